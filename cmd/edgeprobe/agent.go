@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+	"github.com/iaserrat/edgeprobe/internal/config"
+	"github.com/iaserrat/edgeprobe/internal/exporter"
+	"github.com/iaserrat/edgeprobe/internal/heartbeat"
+	"github.com/iaserrat/edgeprobe/internal/hostenv"
+	"github.com/iaserrat/edgeprobe/internal/logging"
+)
+
+// Restart backoff for probe workers. A worker that ran for healthyRun before
+// failing starts over at restartMin.
+const (
+	restartMin = time.Second
+	restartMax = time.Minute
+	healthyRun = 10 * time.Minute
+)
+
+func agentEvent(typ, target string) logging.AgentEvent {
+	return logging.AgentEvent{BaseEvent: logging.BaseEvent{Type: typ, Target: target}}
+}
+
+func emitAgent(logger *logging.Logger, rec logging.Emittable) {
+	if err := logger.Emit(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "log %s: %v\n", rec.Base().Type, err)
+	}
+}
+
+// logStartup writes agent_start and, when the config could be hashed,
+// config_loaded.
+func logStartup(logger *logging.Logger, cfg config.Config, path, configHash string) {
+	emitAgent(logger, &logging.AgentStart{
+		AgentEvent: agentEvent("agent_start", ""),
+		PID:        os.Getpid(),
+		ConfigPath: path,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+	})
+
+	if configHash == "" {
+		return
+	}
+	var targets []string
+	for _, t := range cfg.Targets {
+		targets = append(targets, t.Host)
+	}
+	emitAgent(logger, &logging.ConfigLoaded{
+		AgentEvent: agentEvent("config_loaded", ""),
+		Path:       path,
+		SHA256:     configHash,
+		Targets:    targets,
+	})
+}
+
+// startHeartbeat starts this run's heartbeat and logs an agent_gap when the
+// previous run was last alive more than heartbeat.gap_secs ago, or when its
+// heartbeat file could not be read and the gap is unknown. It returns nil
+// when heartbeats are disabled.
+func startHeartbeat(cfg config.Config, clk clock.Clock, logger *logging.Logger) *heartbeat.Tracker {
+	if cfg.Heartbeat.IntervalSecs <= 0 {
+		return nil
+	}
+	dir := cfg.State.Dir
+	if dir == "" {
+		dir = cfg.Logging.Dir
+	}
+
+	hb, err := heartbeat.Start(filepath.Join(dir, "heartbeat.json"), clk)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "heartbeat: %v\n", err)
+	}
+	prev, ok := hb.Previous()
+	if gap := hb.Gap(); ok && gap >= time.Duration(cfg.Heartbeat.GapSecs)*time.Second {
+		emitAgent(logger, &logging.AgentGap{
+			AgentEvent:  agentEvent("agent_gap", ""),
+			LastAliveTS: &prev.LastAlive,
+			GapMs:       gap.Milliseconds(),
+			PrevStartTS: &prev.StartTS,
+			CleanStop:   prev.Clean,
+		})
+	} else if hb.PreviousLost() {
+		emitAgent(logger, &logging.AgentGap{
+			AgentEvent: agentEvent("agent_gap", ""),
+			Err:        err.Error(),
+		})
+	}
+	return hb
+}
+
+func beat(hb *heartbeat.Tracker, logger *logging.Logger, interval time.Duration) {
+	if err := hb.Beat(); err != nil {
+		fmt.Fprintf(os.Stderr, "heartbeat: %v\n", err)
+	}
+	emitAgent(logger, &logging.Heartbeat{
+		AgentEvent: agentEvent("heartbeat", ""),
+		StartTS:    hb.StartTS(),
+		UptimeMs:   hb.Uptime().Milliseconds(),
+		IntervalMs: interval.Milliseconds(),
+	})
+}
+
+// configSHA256 hashes the config file, or returns "" when it cannot be read.
+func configSHA256(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hash config: %v\n", err)
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// envWatcher writes an environment record whenever the host snapshot
+// changes and points the logger's env_hash at it.
+type envWatcher struct {
+	cfg    hostenv.Config
+	logger *logging.Logger
+	last   hostenv.Snapshot
+	hash   string
+}
+
+func (w *envWatcher) check() {
+	snap := hostenv.Collect(w.cfg)
+	hash := snap.Hash()
+	if hash == w.hash {
+		return
+	}
+
+	rec := &logging.Environment{
+		AgentEvent: agentEvent("environment", ""),
+		Hash:       hash,
+		PrevHash:   w.hash,
+		Interface:  snap.Interface,
+		MAC:        snap.MAC,
+		LocalIPs:   snap.LocalIPs,
+		Gateway:    snap.Gateway,
+		GatewayMAC: snap.GatewayMAC,
+		DNSServers: snap.DNSServers,
+		Kernel:     snap.Kernel,
+		Version:    snap.Version,
+		Commit:     snap.Commit,
+		ConfigHash: snap.ConfigHash,
+		NTPSync:    snap.NTPSync,
+	}
+	if w.hash != "" {
+		rec.Changed = hostenv.Changed(w.last, snap)
+	}
+	emitAgent(w.logger, rec)
+	w.logger.SetEnvHash(hash)
+	w.last, w.hash = snap, hash
+}
+
+// supervise runs work until ctx is cancelled, restarting it with backoff
+// when it fails. Failures are logged as probe_error and restarts as
+// worker_restart, and counted by exp when it is set. A permission error
+// will not go away on a restart, so it stops the daemon through errCh
+// instead.
+func supervise(ctx context.Context, clk clock.Clock, logger *logging.Logger, exp *exporter.Exporter, worker, target string, errCh chan<- error, work func(context.Context) error) {
+	name := worker
+	if target != "" {
+		name += " " + target
+	}
+
+	attempt := 0
+	backoff := time.Duration(0)
+	for {
+		started := clk.Now()
+		err := work(ctx)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		fatal := errors.Is(err, os.ErrPermission)
+		emitAgent(logger, &logging.ProbeError{
+			AgentEvent: agentEvent("probe_error", target),
+			Probe:      worker,
+			Err:        err.Error(),
+			Fatal:      fatal,
+		})
+		if exp != nil {
+			exp.ObserveProbeError(worker, target)
+		}
+		if fatal {
+			reportErr(errCh, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+
+		if clk.Now().Sub(started) >= healthyRun {
+			attempt, backoff = 0, 0
+		}
+		attempt++
+		if backoff == 0 {
+			backoff = restartMin
+		} else {
+			backoff = min(2*backoff, restartMax)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v (restarting in %s)\n", name, err, backoff)
+		emitAgent(logger, &logging.WorkerRestart{
+			AgentEvent: agentEvent("worker_restart", target),
+			Worker:     worker,
+			Attempt:    attempt,
+			BackoffMs:  backoff.Milliseconds(),
+		})
+		if exp != nil {
+			exp.ObserveWorkerRestart(worker, target)
+		}
+
+		timer := clk.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iaserrat/edgeprobe/internal/config"
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+)
+
+var defaultPeakHours = []int{19, 20, 21, 22}
+
+func runCongestion(args []string) error {
+	fs := flag.NewFlagSet("congestion", flag.ContinueOnError)
+	configPath := fs.String("config", "/etc/edgeprobe/config.toml", "Path to config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.State.Dir == "" {
+		return fmt.Errorf("state.dir is not configured, so no baselines are kept")
+	}
+
+	saved, ok, err := metrics.LoadBaselines(filepath.Join(cfg.State.Dir, "baselines.json"))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no baselines learned yet in %s", cfg.State.Dir)
+	}
+
+	peakHours := cfg.Baseline.PeakHours
+	if len(peakHours) == 0 {
+		peakHours = defaultPeakHours
+	}
+	timezone := cfg.Baseline.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+
+	names := make(map[string]string, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		names[t.Host] = t.Name
+	}
+
+	for _, r := range metrics.Congestion(saved, peakHours) {
+		printCongestion(os.Stdout, names[r.Target], r, peakHours, timezone)
+	}
+	return nil
+}
+
+func printCongestion(w io.Writer, name string, r metrics.CongestionReport, peakHours []int, timezone string) {
+	hours := make([]string, 0, len(peakHours))
+	for _, h := range peakHours {
+		hours = append(hours, fmt.Sprintf("%02d", h))
+	}
+
+	fmt.Fprintf(w, "%s (%s), hours in %s\n", r.Target, name, timezone)
+	fmt.Fprintf(w, "  hour  samples  rtt_avg_ms  rtt_p95_ms  loss_pct\n")
+	for h, hs := range r.Hours {
+		if hs.Samples == 0 {
+			continue
+		}
+		mark := " "
+		if h == r.WorstHour {
+			mark = "*"
+		}
+		fmt.Fprintf(w, " %s%02d  %7d  %10.1f  %10.1f  %8.2f\n", mark, h, hs.Samples, hs.RttAvgMs, hs.RttP95Ms, hs.LossPct)
+	}
+	fmt.Fprintf(w, "  peak (%s): rtt_avg %.1f ms, rtt_p95 %.1f ms, loss %.2f%%\n", strings.Join(hours, ","), r.Peak.RttAvgMs, r.Peak.RttP95Ms, r.Peak.LossPct)
+	fmt.Fprintf(w, "  off-peak: rtt_avg %.1f ms, rtt_p95 %.1f ms, loss %.2f%%\n", r.OffPeak.RttAvgMs, r.OffPeak.RttP95Ms, r.OffPeak.LossPct)
+	fmt.Fprintf(w, "  peak-hour rtt increase: %+.1f%%\n\n", r.RttIncreasePct)
+}
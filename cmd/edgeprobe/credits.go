@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/config"
+	"github.com/iaserrat/edgeprobe/internal/credits"
+)
+
+var defaultExcludedDomains = []string{"local_link", "lan_gateway", "destination"}
+
+func runCredits(args []string) error {
+	fs := flag.NewFlagSet("credits", flag.ContinueOnError)
+	configPath := fs.String("config", "/etc/edgeprobe/config.toml", "Path to config file")
+	month := fs.String("month", time.Now().Format("2006-01"), "Month to compute credits for (YYYY-MM)")
+	logDir := fs.String("logs", "", "Log directory (defaults to logging.dir)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Credits.MonthlyFee <= 0 {
+		return fmt.Errorf("credits.monthly_fee is not configured")
+	}
+
+	contract, err := creditsContract(cfg.Credits)
+	if err != nil {
+		return err
+	}
+	monthStart, err := time.ParseInLocation("2006-01", *month, contract.Location)
+	if err != nil {
+		return fmt.Errorf("invalid --month %q: want YYYY-MM", *month)
+	}
+
+	dir := *logDir
+	if dir == "" {
+		dir = cfg.Logging.Dir
+	}
+	outages, err := credits.ReadOutages(dir)
+	if err != nil {
+		return err
+	}
+
+	claim := credits.Compute(contract, credits.Merge(outages), monthStart)
+	printClaim(os.Stdout, contract, claim)
+	return nil
+}
+
+func creditsContract(cfg config.CreditsConfig) (credits.Contract, error) {
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return credits.Contract{}, fmt.Errorf("credits timezone: %w", err)
+	}
+
+	exclude := cfg.ExcludeFaultDomains
+	if exclude == nil {
+		exclude = defaultExcludedDomains
+	}
+
+	contract := credits.Contract{
+		MonthlyFee:      cfg.MonthlyFee,
+		Currency:        cfg.Currency,
+		MinOutage:       time.Duration(cfg.MinOutageMins) * time.Minute,
+		CreditPerOutage: cfg.CreditPerOutage,
+		CreditPerHour:   cfg.CreditPerHour,
+		MaxCreditPct:    cfg.MaxCreditPct,
+		Location:        loc,
+		ExcludeDomains:  exclude,
+	}
+	for _, m := range cfg.Maintenance {
+		var w credits.Window
+		for _, d := range m.Days {
+			day, _ := config.ParseWeekday(d)
+			w.Days = append(w.Days, day)
+		}
+		w.Start, _ = config.ParseClock(m.Start)
+		w.End, _ = config.ParseClock(m.End)
+		contract.Maintenance = append(contract.Maintenance, w)
+	}
+
+	return contract, nil
+}
+
+func printClaim(w io.Writer, contract credits.Contract, claim credits.Claim) {
+	money := func(v float64) string {
+		return strings.TrimSpace(fmt.Sprintf("%.2f %s", v, contract.Currency))
+	}
+
+	fmt.Fprintf(w, "Service credit claim for %s (%s)\n", claim.MonthStart.Format("January 2006"), contract.Location)
+	fmt.Fprintf(w, "Monthly fee: %s\n", money(contract.MonthlyFee))
+	fmt.Fprintf(w, "Qualifying outage: at least %s outside maintenance windows\n\n", contract.MinOutage)
+
+	if len(claim.Items) == 0 {
+		fmt.Fprintln(w, "No outages recorded this month.")
+	}
+	for i, item := range claim.Items {
+		fmt.Fprintf(w, "%d. %s - %s (%s)\n", i+1,
+			item.Start.In(contract.Location).Format("2006-01-02 15:04:05"),
+			item.End.In(contract.Location).Format("2006-01-02 15:04:05"),
+			item.Duration.Round(time.Second))
+		fmt.Fprintf(w, "   targets: %s\n", strings.Join(item.Targets, ", "))
+		fmt.Fprintf(w, "   outage_ids: %s\n", strings.Join(item.OutageIDs, ", "))
+		if item.Maintenance > 0 {
+			fmt.Fprintf(w, "   maintenance excluded: %s\n", item.Maintenance.Round(time.Second))
+		}
+		fmt.Fprintf(w, "   %s\n", item.Note)
+		if item.Qualifies {
+			fmt.Fprintf(w, "   credit: %s\n", money(item.Credit))
+		}
+	}
+
+	fmt.Fprintf(w, "\nSubtotal: %s\n", money(claim.Subtotal))
+	if claim.Cap > 0 && claim.Subtotal > claim.Cap {
+		fmt.Fprintf(w, "Capped at %.0f%% of the monthly fee: %s\n", contract.MaxCreditPct, money(claim.Cap))
+	}
+	fmt.Fprintf(w, "Claimable credit: %s\n", money(claim.Total))
+}
@@ -2,23 +2,60 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/iaserrat/edgeprobe/internal/availability"
+	"github.com/iaserrat/edgeprobe/internal/capture"
+	"github.com/iaserrat/edgeprobe/internal/clock"
 	"github.com/iaserrat/edgeprobe/internal/config"
+	"github.com/iaserrat/edgeprobe/internal/diagnosis"
+	"github.com/iaserrat/edgeprobe/internal/exporter"
+	"github.com/iaserrat/edgeprobe/internal/hostenv"
 	"github.com/iaserrat/edgeprobe/internal/logging"
+	"github.com/iaserrat/edgeprobe/internal/loki"
 	"github.com/iaserrat/edgeprobe/internal/metrics"
+	"github.com/iaserrat/edgeprobe/internal/pipeline"
 	"github.com/iaserrat/edgeprobe/internal/probe"
+	"github.com/iaserrat/edgeprobe/internal/remotewrite"
+	"github.com/iaserrat/edgeprobe/internal/samples"
+	"github.com/iaserrat/edgeprobe/internal/spool"
 	"github.com/iaserrat/edgeprobe/internal/traceroute"
 )
 
 var version = "dev"
 
+const (
+	shutdownTimeout   = 10 * time.Second
+	baselineSaveEvery = 15 * time.Minute
+)
+
+var subcommands = map[string]func([]string) error{
+	"credits":    runCredits,
+	"congestion": runCongestion,
+	"replay":     runReplay,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	configPath := flag.String("config", "/etc/edgeprobe/config.toml", "Path to config file")
 	showVersion := flag.Bool("version", false, "Print version and exit")
 	flag.Parse()
@@ -34,32 +71,238 @@ func main() {
 	}
 }
 
-func run(path string) error {
+func run(path string) (err error) {
 	cfg, err := config.Load(path)
 	if err != nil {
 		return err
 	}
 
-	logger, err := newLogger(cfg)
+	clk := clock.Real{}
+	sinks, err := logSinks(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Loki.Enabled {
+		sink, stopLoki, err := startLoki(cfg, clk)
+		if err != nil {
+			return err
+		}
+		defer stopLoki()
+		sinks = append(sinks, logging.SinkConfig{Name: "loki", Sink: sink, Filter: sinkFilter(cfg.Loki.SinkOptions), QueueSize: cfg.Loki.QueueSize})
+	}
+
+	logger, err := newLogger(cfg, clk, sinks)
 	if err != nil {
 		return err
 	}
 	defer logger.Close()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	started := clk.Now()
+	configHash := configSHA256(path)
+	logStartup(logger, cfg, path, configHash)
+	env := &envWatcher{
+		cfg:    hostenv.Config{Interface: cfg.Diagnosis.Interface, Version: version, ConfigHash: configHash},
+		logger: logger,
+	}
+	env.check()
+	hb := startHeartbeat(cfg, clk, logger)
+	defer func() {
+		if hb != nil {
+			if err := hb.Stop(); err != nil {
+				fmt.Fprintf(os.Stderr, "heartbeat: %v\n", err)
+			}
+		}
+		stop := &logging.AgentStop{
+			AgentEvent: agentEvent("agent_stop", ""),
+			Reason:     "signal",
+			UptimeMs:   clk.Now().Sub(started).Milliseconds(),
+		}
+		if err != nil {
+			stop.Reason = "error"
+			stop.Err = err.Error()
+		}
+		emitAgent(logger, stop)
+	}()
+
+	probeCtx, stopProbes := context.WithCancel(context.Background())
+	defer stopProbes()
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
 
 	pingCh := make(chan probe.PingResult, 256)
 	dnsCh := make(chan probe.DNSResult, 256)
 	eventCh := make(chan metrics.Event, 256)
 	errCh := make(chan error, 1)
 
-	detector := metrics.NewDetector(cfg.Ping.WindowSecs)
+	detector := metrics.NewDetector(detectorConfig(cfg))
+	observations := diagnosis.NewStore()
+	link := diagnosis.NewLinkProbe(cfg.Diagnosis.Interface)
+	link.Observe()
+
+	var exp *exporter.Exporter
+	if cfg.Metrics.Enabled || cfg.RemoteWrite.Enabled {
+		exp = exporter.New(version)
+		exp.WatchLive(func() []metrics.LiveStats { return detector.Live(clk.Now().UTC()) })
+		exp.WatchChannel("ping", func() (int, int) { return len(pingCh), cap(pingCh) })
+		exp.WatchChannel("dns", func() (int, int) { return len(dnsCh), cap(dnsCh) })
+		exp.WatchChannel("events", func() (int, int) { return len(eventCh), cap(eventCh) })
+		exp.WatchChannel("log", func() (int, int) {
+			h := logger.Health()
+			return h.QueueDepth, h.QueueCapacity
+		})
+	}
+	if cfg.Metrics.Enabled {
+		stopServer, err := serveMetrics(cfg.Metrics.Listen, exp)
+		if err != nil {
+			return err
+		}
+		defer stopServer()
+	}
+	if cfg.RemoteWrite.Enabled {
+		stopRemoteWrite, err := startRemoteWrite(cfg, clk, exp)
+		if err != nil {
+			return err
+		}
+		defer stopRemoteWrite()
+	}
+
+	traceCh, traceDone := startTracerouteWorker(workerCtx, cfg, clk, logger, detector, observations, exp)
+	handler := &pipeline.Handler{Logger: logger, Link: link, Observations: observations, Traces: traceCh}
+	if exp != nil {
+		handler.Observer = exp
+	}
+	if cfg.Capture.Enabled {
+		captures := capture.NewManager(captureConfig(cfg, clk), func(r capture.Result) {
+			if err := handler.LogCapture(r); err != nil {
+				fmt.Fprintf(os.Stderr, "log capture: %v\n", err)
+			}
+		})
+		defer captures.Close()
+		handler.Captures = captures
+	}
+
+	var availabilityC <-chan time.Time
+	if cfg.Availability.Enabled {
+		tracker, err := newAvailabilityTracker(cfg)
+		if err != nil {
+			return err
+		}
+		handler.Availability = tracker
+
+		now := clk.Now().UTC()
+		var reports []availability.Report
+		for _, key := range availabilityKeys(cfg) {
+			reports = append(reports, tracker.Track(key, now)...)
+		}
+		if err := handler.LogAvailability(reports); err != nil {
+			fmt.Fprintf(os.Stderr, "log availability: %v\n", err)
+		}
+
+		ticker := clk.NewTicker(time.Minute)
+		defer ticker.Stop()
+		availabilityC = ticker.C()
+	}
+
+	snapshotPath, baselinePath := "", ""
+	if cfg.State.Dir != "" {
+		snapshotPath = filepath.Join(cfg.State.Dir, "detector.json")
+		baselinePath = filepath.Join(cfg.State.Dir, "baselines.json")
+		restoreBaselines(cfg, detector, baselinePath)
+		for _, e := range restoreDetector(cfg, clk, detector, snapshotPath) {
+			if err := handler.Handle(e); err != nil {
+				fmt.Fprintf(os.Stderr, "handle event: %v\n", err)
+			}
+		}
+	}
+	defer saveSnapshot(clk, detector, snapshotPath)
+	defer saveBaselines(clk, detector, baselinePath)
+
+	var sampleLog *samples.Writer
+	if cfg.Samples.Enabled {
+		sampleLog, err = samples.Open(cfg.Logging.Dir, cfg.Samples.MaxMB, cfg.Samples.MaxFiles)
+		if err != nil {
+			return err
+		}
+		defer sampleLog.Close()
+	}
+
+	var probes sync.WaitGroup
+	startPingWorkers(probeCtx, &probes, cfg, clk, logger, exp, pingCh, errCh)
+	startDNSWorker(probeCtx, &probes, cfg, clk, logger, exp, dnsCh, errCh)
+	startAggregator(clk, detector, pingCh, dnsCh, eventCh, aggregatorConfig{
+		snapshotPath:  snapshotPath,
+		snapshotEvery: time.Duration(cfg.State.SnapshotSecs) * time.Second,
+		keepOpen:      snapshotPath != "" && cfg.State.ResumeAfterStop,
+		baselinePath:  baselinePath,
+		rollupEvery:   time.Duration(cfg.Stats.IntervalSecs) * time.Second,
+		samples:       sampleLog,
+		recorder:      flightRecorder(cfg),
+		exporter:      exp,
+	})
+	go func() {
+		probes.Wait()
+		close(pingCh)
+		close(dnsCh)
+	}()
+
+	shutdown := func(cause error) error {
+		stopProbes()
+		deadline := clk.NewTimer(shutdownTimeout)
+		defer deadline.Stop()
+		defer func() {
+			stopWorkers()
+			<-traceDone
+		}()
+
+		timedOut := func() error {
+			if cause != nil {
+				return cause
+			}
+			return fmt.Errorf("shutdown timed out after %s", shutdownTimeout)
+		}
+
+		for {
+			select {
+			case e, ok := <-eventCh:
+				if !ok {
+					close(traceCh)
+					select {
+					case <-traceDone:
+						return cause
+					case <-deadline.C():
+						return timedOut()
+					}
+				}
+				if err := handler.Handle(e); err != nil {
+					fmt.Fprintf(os.Stderr, "handle event: %v\n", err)
+				}
+			case <-deadline.C():
+				return timedOut()
+			}
+		}
+	}
+
+	var healthC <-chan time.Time
+	if cfg.Logging.HealthSecs > 0 {
+		ticker := clk.NewTicker(time.Duration(cfg.Logging.HealthSecs) * time.Second)
+		defer ticker.Stop()
+		healthC = ticker.C()
+	}
 
-	startPingWorkers(ctx, cfg, pingCh, errCh)
-	startDNSWorker(ctx, cfg, dnsCh, errCh)
-	startAggregator(ctx, detector, pingCh, dnsCh, eventCh)
-	traceCh := startTracerouteWorker(ctx, cfg, logger, detector)
+	var heartbeatC <-chan time.Time
+	heartbeatEvery := time.Duration(cfg.Heartbeat.IntervalSecs) * time.Second
+	if hb != nil {
+		ticker := clk.NewTicker(heartbeatEvery)
+		defer ticker.Stop()
+		heartbeatC = ticker.C()
+	}
+
+	var envC <-chan time.Time
+	if cfg.Environment.CheckSecs > 0 {
+		ticker := clk.NewTicker(time.Duration(cfg.Environment.CheckSecs) * time.Second)
+		defer ticker.Stop()
+		envC = ticker.C()
+	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -68,54 +311,118 @@ func run(path string) error {
 	for {
 		select {
 		case <-sigCh:
-			cancel()
-			return nil
+			return shutdown(nil)
 		case err := <-errCh:
-			cancel()
-			return err
+			return shutdown(err)
+		case now := <-availabilityC:
+			if err := handler.LogAvailability(handler.Availability.Advance(now.UTC())); err != nil {
+				fmt.Fprintf(os.Stderr, "log availability: %v\n", err)
+			}
+		case <-envC:
+			env.check()
+		case <-heartbeatC:
+			beat(hb, logger, heartbeatEvery)
+		case <-healthC:
+			if err := logger.EmitHealth(); err != nil {
+				fmt.Fprintf(os.Stderr, "log health: %v\n", err)
+			}
 		case e := <-eventCh:
-			switch evt := e.(type) {
-			case metrics.OutageStart:
-				if err := logDegradation(logger, "degradation_start", evt.Target, evt.OutageID, evt.Reason, evt.LossPct, evt.RttP95Ms, evt.ConsecutiveFailures); err != nil {
-					return err
-				}
-				traceCh <- traceRequest{target: evt.Target, outageID: evt.OutageID}
-			case metrics.OutageEnd:
-				if err := logDegradation(logger, "degradation_end", evt.Target, evt.OutageID, evt.Reason, evt.LossPct, evt.RttP95Ms, evt.ConsecutiveFailures); err != nil {
-					return err
-				}
-			case metrics.OutageSummary:
-				if err := logger.Emit(&logging.OutageSummary{
-					BaseEvent: logging.BaseEvent{
-						Type:     "outage_summary",
-						Target:   evt.Target,
-						OutageID: evt.OutageID,
-					},
-					StartTS:            evt.StartTS,
-					EndTS:              evt.EndTS,
-					DurationMs:         evt.DurationMs,
-					LossPctMax:         evt.LossPctMax,
-					RttP95MaxMs:        evt.RttP95MaxMs,
-					RttAvgMaxMs:        evt.RttAvgMaxMs,
-					ConsecutiveFailMax: evt.ConsecutiveFailMax,
-					PingSent:           evt.PingSent,
-					PingRecv:           evt.PingRecv,
-					DNSErrors:          evt.DNSErrors,
-					TracerouteCount:    evt.TracerouteCount,
-				}); err != nil {
-					return err
-				}
+			// Logging problems are reported, not fatal: probing goes on and
+			// the logger retries its writes.
+			if err := handler.Handle(e); err != nil {
+				fmt.Fprintf(os.Stderr, "handle event: %v\n", err)
 			}
 		}
 	}
 }
 
-type traceRequest struct {
-	target   string
-	outageID string
+func detectorConfig(cfg config.Config) metrics.Config {
+	window := time.Duration(cfg.Ping.WindowSecs) * time.Second
+	var rules []metrics.Rule
+	for _, r := range cfg.Rules {
+		rules = append(rules, metrics.Rule{
+			Name:      r.Name,
+			Metric:    r.Metric,
+			Window:    time.Duration(r.WindowSecs) * time.Second,
+			Threshold: r.Threshold,
+		})
+	}
+
+	loc, err := time.LoadLocation(cfg.Baseline.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	return metrics.Config{
+		Window:   window,
+		Interval: time.Duration(cfg.Ping.IntervalMS) * time.Millisecond,
+		Rules:    rules,
+		Flap: metrics.FlapConfig{
+			MergeGap: time.Duration(cfg.Flap.MergeGapSecs) * time.Second,
+			Changes:  cfg.Flap.Changes,
+			Window:   time.Duration(cfg.Flap.WindowSecs) * time.Second,
+		},
+		Baseline: metrics.BaselineConfig{
+			Sigma:      cfg.Baseline.Sigma,
+			MinSamples: cfg.Baseline.MinSamples,
+			Location:   loc,
+		},
+	}
 }
 
-func newLogger(cfg config.Config) (*logging.Logger, error) {
+func captureConfig(cfg config.Config, clk clock.Clock) capture.Config {
+	iface := cfg.Capture.Interface
+	if iface == "" {
+		iface = cfg.Diagnosis.Interface
+	}
+	dir := cfg.Capture.Dir
+	if dir == "" {
+		dir = filepath.Join(cfg.Logging.Dir, "pcap")
+	}
+
+	return capture.Config{
+		Interface:   iface,
+		Dir:         dir,
+		MaxBytes:    int64(cfg.Capture.MaxMB) << 20,
+		MaxDuration: time.Duration(cfg.Capture.MaxSecs) * time.Second,
+		MaxFiles:    cfg.Capture.MaxFiles,
+		SnapLen:     cfg.Capture.SnapLen,
+		Clock:       clk,
+	}
+}
+
+func flightRecorder(cfg config.Config) *metrics.FlightRecorder {
+	if !cfg.Recorder.Enabled {
+		return nil
+	}
+	return metrics.NewFlightRecorder(metrics.FlightConfig{
+		PreRoll:  time.Duration(cfg.Recorder.PreRollSecs) * time.Second,
+		PostRoll: time.Duration(cfg.Recorder.PostRollSecs) * time.Second,
+	})
+}
+
+func newAvailabilityTracker(cfg config.Config) (*availability.Tracker, error) {
+	loc, err := time.LoadLocation(cfg.Availability.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("availability timezone: %w", err)
+	}
+
+	return availability.New(availability.Config{
+		SLAPct:   cfg.Availability.SLAPct,
+		Periods:  cfg.Availability.Periods,
+		Location: loc,
+	}, filepath.Join(cfg.State.Dir, "availability.json"))
+}
+
+func availabilityKeys(cfg config.Config) []string {
+	keys := []string{availability.AllTargets}
+	for _, t := range cfg.Targets {
+		keys = append(keys, t.Host)
+	}
+	return keys
+}
+
+func newLogger(cfg config.Config, clk clock.Clock, sinks []logging.SinkConfig) (*logging.Logger, error) {
 	hostID, err := os.Hostname()
 	if err != nil || hostID == "" {
 		hostID = "unknown"
@@ -127,59 +434,231 @@ func newLogger(cfg config.Config) (*logging.Logger, error) {
 		ToolName:    "edgeprobe",
 		ToolVersion: version,
 		HostID:      hostID,
+		Clock:       clk,
+		ClockSource: logging.ClockSystem,
+		QueueSize:   cfg.Logging.QueueSize,
+		Sinks:       sinks,
+		AgentFile:   cfg.Logging.AgentFile,
 	})
 }
 
-func startPingWorkers(ctx context.Context, cfg config.Config, pingCh chan<- probe.PingResult, errCh chan<- error) {
+// logSinks builds the local outputs besides the log file. Loki is added by
+// startLoki.
+func logSinks(cfg config.Config) ([]logging.SinkConfig, error) {
+	var sinks []logging.SinkConfig
+	if cfg.Stdout.Enabled {
+		sinks = append(sinks, logging.SinkConfig{
+			Name:      "stdout",
+			Sink:      logging.NewWriterSink(os.Stdout),
+			Filter:    sinkFilter(cfg.Stdout.SinkOptions),
+			QueueSize: cfg.Stdout.QueueSize,
+		})
+	}
+	if cfg.Syslog.Enabled {
+		tag := cfg.Syslog.Tag
+		if tag == "" {
+			tag = "edgeprobe"
+		}
+		sink, err := logging.NewSyslogSink(cfg.Syslog.Network, cfg.Syslog.Address, tag)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, logging.SinkConfig{
+			Name:      "syslog",
+			Sink:      sink,
+			Filter:    sinkFilter(cfg.Syslog.SinkOptions),
+			QueueSize: cfg.Syslog.QueueSize,
+		})
+	}
+	return sinks, nil
+}
+
+func sinkFilter(o config.SinkOptions) logging.Filter {
+	return logging.Filter{Types: o.Types, Targets: o.Targets}
+}
+
+func reportErr(errCh chan<- error, err error) {
+	select {
+	case errCh <- err:
+	default:
+	}
+}
+
+func startPingWorkers(ctx context.Context, wg *sync.WaitGroup, cfg config.Config, clk clock.Clock, logger *logging.Logger, exp *exporter.Exporter, pingCh chan<- probe.PingResult, errCh chan<- error) {
 	pingCfg := probe.PingConfig{
 		Interval: time.Duration(cfg.Ping.IntervalMS) * time.Millisecond,
 		Timeout:  time.Duration(cfg.Ping.TimeoutMS) * time.Millisecond,
+		Clock:    clk,
 	}
 
 	for _, t := range cfg.Targets {
 		target := t.Host
+		wg.Add(1)
 		go func() {
-			if err := probe.RunPing(ctx, target, pingCfg, pingCh); err != nil {
-				errCh <- fmt.Errorf("ping %s: %w", target, err)
-			}
+			defer wg.Done()
+			supervise(ctx, clk, logger, exp, "ping", target, errCh, func(ctx context.Context) error {
+				return probe.RunPing(ctx, target, pingCfg, pingCh)
+			})
 		}()
 	}
 }
 
-func startDNSWorker(ctx context.Context, cfg config.Config, dnsCh chan<- probe.DNSResult, errCh chan<- error) {
+func startDNSWorker(ctx context.Context, wg *sync.WaitGroup, cfg config.Config, clk clock.Clock, logger *logging.Logger, exp *exporter.Exporter, dnsCh chan<- probe.DNSResult, errCh chan<- error) {
 	dnsCfg := probe.DNSConfig{
 		Interval:  time.Duration(cfg.DNS.IntervalMS) * time.Millisecond,
 		Timeout:   time.Duration(cfg.DNS.TimeoutMS) * time.Millisecond,
 		Queries:   cfg.DNS.Queries,
 		Resolvers: cfg.DNS.Resolvers,
+		Clock:     clk,
 	}
+	wg.Add(1)
 	go func() {
-		if err := probe.RunDNS(ctx, dnsCfg, dnsCh); err != nil {
-			errCh <- fmt.Errorf("dns: %w", err)
-		}
+		defer wg.Done()
+		supervise(ctx, clk, logger, exp, "dns", "", errCh, func(ctx context.Context) error {
+			return probe.RunDNS(ctx, dnsCfg, dnsCh)
+		})
 	}()
 }
 
-func startAggregator(ctx context.Context, detector *metrics.Detector, pingCh <-chan probe.PingResult, dnsCh <-chan probe.DNSResult, eventCh chan<- metrics.Event) {
+type aggregatorConfig struct {
+	snapshotPath  string
+	snapshotEvery time.Duration
+	keepOpen      bool
+	baselinePath  string
+	rollupEvery   time.Duration
+	samples       *samples.Writer
+	recorder      *metrics.FlightRecorder
+	exporter      *exporter.Exporter
+}
+
+func startAggregator(clk clock.Clock, detector *metrics.Detector, pingCh <-chan probe.PingResult, dnsCh <-chan probe.DNSResult, eventCh chan<- metrics.Event, cfg aggregatorConfig) {
+	agg := pipeline.NewAggregator(detector, cfg.recorder, func(e metrics.Event) { eventCh <- e })
+
 	go func() {
-		for {
+		defer close(eventCh)
+
+		var snapshotC <-chan time.Time
+		if cfg.snapshotPath != "" {
+			ticker := clk.NewTicker(cfg.snapshotEvery)
+			defer ticker.Stop()
+			snapshotC = ticker.C()
+		}
+
+		var baselineC <-chan time.Time
+		if cfg.baselinePath != "" {
+			ticker := clk.NewTicker(baselineSaveEvery)
+			defer ticker.Stop()
+			baselineC = ticker.C()
+		}
+
+		var rollupC <-chan time.Time
+		if cfg.rollupEvery > 0 {
+			ticker := clk.NewTicker(cfg.rollupEvery)
+			defer ticker.Stop()
+			rollupC = ticker.C()
+		}
+
+		for pingCh != nil || dnsCh != nil {
 			select {
-			case p := <-pingCh:
-				events := detector.ProcessPing(p.Target, p.Time, p.OK, p.RTTMs)
-				for _, e := range events {
-					eventCh <- e
+			case <-snapshotC:
+				saveSnapshot(clk, detector, cfg.snapshotPath)
+			case <-baselineC:
+				saveBaselines(clk, detector, cfg.baselinePath)
+			case <-rollupC:
+				agg.Rollup(clk.Now().UTC())
+			case p, ok := <-pingCh:
+				if !ok {
+					pingCh = nil
+					continue
 				}
-			case d := <-dnsCh:
-				detector.ProcessDNS(d.Time, d.OK)
-			case <-ctx.Done():
-				return
+				if cfg.samples != nil {
+					if err := cfg.samples.Ping(p); err != nil {
+						fmt.Fprintf(os.Stderr, "write sample: %v\n", err)
+					}
+				}
+				if cfg.exporter != nil {
+					cfg.exporter.ObservePing(p)
+				}
+				agg.Ping(p)
+			case d, ok := <-dnsCh:
+				if !ok {
+					dnsCh = nil
+					continue
+				}
+				if cfg.samples != nil {
+					if err := cfg.samples.DNS(d); err != nil {
+						fmt.Fprintf(os.Stderr, "write sample: %v\n", err)
+					}
+				}
+				if cfg.exporter != nil {
+					cfg.exporter.ObserveDNS(d)
+				}
+				agg.DNS(d)
 			}
 		}
+
+		// With state.resume_after_stop, open outages are left for run's last
+		// snapshot and resumed or closed as interrupted on the next start.
+		agg.Finish(clk.Now().UTC(), cfg.rollupEvery > 0, cfg.keepOpen)
 	}()
 }
 
-func startTracerouteWorker(ctx context.Context, cfg config.Config, logger *logging.Logger, detector *metrics.Detector) chan<- traceRequest {
-	reqCh := make(chan traceRequest, 64)
+func restoreDetector(cfg config.Config, clk clock.Clock, detector *metrics.Detector, path string) []metrics.Event {
+	snap, ok, err := metrics.LoadSnapshot(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "detector state not restored: %v\n", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	targets := make([]string, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		targets = append(targets, t.Host)
+	}
+
+	return detector.Restore(snap, targets, clk.Now().UTC(), time.Duration(cfg.State.ResumeMaxGapSecs)*time.Second)
+}
+
+func saveSnapshot(clk clock.Clock, detector *metrics.Detector, path string) {
+	if path == "" {
+		return
+	}
+	if err := metrics.SaveSnapshot(path, detector.Snapshot(clk.Now().UTC())); err != nil {
+		fmt.Fprintf(os.Stderr, "save detector state: %v\n", err)
+	}
+}
+
+func restoreBaselines(cfg config.Config, detector *metrics.Detector, path string) {
+	saved, ok, err := metrics.LoadBaselines(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "baselines not restored: %v\n", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	targets := make([]string, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		targets = append(targets, t.Host)
+	}
+	detector.RestoreBaselines(saved, targets)
+}
+
+func saveBaselines(clk clock.Clock, detector *metrics.Detector, path string) {
+	if path == "" {
+		return
+	}
+	if err := metrics.SaveBaselines(path, detector.Baselines(clk.Now().UTC())); err != nil {
+		fmt.Fprintf(os.Stderr, "save baselines: %v\n", err)
+	}
+}
+
+func startTracerouteWorker(ctx context.Context, cfg config.Config, clk clock.Clock, logger *logging.Logger, detector *metrics.Detector, observations *diagnosis.Store, exp *exporter.Exporter) (chan<- pipeline.TraceRequest, <-chan struct{}) {
+	reqCh := make(chan pipeline.TraceRequest, 64)
+	done := make(chan struct{})
 	trCfg := traceroute.Config{
 		MaxHops: cfg.Traceroute.MaxHops,
 		Timeout: time.Duration(cfg.Traceroute.TimeoutMS) * time.Millisecond,
@@ -188,6 +667,8 @@ func startTracerouteWorker(ctx context.Context, cfg config.Config, logger *loggi
 	traceTimeout := time.Duration(cfg.Traceroute.MaxHops)*trCfg.Timeout + 2*time.Second
 
 	go func() {
+		defer close(done)
+
 		lastTrace := make(map[string]time.Time)
 		lastPath := make(map[string]string)
 		lastHops := make(map[string][]logging.TracerouteHop)
@@ -196,54 +677,181 @@ func startTracerouteWorker(ctx context.Context, cfg config.Config, logger *loggi
 			select {
 			case <-ctx.Done():
 				return
-			case req := <-reqCh:
-				if time.Since(lastTrace[req.target]) < cooldown {
+			case req, ok := <-reqCh:
+				if !ok {
+					return
+				}
+				now := clk.Now()
+				if now.Sub(lastTrace[req.Target]) < cooldown {
 					continue
 				}
-				lastTrace[req.target] = time.Now()
+				lastTrace[req.Target] = now
 
 				trCtx, cancelTrace := context.WithTimeout(ctx, traceTimeout)
-				res := traceroute.Run(trCtx, req.target, trCfg)
+				res := traceroute.Run(trCtx, req.Target, trCfg)
 				cancelTrace()
 
-				detector.RecordTraceroute(req.target, req.outageID)
+				detector.RecordTraceroute(req.Target, req.OutageID)
+				observations.RecordTrace(req.OutageID, res)
 
 				hops := toLogHops(res.Hops)
-				_ = logger.Emit(&logging.TracerouteResult{
+				if err := logger.Emit(&logging.TracerouteResult{
 					BaseEvent: logging.BaseEvent{
-						Type:     "traceroute_result",
-						Target:   req.target,
-						OutageID: req.outageID,
+						Type:       "traceroute_result",
+						Target:     req.Target,
+						OutageID:   req.OutageID,
+						IncidentID: req.IncidentID,
 					},
 					Hops:     hops,
 					PathHash: res.PathHash,
 					Err:      res.Err,
-				})
+				}); err != nil {
+					fmt.Fprintf(os.Stderr, "log traceroute: %v\n", err)
+				}
 
 				if res.Err == "" && res.PathHash != "" {
-					prev := lastPath[req.target]
-					if prev != "" && prev != res.PathHash {
-						_ = logger.Emit(&logging.PathChange{
+					prev := lastPath[req.Target]
+					changed := prev != "" && prev != res.PathHash
+					if exp != nil {
+						exp.ObserveTraceroute(req.Target, changed)
+					}
+					if changed {
+						if err := logger.Emit(&logging.PathChange{
 							BaseEvent: logging.BaseEvent{
-								Type:     "path_change",
-								Target:   req.target,
-								OutageID: req.outageID,
+								Type:       "path_change",
+								Target:     req.Target,
+								OutageID:   req.OutageID,
+								IncidentID: req.IncidentID,
 							},
 							PrevPathHash: prev,
 							NewPathHash:  res.PathHash,
-							PrevHops:     lastHops[req.target],
+							PrevHops:     lastHops[req.Target],
 							NewHops:      hops,
-						})
+						}); err != nil {
+							fmt.Fprintf(os.Stderr, "log path change: %v\n", err)
+						}
 					}
 
-					lastPath[req.target] = res.PathHash
-					lastHops[req.target] = hops
+					lastPath[req.Target] = res.PathHash
+					lastHops[req.Target] = hops
 				}
 			}
 		}
 	}()
 
-	return reqCh
+	return reqCh, done
+}
+
+// serveMetrics listens before returning so a bad address fails startup.
+func serveMetrics(addr string, exp *exporter.Exporter) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exp)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "metrics server: %v\n", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}, nil
+}
+
+// startRemoteWrite pushes the exporter's series until the returned func is
+// called, which waits for the pusher to write its last scrape to the WAL.
+func startRemoteWrite(cfg config.Config, clk clock.Clock, exp *exporter.Exporter) (func(), error) {
+	dir := cfg.RemoteWrite.WALDir
+	if dir == "" {
+		dir = filepath.Join(cfg.Logging.Dir, "remote_write")
+	}
+	wal, err := spool.Open(dir, int64(cfg.RemoteWrite.WALMaxMB)<<20)
+	if err != nil {
+		return nil, fmt.Errorf("remote_write wal: %w", err)
+	}
+
+	labels := map[string]string{"job": "edgeprobe"}
+	if host, err := os.Hostname(); err == nil {
+		labels["instance"] = host
+	}
+	for k, v := range cfg.RemoteWrite.Labels {
+		labels[k] = v
+	}
+
+	client := remotewrite.New(remotewrite.Config{
+		URL:        cfg.RemoteWrite.URL,
+		Username:   cfg.RemoteWrite.Username,
+		Password:   cfg.RemoteWrite.Password,
+		Labels:     labels,
+		Interval:   time.Duration(cfg.RemoteWrite.IntervalSecs) * time.Second,
+		Timeout:    time.Duration(cfg.RemoteWrite.TimeoutSecs) * time.Second,
+		BatchSize:  cfg.RemoteWrite.BatchSize,
+		MinBackoff: time.Second,
+		MaxBackoff: 5 * time.Minute,
+		UserAgent:  "edgeprobe/" + version,
+		Clock:      clk,
+	}, wal, exp.Samples)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+		wal.Close()
+	}, nil
+}
+
+// startLoki runs the Loki sink until the returned func is called. Lines
+// still spooled then are pushed after the next start.
+func startLoki(cfg config.Config, clk clock.Clock) (*loki.Sink, func(), error) {
+	dir := cfg.Loki.SpoolDir
+	if dir == "" {
+		dir = filepath.Join(cfg.Logging.Dir, "loki")
+	}
+	sp, err := spool.Open(dir, int64(cfg.Loki.SpoolMaxMB)<<20)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loki spool: %w", err)
+	}
+
+	sink := loki.New(loki.Config{
+		URL:        cfg.Loki.URL,
+		Username:   cfg.Loki.Username,
+		Password:   cfg.Loki.Password,
+		TenantID:   cfg.Loki.TenantID,
+		Tags:       cfg.Loki.Tags,
+		BatchSize:  cfg.Loki.BatchSize,
+		BatchWait:  time.Duration(cfg.Loki.BatchWaitSecs) * time.Second,
+		Timeout:    time.Duration(cfg.Loki.TimeoutSecs) * time.Second,
+		MinBackoff: time.Second,
+		MaxBackoff: 5 * time.Minute,
+		UserAgent:  "edgeprobe/" + version,
+		Clock:      clk,
+	}, sp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sink.Run(ctx)
+	}()
+
+	return sink, func() {
+		cancel()
+		<-done
+		sp.Close()
+	}, nil
 }
 
 func toLogHops(hops []traceroute.Hop) []logging.TracerouteHop {
@@ -258,17 +866,3 @@ func toLogHops(hops []traceroute.Hop) []logging.TracerouteHop {
 	}
 	return out
 }
-
-func logDegradation(logger *logging.Logger, recordType string, target string, outageID string, reason string, lossPct float64, rttP95 float64, consecutiveFailures int) error {
-	return logger.Emit(&logging.DegradationRecord{
-		BaseEvent: logging.BaseEvent{
-			Type:     recordType,
-			Target:   target,
-			OutageID: outageID,
-		},
-		Reason:              reason,
-		LossPct:             lossPct,
-		RttP95Ms:            rttP95,
-		ConsecutiveFailures: consecutiveFailures,
-	})
-}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/config"
+	"github.com/iaserrat/edgeprobe/internal/credits"
+	"github.com/iaserrat/edgeprobe/internal/replay"
+	"github.com/iaserrat/edgeprobe/internal/samples"
+)
+
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configPath := fs.String("config", "/etc/edgeprobe/config.toml", "Path to config file")
+	whatIfPath := fs.String("what-if", "", "Config file whose settings override --config for the replay")
+	from := fs.String("from", "", "Replay samples from this time (YYYY-MM-DD or RFC3339, UTC)")
+	to := fs.String("to", "", "Replay samples before this time (YYYY-MM-DD or RFC3339, UTC)")
+	logDir := fs.String("logs", "", "Log directory (defaults to logging.dir)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	whatIf := cfg
+	if *whatIfPath != "" {
+		if whatIf, err = config.LoadWhatIf(cfg, *whatIfPath); err != nil {
+			return err
+		}
+	}
+
+	fromTS, err := parseBound("--from", *from)
+	if err != nil {
+		return err
+	}
+	toTS, err := parseBound("--to", *to)
+	if err != nil {
+		return err
+	}
+
+	dir := *logDir
+	if dir == "" {
+		dir = cfg.Logging.Dir
+	}
+	pings, dns, err := samples.Read(dir, fromTS, toTS)
+	if err != nil {
+		return err
+	}
+	if len(pings) == 0 {
+		return fmt.Errorf("no ping samples in range")
+	}
+	first, last := pings[0].Time, pings[len(pings)-1].Time
+
+	logged, err := credits.ReadOutages(dir)
+	if err != nil {
+		return err
+	}
+	var actual []replay.Span
+	for _, o := range logged {
+		if o.End.Before(first) || o.Start.After(last) {
+			continue
+		}
+		actual = append(actual, replay.Span{Target: o.Targets[0], OutageID: o.OutageIDs[0], Start: o.Start, End: o.End})
+	}
+
+	outages := replay.Outages(replay.Input{Pings: pings, DNS: dns}, detectorConfig(whatIf))
+	changes := replay.Diff(actual, replay.SummarySpans(outages))
+
+	names := make(map[string]string, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		names[t.Host] = t.Name
+	}
+
+	fmt.Fprintf(os.Stdout, "Replayed %d pings and %d DNS checks from %s to %s UTC\n",
+		len(pings), len(dns), first.UTC().Format("2006-01-02 15:04:05"), last.UTC().Format("2006-01-02 15:04:05"))
+	if *whatIfPath != "" {
+		fmt.Fprintf(os.Stdout, "What-if settings: %s\n", *whatIfPath)
+	}
+	fmt.Fprintln(os.Stdout)
+	printChanges(os.Stdout, names, changes)
+	return nil
+}
+
+func parseBound(name, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, s); err == nil {
+		return ts, nil
+	}
+	ts, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q: want YYYY-MM-DD or RFC3339", name, s)
+	}
+	return ts, nil
+}
+
+func printChanges(w io.Writer, names map[string]string, changes []replay.Change) {
+	span := func(s *replay.Span) string {
+		if s == nil {
+			return "-"
+		}
+		d := s.Duration().Round(time.Second).String()
+		if s.Open {
+			d += "+"
+		}
+		return d
+	}
+
+	var actualN, whatIfN, opened, dropped, changed int
+	var actualDur, whatIfDur time.Duration
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No outages logged or replayed.")
+	}
+	for _, c := range changes {
+		note := "same"
+		switch {
+		case c.Actual == nil:
+			note = "new"
+			opened++
+		case c.WhatIf == nil:
+			note = "gone"
+			dropped++
+		case c.Delta().Round(time.Second) != 0:
+			note = c.Delta().Round(time.Second).String()
+			if c.Delta() > 0 {
+				note = "+" + note
+			}
+			changed++
+		}
+		start := c.WhatIf
+		if c.Actual != nil {
+			actualN++
+			actualDur += c.Actual.Duration()
+			start = c.Actual
+		}
+		if c.WhatIf != nil {
+			whatIfN++
+			whatIfDur += c.WhatIf.Duration()
+		}
+
+		fmt.Fprintf(w, "%-16s %-12s %s  actual %-8s what-if %-8s %s\n", c.Target, names[c.Target],
+			start.Start.UTC().Format("2006-01-02 15:04:05"), span(c.Actual), span(c.WhatIf), note)
+	}
+
+	fmt.Fprintf(w, "\nActual: %d outages, %s. What-if: %d outages, %s.\n",
+		actualN, actualDur.Round(time.Second), whatIfN, whatIfDur.Round(time.Second))
+	fmt.Fprintf(w, "%d would open, %d would not, %d would change length.\n", opened, dropped, changed)
+}
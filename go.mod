@@ -1,11 +1,14 @@
 module github.com/iaserrat/edgeprobe
 
 go 1.23
+
 toolchain go1.23.5
 
 require (
 	github.com/BurntSushi/toml v1.4.0
+	github.com/golang/snappy v1.0.0
 	github.com/miekg/dns v1.1.59
 	golang.org/x/net v0.35.0
+	golang.org/x/sys v0.30.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
@@ -0,0 +1,301 @@
+package availability
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/statefile"
+)
+
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// AllTargets is the key used for downtime that is attributed to incidents
+// rather than to a single target.
+const AllTargets = "*"
+
+const stateVersion = 1
+
+type Config struct {
+	SLAPct   float64
+	Periods  []string
+	Location *time.Location
+}
+
+type Report struct {
+	Key                    string
+	Period                 string
+	StartTS                time.Time
+	EndTS                  time.Time
+	AsOfTS                 time.Time
+	DowntimeMs             int64
+	AvailabilityPct        float64
+	SLAPct                 float64
+	AllowedDowntimeMs      int64
+	ErrorBudgetRemainingMs int64
+	Outages                int
+	SLAMet                 bool
+	Final                  bool
+}
+
+type periodState struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DowntimeMs int64     `json:"downtime_ms"`
+	Outages    int       `json:"outages"`
+}
+
+type keyState struct {
+	Open    *time.Time              `json:"open,omitempty"`
+	Periods map[string]*periodState `json:"periods"`
+}
+
+type persisted struct {
+	Version int                  `json:"version"`
+	Keys    map[string]*keyState `json:"keys"`
+}
+
+type Tracker struct {
+	mu   sync.Mutex
+	cfg  Config
+	path string
+	keys map[string]*keyState
+}
+
+// New creates a tracker backed by the file at path, loading any state a
+// previous run left behind. An empty path keeps everything in memory.
+func New(cfg Config, path string) (*Tracker, error) {
+	if cfg.Location == nil {
+		cfg.Location = time.UTC
+	}
+	if len(cfg.Periods) == 0 {
+		cfg.Periods = []string{PeriodDay, PeriodWeek, PeriodMonth}
+	}
+
+	t := &Tracker{cfg: cfg, path: path, keys: make(map[string]*keyState)}
+	if path == "" {
+		return t, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read availability state: %w", err)
+	}
+
+	var p persisted
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("decode availability state: %w", err)
+	}
+	if p.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported availability state version %d", p.Version)
+	}
+	for key, ks := range p.Keys {
+		if ks.Periods == nil {
+			ks.Periods = make(map[string]*periodState)
+		}
+		t.keys[key] = ks
+	}
+
+	return t, nil
+}
+
+// Track registers key so it receives reports even when it never has an
+// outage. Periods that ended while edgeprobe was not running are reported.
+func (t *Tracker) Track(key string, ts time.Time) []Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stateFor(key, ts)
+	return t.advance(ts)
+}
+
+func (t *Tracker) Start(key string, impactStart time.Time) []Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ks := t.stateFor(key, impactStart)
+	reports := t.advance(impactStart)
+	start := impactStart
+	ks.Open = &start
+
+	return reports
+}
+
+// End records the downtime between impactStart and impactEnd and returns the
+// final reports of any periods that closed plus a running report for each
+// period the outage touched.
+func (t *Tracker) End(key string, impactStart, impactEnd time.Time) []Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ks := t.stateFor(key, impactEnd)
+	reports := t.advance(impactEnd)
+	ks.Open = nil
+
+	for _, period := range t.cfg.Periods {
+		p := ks.Periods[period]
+		if ms := overlapMs(impactStart, impactEnd, p.Start, p.End); ms > 0 {
+			p.DowntimeMs += ms
+			p.Outages++
+		}
+		reports = append(reports, t.report(key, period, p, impactEnd, false))
+	}
+
+	return reports
+}
+
+// Advance closes every period that ended at or before now and returns their
+// final reports. Open outages count against a period up to its boundary.
+func (t *Tracker) Advance(now time.Time) []Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.advance(now)
+}
+
+func (t *Tracker) Save() error {
+	if t.path == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	b, err := json.Marshal(persisted{Version: stateVersion, Keys: t.keys})
+	t.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("marshal availability state: %w", err)
+	}
+
+	return statefile.Write(t.path, b, "availability state", true)
+}
+
+func (t *Tracker) stateFor(key string, ts time.Time) *keyState {
+	ks, ok := t.keys[key]
+	if !ok {
+		ks = &keyState{Periods: make(map[string]*periodState)}
+		t.keys[key] = ks
+	}
+	for _, period := range t.cfg.Periods {
+		if ks.Periods[period] == nil {
+			start := periodStart(period, ts.In(t.cfg.Location))
+			ks.Periods[period] = &periodState{Start: start, End: nextPeriod(period, start)}
+		}
+	}
+
+	return ks
+}
+
+func (t *Tracker) advance(now time.Time) []Report {
+	keys := make([]string, 0, len(t.keys))
+	for key := range t.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var reports []Report
+	for _, key := range keys {
+		ks := t.keys[key]
+		for _, period := range t.cfg.Periods {
+			p := ks.Periods[period]
+			for p != nil && !now.Before(p.End) {
+				if ks.Open != nil {
+					if ms := overlapMs(*ks.Open, p.End, p.Start, p.End); ms > 0 {
+						p.DowntimeMs += ms
+						p.Outages++
+					}
+				}
+				reports = append(reports, t.report(key, period, p, p.End, true))
+
+				start := p.End.In(t.cfg.Location)
+				p = &periodState{Start: start, End: nextPeriod(period, start)}
+				ks.Periods[period] = p
+			}
+		}
+	}
+
+	return reports
+}
+
+func (t *Tracker) report(key, period string, p *periodState, asOf time.Time, final bool) Report {
+	length := p.End.Sub(p.Start)
+	elapsed := asOf.Sub(p.Start)
+	if elapsed > length {
+		elapsed = length
+	}
+
+	availability := 100.0
+	if elapsed > 0 {
+		availability = 100.0 * (1 - float64(p.DowntimeMs)/float64(elapsed.Milliseconds()))
+		if availability < 0 {
+			availability = 0
+		}
+	}
+
+	r := Report{
+		Key:             key,
+		Period:          period,
+		StartTS:         p.Start,
+		EndTS:           p.End,
+		AsOfTS:          asOf,
+		DowntimeMs:      p.DowntimeMs,
+		AvailabilityPct: availability,
+		Outages:         p.Outages,
+		Final:           final,
+	}
+	if t.cfg.SLAPct > 0 {
+		r.SLAPct = t.cfg.SLAPct
+		r.AllowedDowntimeMs = int64(float64(length.Milliseconds()) * (100 - t.cfg.SLAPct) / 100)
+		r.ErrorBudgetRemainingMs = r.AllowedDowntimeMs - p.DowntimeMs
+		r.SLAMet = r.ErrorBudgetRemainingMs >= 0
+	}
+
+	return r
+}
+
+func periodStart(period string, ts time.Time) time.Time {
+	y, m, d := ts.Date()
+	switch period {
+	case PeriodWeek:
+		offset := (int(ts.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, ts.Location())
+	case PeriodMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, ts.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, ts.Location())
+	}
+}
+
+func nextPeriod(period string, start time.Time) time.Time {
+	switch period {
+	case PeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case PeriodMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+func overlapMs(from, to, start, end time.Time) int64 {
+	if from.Before(start) {
+		from = start
+	}
+	if to.After(end) {
+		to = end
+	}
+	if !to.After(from) {
+		return 0
+	}
+
+	return to.Sub(from).Milliseconds()
+}
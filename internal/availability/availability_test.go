@@ -0,0 +1,86 @@
+package availability
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOutageSplitsAcrossDayBoundary(t *testing.T) {
+	cfg := Config{SLAPct: 99.5, Periods: []string{PeriodDay}}
+	tr, err := New(cfg, "")
+	if err != nil {
+		t.Fatalf("new tracker: %v", err)
+	}
+
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	tr.Track("a", day.Add(time.Hour))
+
+	impactStart := day.Add(24*time.Hour - 10*time.Minute)
+	if reports := tr.Start("a", impactStart); len(reports) != 0 {
+		t.Fatalf("unexpected reports on start: %+v", reports)
+	}
+
+	final := tr.Advance(day.Add(24 * time.Hour))
+	if len(final) != 1 || !final[0].Final {
+		t.Fatalf("expected one final report, got %+v", final)
+	}
+	if final[0].DowntimeMs != (10 * time.Minute).Milliseconds() {
+		t.Fatalf("final downtime = %d, want 10m", final[0].DowntimeMs)
+	}
+	allowed := int64(float64((24 * time.Hour).Milliseconds()) * 0.005)
+	if final[0].AllowedDowntimeMs != allowed || final[0].SLAMet {
+		t.Fatalf("unexpected budget: %+v", final[0])
+	}
+
+	running := tr.End("a", impactStart, day.Add(24*time.Hour+5*time.Minute))
+	if len(running) != 1 || running[0].Final {
+		t.Fatalf("expected one running report, got %+v", running)
+	}
+	r := running[0]
+	if !r.StartTS.Equal(day.Add(24*time.Hour)) || r.DowntimeMs != (5*time.Minute).Milliseconds() || r.Outages != 1 {
+		t.Fatalf("unexpected running report: %+v", r)
+	}
+	if r.ErrorBudgetRemainingMs != allowed-r.DowntimeMs {
+		t.Fatalf("error budget = %d, want %d", r.ErrorBudgetRemainingMs, allowed-r.DowntimeMs)
+	}
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	cfg := Config{Periods: []string{PeriodMonth}}
+	path := filepath.Join(t.TempDir(), "availability.json")
+	month := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tr, err := New(cfg, path)
+	if err != nil {
+		t.Fatalf("new tracker: %v", err)
+	}
+	tr.End("a", month.Add(time.Hour), month.Add(time.Hour+time.Minute))
+	if err := tr.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	restored, err := New(cfg, path)
+	if err != nil {
+		t.Fatalf("reload tracker: %v", err)
+	}
+	reports := restored.Advance(month.AddDate(0, 1, 0).Add(time.Hour))
+	if len(reports) != 1 {
+		t.Fatalf("expected one final report, got %+v", reports)
+	}
+	r := reports[0]
+	if r.DowntimeMs != time.Minute.Milliseconds() || r.Outages != 1 || !r.EndTS.Equal(month.AddDate(0, 1, 0)) {
+		t.Fatalf("unexpected report after restart: %+v", r)
+	}
+	want := 100 * (1 - float64(time.Minute)/float64(28*24*time.Hour))
+	if diff := r.AvailabilityPct - want; diff > 1e-9 || diff < -1e-9 {
+		t.Fatalf("availability = %v, want %v", r.AvailabilityPct, want)
+	}
+}
+
+func TestWeekStartsMonday(t *testing.T) {
+	ts := time.Date(2026, 10, 18, 15, 0, 0, 0, time.UTC) // Sunday
+	if got := periodStart(PeriodWeek, ts); !got.Equal(time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("week start = %v", got)
+	}
+}
@@ -0,0 +1,329 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+	"github.com/iaserrat/edgeprobe/internal/diagnosis"
+)
+
+// Reasons a capture stopped.
+const (
+	StopOutageEnd = "outage_end"
+	StopSizeCap   = "size_cap"
+	StopTimeCap   = "time_cap"
+	StopShutdown  = "shutdown"
+	StopError     = "error"
+)
+
+// Config bounds every capture. Interface empty means the interface of the
+// default route when the capture starts.
+type Config struct {
+	Interface   string
+	Dir         string
+	MaxBytes    int64
+	MaxDuration time.Duration
+	MaxFiles    int
+	SnapLen     int
+	Clock       clock.Clock
+}
+
+// Result describes a finished capture file.
+type Result struct {
+	Target     string
+	OutageID   string
+	IncidentID string
+	Path       string
+	Interface  string
+	Filter     string
+	Packets    int
+	Bytes      int64
+	StartTS    time.Time
+	EndTS      time.Time
+	StopReason string
+	Err        string
+}
+
+// source yields captured packets. Read returns the bytes kept and the length
+// on the wire; n == 0 with a nil error means nothing arrived in time and the
+// caller should check whether to stop.
+type source interface {
+	Read(buf []byte) (n, origLen int, err error)
+	Close() error
+}
+
+const resolveTimeout = time.Second
+
+// openSource is swapped out in tests.
+var openSource = openPacketSource
+
+// Manager runs at most one capture per outage. saved is called from the
+// capture's goroutine once its file is closed.
+type Manager struct {
+	cfg   Config
+	clk   clock.Clock
+	saved func(Result)
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	wg       sync.WaitGroup
+}
+
+type session struct {
+	stop   chan string
+	result Result
+}
+
+func NewManager(cfg Config, saved func(Result)) *Manager {
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
+	return &Manager{cfg: cfg, clk: clk, saved: saved, sessions: make(map[string]*session)}
+}
+
+// Start begins capturing for an outage on target. It does not block: the
+// interface and target are looked up and the socket opened on the capture's
+// goroutine, and a capture that cannot start is reported on stderr.
+func (m *Manager) Start(target, outageID, incidentID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, running := m.sessions[outageID]; running {
+		return
+	}
+
+	s := &session{
+		stop: make(chan string, 1),
+		result: Result{
+			Target:     target,
+			OutageID:   outageID,
+			IncidentID: incidentID,
+			Path:       filepath.Join(m.cfg.Dir, outageID+".pcapng"),
+			StartTS:    m.clk.Now().UTC(),
+		},
+	}
+	m.sessions[outageID] = s
+	m.wg.Add(1)
+	go m.run(s)
+}
+
+// open sets up a session's source and file.
+func (m *Manager) open(res *Result) (source, *os.File, error) {
+	iface := m.cfg.Interface
+	if iface == "" {
+		routeIface, _, err := diagnosis.DefaultRoute()
+		if err != nil {
+			return nil, nil, fmt.Errorf("capture interface: %w", err)
+		}
+		iface = routeIface
+	}
+
+	filter, desc, err := Filter(resolveTarget(res.Target), m.cfg.SnapLen)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	src, err := openSource(iface, filter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open capture on %s: %w", iface, err)
+	}
+
+	if err := os.MkdirAll(m.cfg.Dir, 0o755); err != nil {
+		src.Close()
+		return nil, nil, fmt.Errorf("create capture dir: %w", err)
+	}
+	f, err := os.Create(res.Path)
+	if err != nil {
+		src.Close()
+		return nil, nil, fmt.Errorf("create capture file: %w", err)
+	}
+
+	res.Interface = iface
+	res.Filter = desc
+	return src, f, nil
+}
+
+// resolveTarget gives up quickly: the target may not resolve while the link
+// is down, and the ICMP part of the filter still catches the probes.
+func resolveTarget(target string) net.IP {
+	if ip := net.ParseIP(target); ip != nil {
+		return ip
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", target)
+	if err != nil || len(ips) == 0 {
+		return nil
+	}
+	return ips[0]
+}
+
+// Stop ends the capture for an outage, if one is running.
+func (m *Manager) Stop(outageID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[outageID]; ok {
+		s.signal(StopOutageEnd)
+	}
+}
+
+// Close stops every running capture and waits for their files to be saved.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	for _, s := range m.sessions {
+		s.signal(StopShutdown)
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+}
+
+func (s *session) signal(reason string) {
+	select {
+	case s.stop <- reason:
+	default:
+	}
+}
+
+func (m *Manager) run(s *session) {
+	defer m.wg.Done()
+
+	res := &s.result
+	src, f, err := m.open(res)
+	if err != nil {
+		// A capture is evidence on top of the outage records, so failing to
+		// start one is only reported.
+		fmt.Fprintf(os.Stderr, "packet capture for %s: %v\n", res.OutageID, err)
+		m.mu.Lock()
+		delete(m.sessions, res.OutageID)
+		m.mu.Unlock()
+		return
+	}
+
+	reason, err := m.capture(s, src, f)
+	src.Close()
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	res.EndTS = m.clk.Now().UTC()
+	res.StopReason = reason
+	if err != nil {
+		res.StopReason = StopError
+		res.Err = err.Error()
+	}
+
+	m.mu.Lock()
+	delete(m.sessions, res.OutageID)
+	m.mu.Unlock()
+
+	m.prune()
+	if m.saved != nil {
+		m.saved(*res)
+	}
+}
+
+func (m *Manager) capture(s *session, src source, f *os.File) (string, error) {
+	res := &s.result
+	bw := bufio.NewWriter(f)
+	defer bw.Flush()
+
+	w, err := newPcapngWriter(bw, res.Interface, LinkTypeIPv4, m.cfg.SnapLen)
+	if err != nil {
+		return "", err
+	}
+
+	var deadline <-chan time.Time
+	if m.cfg.MaxDuration > 0 {
+		timer := m.clk.NewTimer(m.cfg.MaxDuration)
+		defer timer.Stop()
+		deadline = timer.C()
+	}
+
+	buf := make([]byte, m.cfg.SnapLen)
+	for {
+		select {
+		case reason := <-s.stop:
+			return reason, bw.Flush()
+		case <-deadline:
+			return StopTimeCap, bw.Flush()
+		default:
+		}
+
+		n, origLen, err := src.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			continue
+		}
+
+		// Packet times are wall-clock like the RTTs the probes measure.
+		written, err := w.WritePacket(time.Now(), buf[:n], origLen)
+		if err != nil {
+			return "", err
+		}
+		res.Packets++
+		res.Bytes += int64(written)
+		if m.cfg.MaxBytes > 0 && res.Bytes >= m.cfg.MaxBytes {
+			return StopSizeCap, bw.Flush()
+		}
+	}
+}
+
+// prune keeps the newest MaxFiles captures in the directory.
+func (m *Manager) prune() {
+	if m.cfg.MaxFiles <= 0 {
+		return
+	}
+
+	paths, err := filepath.Glob(filepath.Join(m.cfg.Dir, "*.pcapng"))
+	if err != nil || len(paths) <= m.cfg.MaxFiles {
+		return
+	}
+
+	type file struct {
+		path string
+		mod  time.Time
+	}
+	files := make([]file, 0, len(paths))
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			files = append(files, file{path: p, mod: info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.After(files[j].mod) })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range files[min(m.cfg.MaxFiles, len(files)):] {
+		if m.running(f.path) {
+			continue
+		}
+		os.Remove(f.path)
+	}
+}
+
+func (m *Manager) running(path string) bool {
+	for _, s := range m.sessions {
+		if s.result.Path == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// readTimeout bounds each read so a quiet capture still notices Stop.
+const readTimeout = 250 * time.Millisecond
+
+// packetSource is a cooked (SOCK_DGRAM) AF_PACKET socket bound to one
+// interface and to IPv4, so packets start at the IP header whatever the link
+// type, in both directions.
+type packetSource struct {
+	fd int
+}
+
+func openPacketSource(iface string, filter []bpf.RawInstruction) (source, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	proto := htons(unix.ETH_P_IP)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		if errors.Is(err, unix.EPERM) {
+			return nil, fmt.Errorf("packet capture requires root or CAP_NET_RAW: %w", err)
+		}
+		return nil, fmt.Errorf("packet socket: %w", err)
+	}
+
+	// Attach the filter before binding so no unfiltered packet is queued.
+	ins := make([]unix.SockFilter, len(filter))
+	for i, r := range filter {
+		ins[i] = unix.SockFilter{Code: r.Op, Jt: r.Jt, Jf: r.Jf, K: r.K}
+	}
+	prog := unix.SockFprog{Len: uint16(len(ins)), Filter: &ins[0]}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("attach capture filter: %w", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("bind packet socket: %w", err)
+	}
+
+	tv := unix.NsecToTimeval(readTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("set capture timeout: %w", err)
+	}
+
+	return &packetSource{fd: fd}, nil
+}
+
+func (s *packetSource) Read(buf []byte) (int, int, error) {
+	// MSG_TRUNC makes the kernel report the full length of a packet that
+	// did not fit in buf.
+	n, _, err := unix.Recvfrom(s.fd, buf, unix.MSG_TRUNC)
+	if err != nil {
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			return 0, 0, nil
+		}
+		return 0, 0, fmt.Errorf("read packet: %w", err)
+	}
+
+	return min(n, len(buf)), n, nil
+}
+
+func (s *packetSource) Close() error {
+	return unix.Close(s.fd)
+}
+
+func htons(v uint16) uint16 {
+	b := binary.BigEndian.AppendUint16(nil, v)
+	return binary.NativeEndian.Uint16(b)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package capture
+
+import (
+	"errors"
+
+	"golang.org/x/net/bpf"
+)
+
+func openPacketSource(iface string, filter []bpf.RawInstruction) (source, error) {
+	return nil, errors.New("packet capture is only supported on Linux")
+}
@@ -0,0 +1,236 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/bpf"
+)
+
+func ipv4Packet(proto byte, src, dst string) []byte {
+	p := make([]byte, 28)
+	p[0] = 0x45
+	p[9] = proto
+	copy(p[12:16], net.ParseIP(src).To4())
+	copy(p[16:20], net.ParseIP(dst).To4())
+	return p
+}
+
+func TestFilter(t *testing.T) {
+	raw, desc, err := Filter(net.ParseIP("1.1.1.1"), 128)
+	if err != nil {
+		t.Fatalf("filter: %v", err)
+	}
+	if desc != "icmp or host 1.1.1.1" {
+		t.Fatalf("description = %q", desc)
+	}
+
+	prog, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatalf("filter does not disassemble")
+	}
+	vm, err := bpf.NewVM(prog)
+	if err != nil {
+		t.Fatalf("vm: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		packet []byte
+		want   int
+	}{
+		{"icmp anywhere", ipv4Packet(1, "10.0.0.2", "9.9.9.9"), 128},
+		{"tcp to target", ipv4Packet(6, "10.0.0.2", "1.1.1.1"), 128},
+		{"udp from target", ipv4Packet(17, "1.1.1.1", "10.0.0.2"), 128},
+		{"other traffic", ipv4Packet(6, "10.0.0.2", "8.8.8.8"), 0},
+	}
+	for _, tt := range tests {
+		got, err := vm.Run(tt.packet)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: filter returned %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	raw, desc, err = Filter(nil, 128)
+	if err != nil || desc != "icmp" {
+		t.Fatalf("icmp-only filter = %q, %v", desc, err)
+	}
+	prog, _ = bpf.Disassemble(raw)
+	vm, _ = bpf.NewVM(prog)
+	if got, _ := vm.Run(ipv4Packet(6, "10.0.0.2", "1.1.1.1")); got != 0 {
+		t.Fatalf("icmp-only filter kept tcp")
+	}
+}
+
+type block struct {
+	kind uint32
+	body []byte
+}
+
+func readBlocks(t *testing.T, b []byte) []block {
+	t.Helper()
+	var blocks []block
+	for len(b) > 0 {
+		kind := binary.LittleEndian.Uint32(b)
+		total := binary.LittleEndian.Uint32(b[4:])
+		if total%4 != 0 || int(total) > len(b) || binary.LittleEndian.Uint32(b[total-4:]) != total {
+			t.Fatalf("malformed block of type %#x", kind)
+		}
+		blocks = append(blocks, block{kind: kind, body: b[8 : total-4]})
+		b = b[total:]
+	}
+	return blocks
+}
+
+func TestPcapngLayout(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newPcapngWriter(&buf, "eth0", LinkTypeIPv4, 96)
+	if err != nil {
+		t.Fatalf("writer: %v", err)
+	}
+	ts := time.Unix(1700000000, 123456000)
+	data := ipv4Packet(1, "10.0.0.2", "1.1.1.1")[:27]
+	if _, err := w.WritePacket(ts, data, 84); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	blocks := readBlocks(t, buf.Bytes())
+	if len(blocks) != 3 || blocks[0].kind != blockSHB || blocks[1].kind != blockIDB || blocks[2].kind != blockEPB {
+		t.Fatalf("blocks = %+v, want SHB, IDB, EPB", blocks)
+	}
+	if binary.LittleEndian.Uint32(blocks[0].body) != 0x1A2B3C4D {
+		t.Fatalf("byte-order magic missing")
+	}
+	if lt := binary.LittleEndian.Uint16(blocks[1].body); lt != LinkTypeIPv4 {
+		t.Fatalf("link type = %d", lt)
+	}
+
+	epb := blocks[2].body
+	us := uint64(binary.LittleEndian.Uint32(epb[4:]))<<32 | uint64(binary.LittleEndian.Uint32(epb[8:]))
+	if us != uint64(ts.UnixMicro()) {
+		t.Fatalf("timestamp = %d, want %d", us, ts.UnixMicro())
+	}
+	if captured, orig := binary.LittleEndian.Uint32(epb[12:]), binary.LittleEndian.Uint32(epb[16:]); captured != 27 || orig != 84 {
+		t.Fatalf("lengths = %d/%d, want 27/84", captured, orig)
+	}
+	if !bytes.Equal(epb[20:47], data) {
+		t.Fatalf("packet data mangled")
+	}
+}
+
+type fakeSource struct {
+	mu      sync.Mutex
+	packets int
+	closed  bool
+}
+
+func (f *fakeSource) Read(buf []byte) (int, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.packets++
+	time.Sleep(time.Millisecond)
+	return copy(buf, ipv4Packet(1, "10.0.0.2", "1.1.1.1")), 28, nil
+}
+
+func (f *fakeSource) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func TestManagerStopsAndPrunes(t *testing.T) {
+	src := &fakeSource{}
+	openSource = func(string, []bpf.RawInstruction) (source, error) { return src, nil }
+	defer func() { openSource = openPacketSource }()
+
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.pcapng")
+	if err := os.WriteFile(old, nil, 0o644); err != nil {
+		t.Fatalf("write old capture: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(old, past, past)
+
+	saved := make(chan Result, 2)
+	m := NewManager(Config{Interface: "eth0", Dir: dir, MaxBytes: 1 << 20, MaxFiles: 1, SnapLen: 64}, func(r Result) { saved <- r })
+
+	m.Start("1.1.1.1", "out-1", "inc-1")
+	time.Sleep(20 * time.Millisecond)
+	m.Stop("out-1")
+	res := <-saved
+
+	if res.StopReason != StopOutageEnd || res.Err != "" || res.Packets == 0 {
+		t.Fatalf("result = %+v, want a clean outage_end stop with packets", res)
+	}
+	if res.Path != filepath.Join(dir, "out-1.pcapng") || res.Filter != "icmp or host 1.1.1.1" {
+		t.Fatalf("result = %+v", res)
+	}
+	if !src.closed {
+		t.Fatalf("source not closed")
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("oldest capture not pruned")
+	}
+	b, err := os.ReadFile(res.Path)
+	if err != nil {
+		t.Fatalf("read capture: %v", err)
+	}
+	if got := len(readBlocks(t, b)); got != 2+res.Packets {
+		t.Fatalf("capture has %d blocks, want %d", got, 2+res.Packets)
+	}
+
+	m2 := NewManager(Config{Interface: "eth0", Dir: dir, MaxBytes: 200, SnapLen: 64}, func(r Result) { saved <- r })
+	m2.Start("1.1.1.1", "out-2", "inc-2")
+	if res := <-saved; res.StopReason != StopSizeCap || res.Bytes < 200 {
+		t.Fatalf("result = %+v, want a size_cap stop", res)
+	}
+	m2.Close()
+}
+
+func TestManagerStartDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	src := &fakeSource{}
+	openSource = func(string, []bpf.RawInstruction) (source, error) {
+		<-release
+		return src, nil
+	}
+	defer func() { openSource = openPacketSource }()
+
+	saved := make(chan Result, 1)
+	m := NewManager(Config{Interface: "eth0", Dir: t.TempDir(), MaxBytes: 1 << 20, SnapLen: 64}, func(r Result) { saved <- r })
+
+	started := make(chan struct{})
+	go func() {
+		m.Start("1.1.1.1", "out-1", "inc-1")
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("start blocked on opening the capture")
+	}
+
+	// Stopped before the socket was open: the capture still ends cleanly.
+	m.Stop("out-1")
+	close(release)
+	if res := <-saved; res.StopReason != StopOutageEnd || res.Interface != "eth0" {
+		t.Fatalf("result = %+v", res)
+	}
+
+	openSource = func(string, []bpf.RawInstruction) (source, error) { return nil, os.ErrPermission }
+	m.Start("1.1.1.1", "out-2", "inc-2")
+	m.Close()
+	if len(saved) != 0 || len(m.sessions) != 0 {
+		t.Fatalf("failed capture left a result or session behind")
+	}
+}
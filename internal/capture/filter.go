@@ -0,0 +1,46 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/bpf"
+)
+
+// Filter builds the socket filter for a capture: every ICMP packet, so the
+// probes and any unreachable or TTL errors about them are kept, plus all
+// traffic to or from target. Packets start at the IPv4 header. A nil target
+// keeps ICMP only. The description reads like the tcpdump equivalent.
+func Filter(target net.IP, snapLen int) ([]bpf.RawInstruction, string, error) {
+	accept := bpf.RetConstant{Val: uint32(snapLen)}
+	drop := bpf.RetConstant{Val: 0}
+
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 9, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 1, SkipFalse: 1},
+		accept,
+	}
+	desc := "icmp"
+
+	if ip4 := target.To4(); ip4 != nil {
+		addr := binary.BigEndian.Uint32(ip4)
+		prog = append(prog,
+			bpf.LoadAbsolute{Off: 12, Size: 4},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: addr, SkipFalse: 1},
+			accept,
+			bpf.LoadAbsolute{Off: 16, Size: 4},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: addr, SkipFalse: 1},
+			accept,
+		)
+		desc = fmt.Sprintf("icmp or host %s", ip4)
+	}
+	prog = append(prog, drop)
+
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		return nil, "", fmt.Errorf("assemble capture filter: %w", err)
+	}
+
+	return raw, desc, nil
+}
@@ -0,0 +1,101 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// LinkTypeIPv4 marks packets that start at the IPv4 header, which is what a
+// cooked AF_PACKET socket bound to ETH_P_IP delivers on any interface type.
+const LinkTypeIPv4 = 228
+
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	optEnd      = 0
+	optUserAppl = 4
+	optIfName   = 2
+)
+
+// pcapngWriter writes a single-section, single-interface pcapng stream with
+// microsecond timestamps, the format Wireshark and tcpdump read by default.
+type pcapngWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newPcapngWriter(w io.Writer, iface string, linkType uint16, snapLen int) (*pcapngWriter, error) {
+	pw := &pcapngWriter{w: w}
+
+	shb := binary.LittleEndian.AppendUint32(nil, 0x1A2B3C4D)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	shb = appendOption(shb, optUserAppl, []byte("edgeprobe"))
+	shb = appendOption(shb, optEnd, nil)
+	if err := pw.block(blockSHB, shb); err != nil {
+		return nil, err
+	}
+
+	idb := binary.LittleEndian.AppendUint16(nil, linkType)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, uint32(snapLen))
+	if iface != "" {
+		idb = appendOption(idb, optIfName, []byte(iface))
+	}
+	idb = appendOption(idb, optEnd, nil)
+	if err := pw.block(blockIDB, idb); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+// WritePacket writes one Enhanced Packet Block. origLen is the length on the
+// wire, which is larger than data when the packet was truncated.
+func (pw *pcapngWriter) WritePacket(ts time.Time, data []byte, origLen int) (int, error) {
+	us := uint64(ts.UnixMicro())
+
+	body := pw.buf[:0]
+	body = binary.LittleEndian.AppendUint32(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, uint32(us>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(us))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(origLen))
+	body = append(body, data...)
+	body = pad(body)
+	pw.buf = body
+
+	return 12 + len(body), pw.block(blockEPB, body)
+}
+
+func (pw *pcapngWriter) block(kind uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	head := binary.LittleEndian.AppendUint32(nil, kind)
+	head = binary.LittleEndian.AppendUint32(head, total)
+	if _, err := pw.w.Write(head); err != nil {
+		return err
+	}
+	if _, err := pw.w.Write(body); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(binary.LittleEndian.AppendUint32(nil, total))
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return pad(b)
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
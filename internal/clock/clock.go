@@ -0,0 +1,168 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for probes, the event pipeline and the logger.
+// Production code uses Real; tests and replays drive a Virtual clock.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+func (Real) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (Real) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Virtual only moves when told to. Timers and tickers fire, in deadline
+// order, as Advance or Set carries the clock past them; like the real ones
+// their channels hold one pending tick and drop the rest.
+type Virtual struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	when    time.Time
+	period  time.Duration
+	ch      chan time.Time
+	stopped bool
+}
+
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.now
+}
+
+func (v *Virtual) Advance(d time.Duration) {
+	v.Set(v.Now().Add(d))
+}
+
+// Set moves the clock forward to t. Moving backwards is ignored.
+func (v *Virtual) Set(t time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for {
+		sort.SliceStable(v.waiters, func(i, j int) bool { return v.waiters[i].when.Before(v.waiters[j].when) })
+		if len(v.waiters) == 0 || v.waiters[0].when.After(t) {
+			break
+		}
+
+		w := v.waiters[0]
+		if w.when.After(v.now) {
+			v.now = w.when
+		}
+		select {
+		case w.ch <- v.now:
+		default:
+		}
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			v.remove(w)
+		}
+	}
+	if t.After(v.now) {
+		v.now = t
+	}
+}
+
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	return &virtualTimer{v: v, w: v.add(d, 0)}
+}
+
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return &virtualTicker{v: v, w: v.add(d, d)}
+}
+
+func (v *Virtual) add(d, period time.Duration) *waiter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	w := &waiter{when: v.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.ch <- v.now
+		if period == 0 {
+			w.stopped = true
+			return w
+		}
+	}
+	v.waiters = append(v.waiters, w)
+	return w
+}
+
+func (v *Virtual) stop(w *waiter) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if w.stopped {
+		return false
+	}
+	v.remove(w)
+	return true
+}
+
+func (v *Virtual) remove(w *waiter) {
+	w.stopped = true
+	for i, other := range v.waiters {
+		if other == w {
+			v.waiters = append(v.waiters[:i], v.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type virtualTimer struct {
+	v *Virtual
+	w *waiter
+}
+
+func (t *virtualTimer) C() <-chan time.Time { return t.w.ch }
+func (t *virtualTimer) Stop() bool          { return t.v.stop(t.w) }
+
+type virtualTicker struct {
+	v *Virtual
+	w *waiter
+}
+
+func (t *virtualTicker) C() <-chan time.Time { return t.w.ch }
+func (t *virtualTicker) Stop()               { t.v.stop(t.w) }
@@ -0,0 +1,55 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualTimersFireInOrder(t *testing.T) {
+	start := time.Unix(1000, 0)
+	v := NewVirtual(start)
+
+	ticker := v.NewTicker(time.Second)
+	timer := v.NewTimer(1500 * time.Millisecond)
+	stopped := v.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Fatalf("stopping a pending timer should report true")
+	}
+
+	v.Advance(time.Second)
+	if got := <-ticker.C(); !got.Equal(start.Add(time.Second)) {
+		t.Fatalf("tick at %v, want %v", got, start.Add(time.Second))
+	}
+	select {
+	case <-timer.C():
+		t.Fatalf("timer fired early")
+	default:
+	}
+
+	v.Advance(time.Second)
+	if got := <-timer.C(); !got.Equal(start.Add(1500 * time.Millisecond)) {
+		t.Fatalf("timer fired at %v, want its deadline", got)
+	}
+	if got := <-ticker.C(); !got.Equal(start.Add(2 * time.Second)) {
+		t.Fatalf("tick at %v, want %v", got, start.Add(2*time.Second))
+	}
+	if timer.Stop() {
+		t.Fatalf("stopping a fired timer should report false")
+	}
+	select {
+	case <-stopped.C():
+		t.Fatalf("stopped timer fired")
+	default:
+	}
+
+	v.Advance(5 * time.Second)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatalf("ticker should drop ticks nobody read")
+	default:
+	}
+	if !v.Now().Equal(start.Add(7 * time.Second)) {
+		t.Fatalf("now = %v, want %v", v.Now(), start.Add(7*time.Second))
+	}
+}
@@ -3,24 +3,54 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
-	Logging    LoggingConfig     `toml:"logging"`
-	Ping       PingConfig        `toml:"ping"`
-	DNS        DNSConfig         `toml:"dns"`
-	Traceroute TracerouteConfig  `toml:"traceroute"`
-	Targets    []TargetConfig    `toml:"targets"`
+	Logging      LoggingConfig      `toml:"logging"`
+	Samples      SamplesConfig      `toml:"samples"`
+	Ping         PingConfig         `toml:"ping"`
+	DNS          DNSConfig          `toml:"dns"`
+	Traceroute   TracerouteConfig   `toml:"traceroute"`
+	Diagnosis    DiagnosisConfig    `toml:"diagnosis"`
+	State        StateConfig        `toml:"state"`
+	Heartbeat    HeartbeatConfig    `toml:"heartbeat"`
+	Environment  EnvironmentConfig  `toml:"environment"`
+	Stats        StatsConfig        `toml:"stats"`
+	Flap         FlapConfig         `toml:"flap"`
+	Recorder     RecorderConfig     `toml:"recorder"`
+	Capture      CaptureConfig      `toml:"capture"`
+	Metrics      MetricsConfig      `toml:"metrics"`
+	RemoteWrite  RemoteWriteConfig  `toml:"remote_write"`
+	Stdout       StdoutConfig       `toml:"stdout"`
+	Syslog       SyslogConfig       `toml:"syslog"`
+	Loki         LokiConfig         `toml:"loki"`
+	Baseline     BaselineConfig     `toml:"baseline"`
+	Availability AvailabilityConfig `toml:"availability"`
+	Credits      CreditsConfig      `toml:"credits"`
+	Rules        []RuleConfig       `toml:"rules"`
+	Targets      []TargetConfig     `toml:"targets"`
 }
 
 type LoggingConfig struct {
-	Dir      string `toml:"dir"`
-	MaxMB    int    `toml:"max_mb"`
-	MaxFiles int    `toml:"max_files"`
+	Dir        string `toml:"dir"`
+	MaxMB      int    `toml:"max_mb"`
+	MaxFiles   int    `toml:"max_files"`
+	QueueSize  int    `toml:"queue_size"`
+	HealthSecs int    `toml:"health_secs"`
+	AgentFile  string `toml:"agent_file"`
+}
+
+type SamplesConfig struct {
+	Enabled  bool `toml:"enabled"`
+	MaxMB    int  `toml:"max_mb"`
+	MaxFiles int  `toml:"max_files"`
 }
 
 type PingConfig struct {
@@ -42,6 +72,145 @@ type TracerouteConfig struct {
 	TimeoutMS    int `toml:"timeout_ms"`
 }
 
+type RuleConfig struct {
+	Name       string  `toml:"name"`
+	Metric     string  `toml:"metric"`
+	WindowSecs int     `toml:"window_secs"`
+	Threshold  float64 `toml:"threshold"`
+}
+
+type StateConfig struct {
+	Dir              string `toml:"dir"`
+	SnapshotSecs     int    `toml:"snapshot_secs"`
+	ResumeMaxGapSecs int    `toml:"resume_max_gap_secs"`
+	ResumeAfterStop  bool   `toml:"resume_after_stop"`
+}
+
+type HeartbeatConfig struct {
+	IntervalSecs int `toml:"interval_secs"`
+	GapSecs      int `toml:"gap_secs"`
+}
+
+type EnvironmentConfig struct {
+	CheckSecs int `toml:"check_secs"`
+}
+
+type FlapConfig struct {
+	MergeGapSecs int `toml:"merge_gap_secs"`
+	Changes      int `toml:"changes"`
+	WindowSecs   int `toml:"window_secs"`
+}
+
+type RecorderConfig struct {
+	Enabled      bool `toml:"enabled"`
+	PreRollSecs  int  `toml:"pre_roll_secs"`
+	PostRollSecs int  `toml:"post_roll_secs"`
+}
+
+type CaptureConfig struct {
+	Enabled   bool   `toml:"enabled"`
+	Interface string `toml:"interface"`
+	Dir       string `toml:"dir"`
+	MaxMB     int    `toml:"max_mb"`
+	MaxSecs   int    `toml:"max_secs"`
+	MaxFiles  int    `toml:"max_files"`
+	SnapLen   int    `toml:"snaplen"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"`
+}
+
+type RemoteWriteConfig struct {
+	Enabled      bool              `toml:"enabled"`
+	URL          string            `toml:"url"`
+	Username     string            `toml:"username"`
+	Password     string            `toml:"password"`
+	Labels       map[string]string `toml:"labels"`
+	IntervalSecs int               `toml:"interval_secs"`
+	TimeoutSecs  int               `toml:"timeout_secs"`
+	BatchSize    int               `toml:"batch_size"`
+	WALDir       string            `toml:"wal_dir"`
+	WALMaxMB     int               `toml:"wal_max_mb"`
+}
+
+// SinkOptions are shared by the outputs that receive log records besides
+// the log file. Empty Types or Targets match every record.
+type SinkOptions struct {
+	Types     []string `toml:"types"`
+	Targets   []string `toml:"targets"`
+	QueueSize int      `toml:"queue_size"`
+}
+
+type StdoutConfig struct {
+	Enabled bool `toml:"enabled"`
+	SinkOptions
+}
+
+type SyslogConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Network string `toml:"network"`
+	Address string `toml:"address"`
+	Tag     string `toml:"tag"`
+	SinkOptions
+}
+
+type LokiConfig struct {
+	Enabled       bool              `toml:"enabled"`
+	URL           string            `toml:"url"`
+	Username      string            `toml:"username"`
+	Password      string            `toml:"password"`
+	TenantID      string            `toml:"tenant_id"`
+	Tags          map[string]string `toml:"tags"`
+	BatchSize     int               `toml:"batch_size"`
+	BatchWaitSecs int               `toml:"batch_wait_secs"`
+	TimeoutSecs   int               `toml:"timeout_secs"`
+	SpoolDir      string            `toml:"spool_dir"`
+	SpoolMaxMB    int               `toml:"spool_max_mb"`
+	SinkOptions
+}
+
+type BaselineConfig struct {
+	Sigma      float64 `toml:"sigma"`
+	MinSamples int     `toml:"min_samples"`
+	Timezone   string  `toml:"timezone"`
+	PeakHours  []int   `toml:"peak_hours"`
+}
+
+type StatsConfig struct {
+	IntervalSecs int `toml:"interval_secs"`
+}
+
+type AvailabilityConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	SLAPct   float64  `toml:"sla_pct"`
+	Periods  []string `toml:"periods"`
+	Timezone string   `toml:"timezone"`
+}
+
+type CreditsConfig struct {
+	MonthlyFee          float64             `toml:"monthly_fee"`
+	Currency            string              `toml:"currency"`
+	MinOutageMins       int                 `toml:"min_outage_mins"`
+	CreditPerOutage     float64             `toml:"credit_per_outage"`
+	CreditPerHour       float64             `toml:"credit_per_hour"`
+	MaxCreditPct        float64             `toml:"max_credit_pct"`
+	Timezone            string              `toml:"timezone"`
+	ExcludeFaultDomains []string            `toml:"exclude_fault_domains"`
+	Maintenance         []MaintenanceConfig `toml:"maintenance"`
+}
+
+type MaintenanceConfig struct {
+	Days  []string `toml:"days"`
+	Start string   `toml:"start"`
+	End   string   `toml:"end"`
+}
+
+type DiagnosisConfig struct {
+	Interface string `toml:"interface"`
+}
+
 type TargetConfig struct {
 	Name string `toml:"name"`
 	Host string `toml:"host"`
@@ -65,6 +234,62 @@ func Load(path string) (Config, error) {
 	return cfg, nil
 }
 
+// LoadWhatIf reads path over a copy of base, so a what-if file only needs the
+// settings it changes. Rules listed in the file replace the base rules.
+func LoadWhatIf(base Config, path string) (Config, error) {
+	cfg := base
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		return base, fmt.Errorf("decode what-if config: %w", err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return base, err
+	}
+
+	return cfg, nil
+}
+
+// recordTypes lists every log record type, for sink filters.
+var recordTypes = map[string]bool{
+	"incident_start":      true,
+	"incident_end":        true,
+	"degradation_start":   true,
+	"degradation_end":     true,
+	"outage_summary":      true,
+	"flapping_start":      true,
+	"flapping_end":        true,
+	"diagnosis":           true,
+	"anomaly":             true,
+	"probe_sample":        true,
+	"pcap_saved":          true,
+	"interval_stats":      true,
+	"availability_report": true,
+	"traceroute_result":   true,
+	"path_change":         true,
+	"logger_health":       true,
+	"agent_start":         true,
+	"agent_stop":          true,
+	"config_loaded":       true,
+	"probe_error":         true,
+	"worker_restart":      true,
+	"agent_gap":           true,
+	"heartbeat":           true,
+	"environment":         true,
+}
+
+func (o SinkOptions) validate(section string) []string {
+	var errs []string
+	for i, t := range o.Types {
+		if !recordTypes[t] {
+			errs = append(errs, fmt.Sprintf("%s.types[%d] is not a record type: %q", section, i, t))
+		}
+	}
+	if o.QueueSize < 0 {
+		errs = append(errs, fmt.Sprintf("%s.queue_size must be >= 0", section))
+	}
+	return errs
+}
+
 func (c *Config) validate() error {
 	var errs []string
 
@@ -77,6 +302,20 @@ func (c *Config) validate() error {
 	if c.Logging.MaxFiles <= 0 {
 		errs = append(errs, "logging.max_files must be > 0")
 	}
+	if c.Logging.QueueSize < 0 || c.Logging.HealthSecs < 0 {
+		errs = append(errs, "logging.queue_size and logging.health_secs must be >= 0")
+	}
+	if f := c.Logging.AgentFile; f != "" && (f != filepath.Base(f) || f == "edgeprobe.jsonl") {
+		errs = append(errs, "logging.agent_file must be a file name other than edgeprobe.jsonl")
+	}
+	if c.Samples.Enabled {
+		if c.Samples.MaxMB <= 0 {
+			errs = append(errs, "samples.max_mb must be > 0")
+		}
+		if c.Samples.MaxFiles <= 0 {
+			errs = append(errs, "samples.max_files must be > 0")
+		}
+	}
 	if c.Ping.IntervalMS <= 0 {
 		errs = append(errs, "ping.interval_ms must be > 0")
 	}
@@ -107,6 +346,188 @@ func (c *Config) validate() error {
 	if c.Traceroute.TimeoutMS <= 0 {
 		errs = append(errs, "traceroute.timeout_ms must be > 0")
 	}
+	if strings.TrimSpace(c.State.Dir) != "" {
+		if c.State.SnapshotSecs <= 0 {
+			errs = append(errs, "state.snapshot_secs must be > 0")
+		}
+		if c.State.ResumeMaxGapSecs <= 0 {
+			errs = append(errs, "state.resume_max_gap_secs must be > 0")
+		}
+	}
+	if c.Heartbeat.IntervalSecs < 0 {
+		errs = append(errs, "heartbeat.interval_secs must be >= 0")
+	}
+	if c.Heartbeat.IntervalSecs > 0 && c.Heartbeat.GapSecs < c.Heartbeat.IntervalSecs {
+		errs = append(errs, "heartbeat.gap_secs must be >= heartbeat.interval_secs")
+	}
+	if c.Environment.CheckSecs < 0 {
+		errs = append(errs, "environment.check_secs must be >= 0")
+	}
+	if c.Stats.IntervalSecs < 0 {
+		errs = append(errs, "stats.interval_secs must be >= 0")
+	}
+	if c.Flap.MergeGapSecs < 0 {
+		errs = append(errs, "flap.merge_gap_secs must be >= 0")
+	}
+	if c.Flap.Changes < 0 || c.Flap.WindowSecs < 0 {
+		errs = append(errs, "flap.changes and flap.window_secs must be >= 0")
+	}
+	if (c.Flap.Changes > 0) != (c.Flap.WindowSecs > 0) {
+		errs = append(errs, "flap.changes and flap.window_secs must be set together")
+	}
+	if c.Recorder.Enabled && (c.Recorder.PreRollSecs < 0 || c.Recorder.PostRollSecs < 0) {
+		errs = append(errs, "recorder.pre_roll_secs and recorder.post_roll_secs must be >= 0")
+	}
+	if c.Capture.Enabled {
+		if c.Capture.MaxMB <= 0 {
+			errs = append(errs, "capture.max_mb must be > 0")
+		}
+		if c.Capture.MaxSecs <= 0 {
+			errs = append(errs, "capture.max_secs must be > 0")
+		}
+		if c.Capture.MaxFiles <= 0 {
+			errs = append(errs, "capture.max_files must be > 0")
+		}
+		if c.Capture.SnapLen <= 0 || c.Capture.SnapLen > 65535 {
+			errs = append(errs, "capture.snaplen must be between 1 and 65535")
+		}
+	}
+	if c.Metrics.Enabled {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			errs = append(errs, fmt.Sprintf("metrics.listen must be host:port: %v", err))
+		}
+	}
+	if c.RemoteWrite.Enabled {
+		if !strings.HasPrefix(c.RemoteWrite.URL, "http://") && !strings.HasPrefix(c.RemoteWrite.URL, "https://") {
+			errs = append(errs, "remote_write.url must be an http or https URL")
+		}
+		if c.RemoteWrite.IntervalSecs <= 0 {
+			errs = append(errs, "remote_write.interval_secs must be > 0")
+		}
+		if c.RemoteWrite.TimeoutSecs <= 0 {
+			errs = append(errs, "remote_write.timeout_secs must be > 0")
+		}
+		if c.RemoteWrite.BatchSize <= 0 {
+			errs = append(errs, "remote_write.batch_size must be > 0")
+		}
+		if c.RemoteWrite.WALMaxMB <= 0 {
+			errs = append(errs, "remote_write.wal_max_mb must be > 0")
+		}
+	}
+	if c.Stdout.Enabled {
+		errs = append(errs, c.Stdout.validate("stdout")...)
+	}
+	if c.Syslog.Enabled {
+		switch c.Syslog.Network {
+		case "", "udp", "tcp", "unix", "unixgram":
+		default:
+			errs = append(errs, "syslog.network must be udp, tcp, unix or unixgram")
+		}
+		if (c.Syslog.Network == "") != (c.Syslog.Address == "") {
+			errs = append(errs, "syslog.network and syslog.address must be set together")
+		}
+		errs = append(errs, c.Syslog.validate("syslog")...)
+	}
+	if c.Loki.Enabled {
+		errs = append(errs, c.Loki.validate("loki")...)
+		if !strings.HasPrefix(c.Loki.URL, "http://") && !strings.HasPrefix(c.Loki.URL, "https://") {
+			errs = append(errs, "loki.url must be an http or https URL")
+		}
+		if c.Loki.BatchSize <= 0 {
+			errs = append(errs, "loki.batch_size must be > 0")
+		}
+		if c.Loki.BatchWaitSecs < 0 {
+			errs = append(errs, "loki.batch_wait_secs must be >= 0")
+		}
+		if c.Loki.TimeoutSecs <= 0 {
+			errs = append(errs, "loki.timeout_secs must be > 0")
+		}
+		if c.Loki.SpoolMaxMB <= 0 {
+			errs = append(errs, "loki.spool_max_mb must be > 0")
+		}
+		for name := range c.Loki.Tags {
+			switch name {
+			case "job", "type", "target", "host_id":
+				errs = append(errs, fmt.Sprintf("loki.tags cannot set the %s label", name))
+			}
+		}
+	}
+	if c.Baseline.Sigma < 0 {
+		errs = append(errs, "baseline.sigma must be >= 0")
+	}
+	if c.Baseline.MinSamples < 0 {
+		errs = append(errs, "baseline.min_samples must be >= 0")
+	}
+	if _, err := time.LoadLocation(c.Baseline.Timezone); err != nil {
+		errs = append(errs, fmt.Sprintf("baseline.timezone is invalid: %v", err))
+	}
+	for i, h := range c.Baseline.PeakHours {
+		if h < 0 || h > 23 {
+			errs = append(errs, fmt.Sprintf("baseline.peak_hours[%d] must be between 0 and 23", i))
+		}
+	}
+	if c.Availability.Enabled {
+		if strings.TrimSpace(c.State.Dir) == "" {
+			errs = append(errs, "availability requires state.dir")
+		}
+		if c.Availability.SLAPct < 0 || c.Availability.SLAPct >= 100 {
+			errs = append(errs, "availability.sla_pct must be >= 0 and < 100")
+		}
+		for i, p := range c.Availability.Periods {
+			switch p {
+			case "day", "week", "month":
+			default:
+				errs = append(errs, fmt.Sprintf("availability.periods[%d] must be one of day, week, month", i))
+			}
+		}
+		if _, err := time.LoadLocation(c.Availability.Timezone); err != nil {
+			errs = append(errs, fmt.Sprintf("availability.timezone is invalid: %v", err))
+		}
+	}
+	if c.Credits.MonthlyFee < 0 {
+		errs = append(errs, "credits.monthly_fee must be >= 0")
+	}
+	if c.Credits.MinOutageMins < 0 {
+		errs = append(errs, "credits.min_outage_mins must be >= 0")
+	}
+	if c.Credits.CreditPerOutage < 0 || c.Credits.CreditPerHour < 0 {
+		errs = append(errs, "credits.credit_per_outage and credits.credit_per_hour must be >= 0")
+	}
+	if c.Credits.MaxCreditPct < 0 || c.Credits.MaxCreditPct > 100 {
+		errs = append(errs, "credits.max_credit_pct must be between 0 and 100")
+	}
+	if _, err := time.LoadLocation(c.Credits.Timezone); err != nil {
+		errs = append(errs, fmt.Sprintf("credits.timezone is invalid: %v", err))
+	}
+	for i, m := range c.Credits.Maintenance {
+		for _, d := range m.Days {
+			if _, ok := ParseWeekday(d); !ok {
+				errs = append(errs, fmt.Sprintf("credits.maintenance[%d].days has unknown day %q", i, d))
+			}
+		}
+		if _, ok := ParseClock(m.Start); !ok {
+			errs = append(errs, fmt.Sprintf("credits.maintenance[%d].start must be HH:MM", i))
+		}
+		if _, ok := ParseClock(m.End); !ok {
+			errs = append(errs, fmt.Sprintf("credits.maintenance[%d].end must be HH:MM", i))
+		}
+	}
+	for i, r := range c.Rules {
+		switch r.Metric {
+		case "loss_pct", "rtt_p95_ms", "rtt_avg_ms", "consecutive_failures", "mos":
+		default:
+			errs = append(errs, fmt.Sprintf("rules[%d].metric must be one of loss_pct, rtt_p95_ms, rtt_avg_ms, consecutive_failures, mos", i))
+		}
+		if r.WindowSecs < 0 {
+			errs = append(errs, fmt.Sprintf("rules[%d].window_secs must be >= 0", i))
+		}
+		if r.Threshold <= 0 {
+			errs = append(errs, fmt.Sprintf("rules[%d].threshold must be > 0", i))
+		}
+		if r.Metric == "mos" && r.Threshold > 5 {
+			errs = append(errs, fmt.Sprintf("rules[%d].threshold must be <= 5 for mos", i))
+		}
+	}
 	if len(c.Targets) == 0 {
 		errs = append(errs, "targets must not be empty")
 	}
@@ -125,3 +546,27 @@ func (c *Config) validate() error {
 
 	return nil
 }
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func ParseWeekday(s string) (time.Weekday, bool) {
+	d, ok := weekdays[strings.ToLower(strings.TrimSpace(s))]
+	return d, ok
+}
+
+// ParseClock parses an HH:MM time of day into an offset from midnight.
+func ParseClock(s string) (time.Duration, bool) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, true
+}
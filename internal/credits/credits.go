@@ -0,0 +1,225 @@
+package credits
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Window is a recurring maintenance window in the contract's time zone. An
+// End at or before Start crosses midnight.
+type Window struct {
+	Days  []time.Weekday
+	Start time.Duration
+	End   time.Duration
+}
+
+type Contract struct {
+	MonthlyFee      float64
+	Currency        string
+	MinOutage       time.Duration
+	CreditPerOutage float64
+	CreditPerHour   float64
+	MaxCreditPct    float64
+	Location        *time.Location
+	Maintenance     []Window
+	ExcludeDomains  []string
+}
+
+// Outage is a span where at least one target was down. Overlapping
+// per-target outages are merged so the same downtime is never claimed twice.
+type Outage struct {
+	Start        time.Time
+	End          time.Time
+	Targets      []string
+	OutageIDs    []string
+	FaultDomains []string
+}
+
+type Item struct {
+	Outage
+	Duration    time.Duration
+	Maintenance time.Duration
+	Net         time.Duration
+	Qualifies   bool
+	Credit      float64
+	Note        string
+}
+
+type Claim struct {
+	MonthStart time.Time
+	MonthEnd   time.Time
+	Items      []Item
+	Subtotal   float64
+	Cap        float64
+	Total      float64
+}
+
+// Compute applies the contract to every outage that started in the month
+// containing month.
+func Compute(c Contract, outages []Outage, month time.Time) Claim {
+	loc := c.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	m := month.In(loc)
+	start := time.Date(m.Year(), m.Month(), 1, 0, 0, 0, 0, loc)
+	claim := Claim{MonthStart: start, MonthEnd: start.AddDate(0, 1, 0)}
+
+	excluded := make(map[string]bool, len(c.ExcludeDomains))
+	for _, d := range c.ExcludeDomains {
+		excluded[d] = true
+	}
+
+	for _, o := range outages {
+		if o.Start.Before(claim.MonthStart) || !o.Start.Before(claim.MonthEnd) {
+			continue
+		}
+
+		item := Item{Outage: o, Duration: o.End.Sub(o.Start)}
+		item.Maintenance = maintenanceOverlap(c.Maintenance, o.Start.In(loc), o.End.In(loc))
+		item.Net = item.Duration - item.Maintenance
+
+		switch {
+		case allExcluded(o.FaultDomains, excluded):
+			item.Note = fmt.Sprintf("excluded: fault domain %v is not the ISP's", o.FaultDomains)
+		case item.Net < c.MinOutage:
+			item.Note = fmt.Sprintf("below threshold: %s outside maintenance, need %s", item.Net.Round(time.Second), c.MinOutage)
+		default:
+			hours := math.Floor(item.Net.Hours())
+			item.Qualifies = true
+			item.Credit = c.CreditPerOutage + c.CreditPerHour*hours
+			item.Note = fmt.Sprintf("qualifies: %s outside maintenance, %d full hours", item.Net.Round(time.Second), int(hours))
+		}
+
+		claim.Items = append(claim.Items, item)
+		claim.Subtotal += item.Credit
+	}
+
+	claim.Total = claim.Subtotal
+	if c.MaxCreditPct > 0 {
+		claim.Cap = c.MonthlyFee * c.MaxCreditPct / 100
+		if claim.Total > claim.Cap {
+			claim.Total = claim.Cap
+		}
+	}
+
+	return claim
+}
+
+// Merge collapses overlapping outages into single spans.
+func Merge(outages []Outage) []Outage {
+	sorted := append([]Outage(nil), outages...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	var merged []Outage
+	for _, o := range sorted {
+		if n := len(merged); n > 0 && !o.Start.After(merged[n-1].End) {
+			last := &merged[n-1]
+			if o.End.After(last.End) {
+				last.End = o.End
+			}
+			last.Targets = appendUnique(last.Targets, o.Targets...)
+			last.OutageIDs = append(last.OutageIDs, o.OutageIDs...)
+			last.FaultDomains = appendUnique(last.FaultDomains, o.FaultDomains...)
+			continue
+		}
+		o.Targets = appendUnique(nil, o.Targets...)
+		o.OutageIDs = append([]string(nil), o.OutageIDs...)
+		o.FaultDomains = appendUnique(nil, o.FaultDomains...)
+		merged = append(merged, o)
+	}
+
+	return merged
+}
+
+func maintenanceOverlap(windows []Window, start, end time.Time) time.Duration {
+	if len(windows) == 0 || !end.After(start) {
+		return 0
+	}
+
+	// Collect every window occurrence touching the outage, starting the day
+	// before so windows that cross midnight are included, then union them.
+	var spans [][2]time.Time
+	first := time.Date(start.Year(), start.Month(), start.Day()-1, 0, 0, 0, 0, start.Location())
+	for day := first; day.Before(end); day = day.AddDate(0, 0, 1) {
+		for _, w := range windows {
+			if !w.on(day.Weekday()) {
+				continue
+			}
+			from := day.Add(w.Start)
+			to := day.Add(w.End)
+			if w.End <= w.Start {
+				to = day.AddDate(0, 0, 1).Add(w.End)
+			}
+			if from.Before(start) {
+				from = start
+			}
+			if to.After(end) {
+				to = end
+			}
+			if to.After(from) {
+				spans = append(spans, [2]time.Time{from, to})
+			}
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0].Before(spans[j][0]) })
+	var total time.Duration
+	var cursor time.Time
+	for _, s := range spans {
+		if s[0].Before(cursor) {
+			s[0] = cursor
+		}
+		if s[1].After(s[0]) {
+			total += s[1].Sub(s[0])
+			cursor = s[1]
+		}
+	}
+
+	return total
+}
+
+func (w Window) on(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+func allExcluded(domains []string, excluded map[string]bool) bool {
+	if len(domains) == 0 {
+		return false
+	}
+	for _, d := range domains {
+		if !excluded[d] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func appendUnique(dst []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, d := range dst {
+			if d == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, v)
+		}
+	}
+
+	return dst
+}
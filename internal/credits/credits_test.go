@@ -0,0 +1,88 @@
+package credits
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompute(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 9, day, hour, min, 0, 0, time.UTC)
+	}
+
+	contract := Contract{
+		MonthlyFee:      50,
+		MinOutage:       4 * time.Hour,
+		CreditPerOutage: 5,
+		CreditPerHour:   1,
+		MaxCreditPct:    50,
+		Maintenance:     []Window{{Days: []time.Weekday{time.Tuesday}, Start: 23 * time.Hour, End: 2 * time.Hour}},
+		ExcludeDomains:  []string{"local_link"},
+	}
+	outages := Merge([]Outage{
+		// 2026-09-01 is a Tuesday: 22:00-04:30 loses 3h to the window crossing midnight.
+		{Start: at(1, 22, 0), End: at(2, 4, 30), Targets: []string{"a"}, OutageIDs: []string{"o1"}},
+		{Start: at(10, 8, 0), End: at(10, 15, 0), Targets: []string{"a"}, OutageIDs: []string{"o2"}},
+		{Start: at(10, 9, 0), End: at(10, 14, 10), Targets: []string{"b"}, OutageIDs: []string{"o3"}},
+		{Start: at(20, 0, 0), End: at(20, 9, 0), Targets: []string{"a"}, OutageIDs: []string{"o4"}, FaultDomains: []string{"local_link"}},
+		{Start: at(30, 23, 0), End: time.Date(2026, 10, 1, 6, 0, 0, 0, time.UTC), Targets: []string{"a"}, OutageIDs: []string{"o5"}},
+		{Start: time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC), End: time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC), Targets: []string{"a"}, OutageIDs: []string{"o6"}},
+	})
+
+	claim := Compute(contract, outages, at(15, 0, 0))
+	if len(claim.Items) != 4 {
+		t.Fatalf("expected 4 items in September, got %d: %+v", len(claim.Items), claim.Items)
+	}
+
+	first := claim.Items[0]
+	if first.Maintenance != 3*time.Hour || first.Qualifies {
+		t.Fatalf("maintenance should drop first outage below threshold: %+v", first)
+	}
+	merged := claim.Items[1]
+	if len(merged.Targets) != 2 || merged.Duration != 7*time.Hour || merged.Credit != 12 {
+		t.Fatalf("unexpected merged outage: %+v", merged)
+	}
+	if claim.Items[2].Qualifies {
+		t.Fatalf("local link outage should be excluded: %+v", claim.Items[2])
+	}
+	if last := claim.Items[3]; !last.Qualifies || last.Credit != 12 {
+		t.Fatalf("outage spilling into next month should count in its start month: %+v", last)
+	}
+	if claim.Subtotal != 24 || claim.Total != 24 || claim.Cap != 25 {
+		t.Fatalf("unexpected totals: subtotal=%v total=%v cap=%v", claim.Subtotal, claim.Total, claim.Cap)
+	}
+
+	contract.CreditPerHour = 10
+	if capped := Compute(contract, outages, at(15, 0, 0)); capped.Total != 25 {
+		t.Fatalf("expected total capped at 25, got %v", capped.Total)
+	}
+}
+
+func TestReadOutages(t *testing.T) {
+	dir := t.TempDir()
+	lines := `{"type":"degradation_start","target":"a","outage_id":"o1"}
+{"type":"outage_summary","target":"a","outage_id":"o1","start_ts":"2026-09-01T10:01:00Z","end_ts":"2026-09-01T11:00:00Z","impact_start_ts":"2026-09-01T10:00:00Z","impact_end_ts":"2026-09-01T10:58:00Z"}
+{"type":"diagnosis","target":"a","outage_id":"o1","fault_domain":"isp_access"}
+{"type":"outage_summary","target":"b","outage_id":"o2"
+`
+	if err := os.WriteFile(filepath.Join(dir, "edgeprobe.jsonl"), []byte(lines), 0o644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	outages, err := ReadOutages(dir)
+	if err != nil {
+		t.Fatalf("read outages: %v", err)
+	}
+	if len(outages) != 1 {
+		t.Fatalf("expected one outage, got %+v", outages)
+	}
+	o := outages[0]
+	if !o.Start.Equal(time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC)) || o.End.Sub(o.Start) != 58*time.Minute {
+		t.Fatalf("expected impact times, got %+v", o)
+	}
+	if len(o.FaultDomains) != 1 || o.FaultDomains[0] != "isp_access" {
+		t.Fatalf("expected diagnosis joined, got %+v", o.FaultDomains)
+	}
+}
@@ -0,0 +1,95 @@
+package credits
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type logRecord struct {
+	Type          string    `json:"type"`
+	Target        string    `json:"target"`
+	OutageID      string    `json:"outage_id"`
+	StartTS       time.Time `json:"start_ts"`
+	EndTS         time.Time `json:"end_ts"`
+	ImpactStartTS time.Time `json:"impact_start_ts"`
+	ImpactEndTS   time.Time `json:"impact_end_ts"`
+	FaultDomain   string    `json:"fault_domain"`
+}
+
+// ReadOutages collects outage_summary records, and the diagnosis logged for
+// each, from every edgeprobe JSONL file in dir including rotated ones.
+func ReadOutages(dir string) ([]Outage, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "edgeprobe*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("list logs: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no edgeprobe logs in %s", dir)
+	}
+
+	summaries := make(map[string]logRecord)
+	domains := make(map[string]string)
+	var order []string
+	for _, path := range paths {
+		if err := scanLog(path, func(rec logRecord) {
+			switch rec.Type {
+			case "outage_summary":
+				if _, seen := summaries[rec.OutageID]; !seen {
+					order = append(order, rec.OutageID)
+				}
+				summaries[rec.OutageID] = rec
+			case "diagnosis":
+				domains[rec.OutageID] = rec.FaultDomain
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	outages := make([]Outage, 0, len(order))
+	for _, id := range order {
+		rec := summaries[id]
+		o := Outage{
+			Start:     rec.ImpactStartTS,
+			End:       rec.ImpactEndTS,
+			Targets:   []string{rec.Target},
+			OutageIDs: []string{id},
+		}
+		if o.Start.IsZero() || o.End.IsZero() {
+			o.Start, o.End = rec.StartTS, rec.EndTS
+		}
+		if d := domains[id]; d != "" {
+			o.FaultDomains = []string{d}
+		}
+		outages = append(outages, o)
+	}
+
+	return outages, nil
+}
+
+func scanLog(path string, fn func(logRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var rec logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		fn(rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	return nil
+}
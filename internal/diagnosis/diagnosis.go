@@ -0,0 +1,226 @@
+package diagnosis
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/iaserrat/edgeprobe/internal/traceroute"
+)
+
+const (
+	DomainLocalLink   = "local_link"
+	DomainLANGateway  = "lan_gateway"
+	DomainISPAccess   = "isp_access"
+	DomainISPCore     = "isp_core"
+	DomainDestination = "destination"
+	DomainDNSOnly     = "dns_only"
+	DomainUnknown     = "unknown"
+)
+
+const (
+	ConfidenceHigh   = "high"
+	ConfidenceMedium = "medium"
+	ConfidenceLow    = "low"
+)
+
+const (
+	scopeAllTargets   = "all_targets"
+	scopeSingleTarget = "single_target"
+)
+
+type Evidence struct {
+	Target    string
+	Interface string
+	LinkState string
+	Gateway   string
+	Trace     *traceroute.Result
+	// Reason lists the ping rules that opened the outage, as in
+	// degradation_start.
+	Reason    string
+	PingSent  int
+	PingRecv  int
+	DNSErrors int
+	Scope     string
+}
+
+type Diagnosis struct {
+	Domain     string
+	Confidence string
+	Evidence   []string
+}
+
+func Classify(ev Evidence) Diagnosis {
+	var notes []string
+
+	if ev.LinkState != "" {
+		notes = append(notes, fmt.Sprintf("link %s operstate %s", ev.Interface, ev.LinkState))
+	}
+	if ev.LinkState == "down" || ev.LinkState == "lowerlayerdown" || ev.LinkState == "notpresent" {
+		return Diagnosis{Domain: DomainLocalLink, Confidence: ConfidenceHigh, Evidence: notes}
+	}
+
+	if ev.Reason != "" {
+		notes = append(notes, fmt.Sprintf("opened by %s", ev.Reason))
+	}
+	notes = append(notes, fmt.Sprintf("ping received %d/%d", ev.PingRecv, ev.PingSent))
+	if ev.DNSErrors > 0 {
+		notes = append(notes, fmt.Sprintf("dns errors %d", ev.DNSErrors))
+	}
+	if ev.Scope != "" {
+		notes = append(notes, fmt.Sprintf("incident scope %s", ev.Scope))
+	}
+
+	// DNS errors are only a diagnosis when no ping rule tripped; an RTT or
+	// MOS outage with a stray DNS timeout is still classified by its path.
+	if ev.Reason == "" && ev.PingSent > 0 && ev.PingRecv == ev.PingSent && ev.DNSErrors > 0 {
+		return Diagnosis{Domain: DomainDNSOnly, Confidence: ConfidenceMedium, Evidence: notes}
+	}
+
+	domain := DomainUnknown
+	fromTrace := false
+	if ev.Trace != nil && len(ev.Trace.Hops) > 0 {
+		var traceNotes []string
+		dest := ev.Trace.DestIP
+		if dest == "" {
+			dest = ev.Target
+		}
+		domain, traceNotes = classifyTrace(dest, ev.Gateway, ev.Trace.Hops)
+		notes = append(notes, traceNotes...)
+		fromTrace = true
+	} else {
+		notes = append(notes, "no traceroute for this outage")
+		switch ev.Scope {
+		case scopeSingleTarget:
+			domain = DomainDestination
+		case scopeAllTargets:
+			domain = DomainISPAccess
+		}
+	}
+
+	return Diagnosis{Domain: domain, Confidence: confidence(domain, ev.Scope, fromTrace), Evidence: notes}
+}
+
+// classifyTrace reads the path to dest, the address the traceroute resolved
+// the target to.
+func classifyTrace(dest string, gateway string, hops []traceroute.Hop) (string, []string) {
+	var notes []string
+
+	first := hops[0]
+	if first.IP != "" && gateway != "" {
+		if first.IP == gateway {
+			notes = append(notes, fmt.Sprintf("traceroute hop %d (%s) is the default gateway", first.TTL, first.IP))
+		} else {
+			notes = append(notes, fmt.Sprintf("traceroute hop %d (%s) differs from default gateway %s", first.TTL, first.IP, gateway))
+		}
+	}
+
+	last := hops[len(hops)-1]
+	if last.IP != "" && last.IP == dest {
+		notes = append(notes, fmt.Sprintf("traceroute reached %s at ttl %d", dest, last.TTL))
+		return DomainDestination, notes
+	}
+
+	silentFrom := 0
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i].IP != "" {
+			break
+		}
+		silentFrom = hops[i].TTL
+	}
+
+	switch {
+	case silentFrom == 0:
+		notes = append(notes, fmt.Sprintf("traceroute path responsive through ttl %d", last.TTL))
+		return DomainDestination, notes
+	case silentFrom <= 1:
+		notes = append(notes, "traceroute: no replies from the first hop onward")
+		return DomainLANGateway, notes
+	case silentFrom == 2:
+		notes = append(notes, "traceroute: no replies beyond the gateway (from ttl 2 onward)")
+		return DomainISPAccess, notes
+	default:
+		notes = append(notes, fmt.Sprintf("traceroute: replies stop mid-path at ttl %d", silentFrom))
+		return DomainISPCore, notes
+	}
+}
+
+func confidence(domain string, scope string, fromTrace bool) string {
+	if domain == DomainUnknown {
+		return ConfidenceLow
+	}
+	if !fromTrace {
+		return ConfidenceLow
+	}
+
+	switch domain {
+	case DomainLANGateway, DomainISPAccess, DomainISPCore:
+		if scope == scopeAllTargets {
+			return ConfidenceHigh
+		}
+		if scope == scopeSingleTarget {
+			return ConfidenceLow
+		}
+	case DomainDestination:
+		if scope == scopeSingleTarget {
+			return ConfidenceHigh
+		}
+		if scope == scopeAllTargets {
+			return ConfidenceLow
+		}
+	}
+
+	return ConfidenceMedium
+}
+
+type Observation struct {
+	Interface string
+	LinkState string
+	Gateway   string
+	Trace     *traceroute.Result
+}
+
+type Store struct {
+	mu       sync.Mutex
+	byOutage map[string]Observation
+}
+
+func NewStore() *Store {
+	return &Store{byOutage: make(map[string]Observation)}
+}
+
+func (s *Store) RecordLink(outageID string, iface string, linkState string, gateway string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obs := s.byOutage[outageID]
+	if obs.LinkState == "" || obs.LinkState == "up" {
+		obs.Interface = iface
+		obs.LinkState = linkState
+	}
+	if gateway != "" {
+		obs.Gateway = gateway
+	}
+	s.byOutage[outageID] = obs
+}
+
+func (s *Store) RecordTrace(outageID string, res traceroute.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obs, ok := s.byOutage[outageID]
+	if !ok {
+		return
+	}
+	obs.Trace = &res
+	s.byOutage[outageID] = obs
+}
+
+func (s *Store) Take(outageID string) Observation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obs := s.byOutage[outageID]
+	delete(s.byOutage, outageID)
+
+	return obs
+}
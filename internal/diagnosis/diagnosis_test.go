@@ -0,0 +1,123 @@
+package diagnosis
+
+import (
+	"testing"
+
+	"github.com/iaserrat/edgeprobe/internal/traceroute"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name       string
+		ev         Evidence
+		domain     string
+		confidence string
+	}{
+		{
+			name:       "link down",
+			ev:         Evidence{Interface: "eth0", LinkState: "down", PingSent: 10},
+			domain:     DomainLocalLink,
+			confidence: ConfidenceHigh,
+		},
+		{
+			name: "gateway silent",
+			ev: Evidence{
+				Target:   "1.1.1.1",
+				Gateway:  "192.168.1.1",
+				Trace:    &traceroute.Result{Hops: []traceroute.Hop{{TTL: 1}, {TTL: 2}, {TTL: 3}}},
+				PingSent: 10,
+				Scope:    scopeAllTargets,
+			},
+			domain:     DomainLANGateway,
+			confidence: ConfidenceHigh,
+		},
+		{
+			name: "isp access",
+			ev: Evidence{
+				Target:   "1.1.1.1",
+				Gateway:  "192.168.1.1",
+				Trace:    &traceroute.Result{Hops: []traceroute.Hop{{TTL: 1, IP: "192.168.1.1"}, {TTL: 2}, {TTL: 3}}},
+				PingSent: 10,
+				Scope:    scopeAllTargets,
+			},
+			domain:     DomainISPAccess,
+			confidence: ConfidenceHigh,
+		},
+		{
+			name: "isp core",
+			ev: Evidence{
+				Target:   "1.1.1.1",
+				Trace:    &traceroute.Result{Hops: []traceroute.Hop{{TTL: 1, IP: "192.168.1.1"}, {TTL: 2, IP: "10.0.0.1"}, {TTL: 3, IP: "10.0.1.1"}, {TTL: 4}}},
+				PingSent: 10,
+				Scope:    "subset",
+			},
+			domain:     DomainISPCore,
+			confidence: ConfidenceMedium,
+		},
+		{
+			name: "destination",
+			ev: Evidence{
+				Target:   "1.1.1.1",
+				Trace:    &traceroute.Result{Hops: []traceroute.Hop{{TTL: 1, IP: "192.168.1.1"}, {TTL: 2, IP: "1.1.1.1"}}},
+				PingSent: 10,
+				PingRecv: 5,
+				Scope:    scopeSingleTarget,
+			},
+			domain:     DomainDestination,
+			confidence: ConfidenceHigh,
+		},
+		{
+			name: "hostname destination",
+			ev: Evidence{
+				Target:   "example.com",
+				Trace:    &traceroute.Result{DestIP: "93.184.216.34", Hops: []traceroute.Hop{{TTL: 1, IP: "192.168.1.1"}, {TTL: 2}, {TTL: 3, IP: "93.184.216.34"}}},
+				PingSent: 10,
+				PingRecv: 5,
+				Scope:    scopeSingleTarget,
+			},
+			domain:     DomainDestination,
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "dns only",
+			ev:         Evidence{Target: "1.1.1.1", PingSent: 10, PingRecv: 10, DNSErrors: 3},
+			domain:     DomainDNSOnly,
+			confidence: ConfidenceMedium,
+		},
+		{
+			name: "rtt outage with stray dns errors",
+			ev: Evidence{
+				Target:    "1.1.1.1",
+				Trace:     &traceroute.Result{Hops: []traceroute.Hop{{TTL: 1, IP: "192.168.1.1"}, {TTL: 2, IP: "1.1.1.1"}}},
+				Reason:    "rtt_p95_ms",
+				PingSent:  10,
+				PingRecv:  10,
+				DNSErrors: 1,
+				Scope:     scopeSingleTarget,
+			},
+			domain:     DomainDestination,
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "no evidence",
+			ev:         Evidence{Target: "1.1.1.1", PingSent: 10, Scope: "subset"},
+			domain:     DomainUnknown,
+			confidence: ConfidenceLow,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := Classify(c.ev)
+			if got.Domain != c.domain {
+				t.Fatalf("domain = %s, want %s (evidence %v)", got.Domain, c.domain, got.Evidence)
+			}
+			if got.Confidence != c.confidence {
+				t.Fatalf("confidence = %s, want %s", got.Confidence, c.confidence)
+			}
+			if len(got.Evidence) == 0 {
+				t.Fatalf("expected evidence")
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package diagnosis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	procRoutePath = "/proc/net/route"
+	sysNetPath    = "/sys/class/net"
+)
+
+func DefaultRoute() (string, string, error) {
+	f, err := os.Open(procRoutePath)
+	if err != nil {
+		return "", "", fmt.Errorf("read routes: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		gw := make(net.IP, 4)
+		binary.BigEndian.PutUint32(gw, binary.LittleEndian.Uint32(raw))
+
+		return fields[0], gw.String(), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("read routes: %w", err)
+	}
+
+	return "", "", fmt.Errorf("no default route")
+}
+
+func LinkState(iface string) string {
+	if iface == "" {
+		return ""
+	}
+
+	b, err := os.ReadFile(filepath.Join(sysNetPath, iface, "operstate"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+type LinkProbe struct {
+	pinned  string
+	iface   string
+	gateway string
+}
+
+func NewLinkProbe(iface string) *LinkProbe {
+	return &LinkProbe{pinned: iface, iface: iface}
+}
+
+func (l *LinkProbe) Observe() (string, string, string) {
+	if routeIface, gw, err := DefaultRoute(); err == nil {
+		if l.pinned == "" {
+			l.iface = routeIface
+		}
+		if l.pinned == "" || l.pinned == routeIface {
+			l.gateway = gw
+		}
+	}
+
+	return l.iface, LinkState(l.iface), l.gateway
+}
@@ -0,0 +1,481 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+	"github.com/iaserrat/edgeprobe/internal/probe"
+)
+
+// Histogram bucket upper bounds, in seconds.
+var (
+	rttBuckets    = []float64{0.005, 0.01, 0.02, 0.03, 0.05, 0.075, 0.1, 0.15, 0.2, 0.3, 0.5, 1}
+	dnsBuckets    = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+	outageBuckets = []float64{5, 15, 30, 60, 120, 300, 600, 1800, 3600, 4 * 3600}
+	rttQuantiles  = []struct {
+		quantile string
+		value    func(metrics.LiveStats) float64
+	}{
+		{"0", func(s metrics.LiveStats) float64 { return s.RttMinMs }},
+		{"0.5", func(s metrics.LiveStats) float64 { return s.RttP50Ms }},
+		{"0.95", func(s metrics.LiveStats) float64 { return s.RttP95Ms }},
+		{"1", func(s metrics.LiveStats) float64 { return s.RttMaxMs }},
+	}
+)
+
+// Exporter keeps Prometheus series built from probe results and detector
+// events and renders them in the text exposition format. Everything is
+// computed from what edgeprobe already sees; nothing is probed for a scrape.
+type Exporter struct {
+	version string
+
+	mu        sync.Mutex
+	targets   map[string]*targetSeries
+	resolvers map[string]*resolverSeries
+	channels  map[string]func() (int, int)
+	live      func() []metrics.LiveStats
+	errors    map[workerKey]uint64
+	restarts  map[workerKey]uint64
+}
+
+// workerKey names a supervised probe worker; target is empty for workers
+// that are not per target.
+type workerKey struct {
+	worker string
+	target string
+}
+
+type targetSeries struct {
+	sent        uint64
+	recv        uint64
+	failures    map[string]uint64
+	rtt         *histogram
+	outage      bool
+	outages     uint64
+	outageDur   *histogram
+	traceroutes uint64
+	pathChanges uint64
+}
+
+type resolverSeries struct {
+	ok      uint64
+	failed  uint64
+	latency *histogram
+}
+
+func New(version string) *Exporter {
+	return &Exporter{
+		version:   version,
+		targets:   make(map[string]*targetSeries),
+		resolvers: make(map[string]*resolverSeries),
+		channels:  make(map[string]func() (int, int)),
+		errors:    make(map[workerKey]uint64),
+		restarts:  make(map[workerKey]uint64),
+	}
+}
+
+// WatchChannel exports the depth and capacity that fn reports for a queue.
+func (e *Exporter) WatchChannel(name string, fn func() (depth, capacity int)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.channels[name] = fn
+}
+
+// WatchLive exports the loss, RTT, jitter and MOS gauges from the window
+// stats fn returns at scrape time. *metrics.Detector's Live is the
+// production source.
+func (e *Exporter) WatchLive(fn func() []metrics.LiveStats) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.live = fn
+}
+
+// ObserveProbeError counts a probe worker failure.
+func (e *Exporter) ObserveProbeError(worker, target string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.errors[workerKey{worker, target}]++
+}
+
+// ObserveWorkerRestart counts a probe worker restart.
+func (e *Exporter) ObserveWorkerRestart(worker, target string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.restarts[workerKey{worker, target}]++
+}
+
+func (e *Exporter) ObservePing(p probe.PingResult) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t := e.target(p.Target)
+	t.sent++
+	if p.OK {
+		t.recv++
+		t.rtt.observe(p.RTTMs / 1000)
+		return
+	}
+	failure := p.Failure
+	if failure == "" {
+		failure = "unknown"
+	}
+	t.failures[failure]++
+}
+
+func (e *Exporter) ObserveDNS(d probe.DNSResult) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r := e.resolvers[d.Resolver]
+	if r == nil {
+		r = &resolverSeries{latency: newHistogram(dnsBuckets)}
+		e.resolvers[d.Resolver] = r
+	}
+	if !d.OK {
+		r.failed++
+		return
+	}
+	r.ok++
+	r.latency.observe(d.LatencyMs / 1000)
+}
+
+// ObserveEvent updates outage state.
+func (e *Exporter) ObserveEvent(ev metrics.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch evt := ev.(type) {
+	case metrics.OutageStart:
+		e.target(evt.Target).outage = true
+	case metrics.OutageEnd:
+		e.target(evt.Target).outage = false
+	case metrics.OutageSummary:
+		t := e.target(evt.Target)
+		t.outages++
+		t.outageDur.observe(float64(evt.ImpactDurationMs) / 1000)
+	}
+}
+
+// ObserveTraceroute counts a finished traceroute and whether its path
+// differed from the previous one.
+func (e *Exporter) ObserveTraceroute(target string, pathChanged bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t := e.target(target)
+	t.traceroutes++
+	if pathChanged {
+		t.pathChanges++
+	}
+}
+
+func (e *Exporter) target(name string) *targetSeries {
+	t := e.targets[name]
+	if t == nil {
+		t = &targetSeries{
+			failures:  make(map[string]uint64),
+			rtt:       newHistogram(rttBuckets),
+			outageDur: newHistogram(outageBuckets),
+		}
+		e.targets[name] = t
+	}
+	return t
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = e.Write(w)
+}
+
+// Write renders every series, families and label values in sorted order.
+func (e *Exporter) Write(out io.Writer) error {
+	w := &textWriter{w: bufio.NewWriter(out)}
+	e.collect(w)
+	return w.flush()
+}
+
+// Sample is one series value as a scrape would show it.
+type Sample struct {
+	Name   string
+	Labels []Label
+	Value  float64
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Samples returns every series in the same order as Write.
+func (e *Exporter) Samples() []Sample {
+	c := &collector{}
+	e.collect(c)
+	return c.samples
+}
+
+func (e *Exporter) collect(w emitter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	targets := sortedKeys(e.targets)
+	resolvers := sortedKeys(e.resolvers)
+
+	w.family("edgeprobe_build_info", "gauge", "Build information.")
+	w.sample("edgeprobe_build_info", labels{"version", e.version}, 1)
+
+	w.family("edgeprobe_ping_sent_total", "counter", "Pings sent per target.")
+	for _, name := range targets {
+		w.sample("edgeprobe_ping_sent_total", labels{"target", name}, float64(e.targets[name].sent))
+	}
+	w.family("edgeprobe_ping_received_total", "counter", "Echo replies received per target.")
+	for _, name := range targets {
+		w.sample("edgeprobe_ping_received_total", labels{"target", name}, float64(e.targets[name].recv))
+	}
+	w.family("edgeprobe_ping_failures_total", "counter", "Failed pings per target and failure class.")
+	for _, name := range targets {
+		t := e.targets[name]
+		for _, class := range sortedKeys(t.failures) {
+			w.sample("edgeprobe_ping_failures_total", labels{"target", name, "class", class}, float64(t.failures[class]))
+		}
+	}
+	w.family("edgeprobe_ping_rtt_seconds", "histogram", "Round-trip time of answered pings.")
+	for _, name := range targets {
+		writeHistogram(w, "edgeprobe_ping_rtt_seconds", labels{"target", name}, e.targets[name].rtt)
+	}
+
+	var live []metrics.LiveStats
+	if e.live != nil {
+		live = e.live()
+	}
+	w.family("edgeprobe_loss_ratio", "gauge", "Packet loss over the detection window.")
+	for _, s := range live {
+		if s.Sent > 0 {
+			w.sample("edgeprobe_loss_ratio", labels{"target", s.Target}, s.LossPct/100)
+		}
+	}
+	w.family("edgeprobe_rtt_quantile_seconds", "gauge", "RTT quantiles over the detection window.")
+	for _, s := range live {
+		if s.Recv > 0 {
+			for _, q := range rttQuantiles {
+				w.sample("edgeprobe_rtt_quantile_seconds", labels{"target", s.Target, "quantile", q.quantile}, q.value(s)/1000)
+			}
+		}
+	}
+	w.family("edgeprobe_jitter_seconds", "gauge", "Mean RTT difference between consecutive replies over the detection window.")
+	for _, s := range live {
+		if s.Recv > 0 {
+			w.sample("edgeprobe_jitter_seconds", labels{"target", s.Target}, s.JitterMs/1000)
+		}
+	}
+	w.family("edgeprobe_mos", "gauge", "Estimated call quality (1-5) over the detection window.")
+	for _, s := range live {
+		if s.Sent > 0 {
+			w.sample("edgeprobe_mos", labels{"target", s.Target}, s.MOS)
+		}
+	}
+
+	w.family("edgeprobe_outage_active", "gauge", "1 while the target is in an outage.")
+	for _, name := range targets {
+		v := 0.0
+		if e.targets[name].outage {
+			v = 1
+		}
+		w.sample("edgeprobe_outage_active", labels{"target", name}, v)
+	}
+	w.family("edgeprobe_outages_total", "counter", "Outages closed per target.")
+	for _, name := range targets {
+		w.sample("edgeprobe_outages_total", labels{"target", name}, float64(e.targets[name].outages))
+	}
+	w.family("edgeprobe_outage_duration_seconds", "histogram", "Impact duration of closed outages.")
+	for _, name := range targets {
+		writeHistogram(w, "edgeprobe_outage_duration_seconds", labels{"target", name}, e.targets[name].outageDur)
+	}
+	w.family("edgeprobe_traceroutes_total", "counter", "Traceroutes run per target.")
+	for _, name := range targets {
+		w.sample("edgeprobe_traceroutes_total", labels{"target", name}, float64(e.targets[name].traceroutes))
+	}
+	w.family("edgeprobe_path_changes_total", "counter", "Traceroutes whose path differed from the previous one.")
+	for _, name := range targets {
+		w.sample("edgeprobe_path_changes_total", labels{"target", name}, float64(e.targets[name].pathChanges))
+	}
+
+	w.family("edgeprobe_dns_queries_total", "counter", "DNS queries per resolver and result.")
+	for _, name := range resolvers {
+		r := e.resolvers[name]
+		w.sample("edgeprobe_dns_queries_total", labels{"resolver", name, "result", "ok"}, float64(r.ok))
+		w.sample("edgeprobe_dns_queries_total", labels{"resolver", name, "result", "error"}, float64(r.failed))
+	}
+	w.family("edgeprobe_dns_latency_seconds", "histogram", "Latency of answered DNS queries.")
+	for _, name := range resolvers {
+		writeHistogram(w, "edgeprobe_dns_latency_seconds", labels{"resolver", name}, e.resolvers[name].latency)
+	}
+
+	w.family("edgeprobe_probe_errors_total", "counter", "Probe worker failures.")
+	for _, k := range sortedWorkers(e.errors) {
+		w.sample("edgeprobe_probe_errors_total", labels{"worker", k.worker, "target", k.target}, float64(e.errors[k]))
+	}
+	w.family("edgeprobe_worker_restarts_total", "counter", "Probe worker restarts after a failure.")
+	for _, k := range sortedWorkers(e.restarts) {
+		w.sample("edgeprobe_worker_restarts_total", labels{"worker", k.worker, "target", k.target}, float64(e.restarts[k]))
+	}
+
+	channels := sortedKeys(e.channels)
+	w.family("edgeprobe_channel_depth", "gauge", "Items waiting in an internal queue.")
+	for _, name := range channels {
+		depth, _ := e.channels[name]()
+		w.sample("edgeprobe_channel_depth", labels{"channel", name}, float64(depth))
+	}
+	w.family("edgeprobe_channel_capacity", "gauge", "Capacity of an internal queue.")
+	for _, name := range channels {
+		_, capacity := e.channels[name]()
+		w.sample("edgeprobe_channel_capacity", labels{"channel", name}, float64(capacity))
+	}
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// labels alternates names and values.
+type labels []string
+
+type emitter interface {
+	family(name, kind, help string)
+	sample(name string, l labels, v float64)
+}
+
+// writeHistogram emits the cumulative buckets, sum and count series.
+func writeHistogram(w emitter, name string, l labels, h *histogram) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		w.sample(name+"_bucket", append(l[:len(l):len(l)], "le", formatValue(bound)), float64(cumulative))
+	}
+	w.sample(name+"_bucket", append(l[:len(l):len(l)], "le", "+Inf"), float64(h.count))
+	w.sample(name+"_sum", l, h.sum)
+	w.sample(name+"_count", l, float64(h.count))
+}
+
+type textWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *textWriter) family(name, kind, help string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *textWriter) sample(name string, l labels, v float64) {
+	w.printf("%s%s %s\n", name, l.format(), formatValue(v))
+}
+
+func (w *textWriter) printf(format string, args ...any) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
+
+func (w *textWriter) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+type collector struct {
+	samples []Sample
+}
+
+func (c *collector) family(name, kind, help string) {}
+
+func (c *collector) sample(name string, l labels, v float64) {
+	s := Sample{Name: name, Value: v, Labels: make([]Label, 0, len(l)/2)}
+	for i := 0; i+1 < len(l); i += 2 {
+		s.Labels = append(s.Labels, Label{Name: l[i], Value: l[i+1]})
+	}
+	c.samples = append(c.samples, s)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (l labels) format() string {
+	if len(l) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(l); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(l[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedWorkers(m map[workerKey]uint64) []workerKey {
+	keys := make([]workerKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].worker != keys[j].worker {
+			return keys[i].worker < keys[j].worker
+		}
+		return keys[i].target < keys[j].target
+	})
+	return keys
+}
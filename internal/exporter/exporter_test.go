@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+	"github.com/iaserrat/edgeprobe/internal/probe"
+)
+
+func TestWrite(t *testing.T) {
+	e := New("1.2.3")
+	ts := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	e.ObservePing(probe.PingResult{Target: "1.1.1.1", Time: ts, OK: true, RTTMs: 16})
+	e.ObservePing(probe.PingResult{Target: "1.1.1.1", Time: ts, OK: true, RTTMs: 40})
+	e.ObservePing(probe.PingResult{Target: "1.1.1.1", Time: ts, Failure: probe.FailTimeout})
+	e.ObserveDNS(probe.DNSResult{Time: ts, Resolver: "9.9.9.9:53", OK: true, LatencyMs: 20})
+	e.ObserveDNS(probe.DNSResult{Time: ts, Resolver: "9.9.9.9:53"})
+	e.ObserveEvent(metrics.OutageStart{Target: "8.8.8.8"})
+	e.ObserveEvent(metrics.OutageSummary{Target: "1.1.1.1", ImpactDurationMs: 45000})
+	e.WatchLive(func() []metrics.LiveStats {
+		return []metrics.LiveStats{{Target: "1.1.1.1", Sent: 4, Recv: 3, LossPct: 25, RttMinMs: 12, RttP50Ms: 12, RttP95Ms: 40, RttMaxMs: 40, JitterMs: 14, MOS: 4.1}}
+	})
+	e.ObserveProbeError("ping", "1.1.1.1")
+	e.ObserveProbeError("ping", "1.1.1.1")
+	e.ObserveWorkerRestart("dns", "")
+	e.ObserveTraceroute("1.1.1.1", false)
+	e.ObserveTraceroute("1.1.1.1", true)
+	e.WatchChannel("ping", func() (int, int) { return 3, 100 })
+
+	var b strings.Builder
+	if err := e.Write(&b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	out := b.String()
+
+	for _, want := range []string{
+		`edgeprobe_build_info{version="1.2.3"} 1`,
+		`edgeprobe_ping_sent_total{target="1.1.1.1"} 3`,
+		`edgeprobe_ping_received_total{target="1.1.1.1"} 2`,
+		`edgeprobe_ping_failures_total{target="1.1.1.1",class="timeout"} 1`,
+		`edgeprobe_ping_rtt_seconds_bucket{target="1.1.1.1",le="0.02"} 1`,
+		`edgeprobe_ping_rtt_seconds_bucket{target="1.1.1.1",le="0.05"} 2`,
+		`edgeprobe_ping_rtt_seconds_bucket{target="1.1.1.1",le="+Inf"} 2`,
+		`edgeprobe_ping_rtt_seconds_sum{target="1.1.1.1"} 0.056`,
+		`edgeprobe_ping_rtt_seconds_count{target="1.1.1.1"} 2`,
+		`edgeprobe_loss_ratio{target="1.1.1.1"} 0.25`,
+		`edgeprobe_rtt_quantile_seconds{target="1.1.1.1",quantile="0.95"} 0.04`,
+		`edgeprobe_jitter_seconds{target="1.1.1.1"} 0.014`,
+		`edgeprobe_mos{target="1.1.1.1"} 4.1`,
+		`edgeprobe_outage_active{target="8.8.8.8"} 1`,
+		`edgeprobe_outage_active{target="1.1.1.1"} 0`,
+		`edgeprobe_outages_total{target="1.1.1.1"} 1`,
+		`edgeprobe_outage_duration_seconds_bucket{target="1.1.1.1",le="60"} 1`,
+		`edgeprobe_traceroutes_total{target="1.1.1.1"} 2`,
+		`edgeprobe_path_changes_total{target="1.1.1.1"} 1`,
+		`edgeprobe_dns_queries_total{resolver="9.9.9.9:53",result="ok"} 1`,
+		`edgeprobe_dns_queries_total{resolver="9.9.9.9:53",result="error"} 1`,
+		`edgeprobe_dns_latency_seconds_count{resolver="9.9.9.9:53"} 1`,
+		`edgeprobe_probe_errors_total{worker="ping",target="1.1.1.1"} 2`,
+		`edgeprobe_worker_restarts_total{worker="dns",target=""} 1`,
+		`edgeprobe_channel_depth{channel="ping"} 3`,
+		`edgeprobe_channel_capacity{channel="ping"} 100`,
+		"# TYPE edgeprobe_ping_rtt_seconds histogram",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %q", want)
+		}
+	}
+	if strings.Index(out, `target="1.1.1.1"`) > strings.Index(out, `target="8.8.8.8"`) {
+		t.Errorf("targets not sorted")
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	got := labels{"target", "a\"b\\c\nd"}.format()
+	if want := `{target="a\"b\\c\nd"}`; got != want {
+		t.Fatalf("labels = %s, want %s", got, want)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	e := New("dev")
+	e.ObservePing(probe.PingResult{Target: "1.1.1.1", OK: true, RTTMs: 5})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type = %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), `edgeprobe_ping_received_total{target="1.1.1.1"} 1`) {
+		t.Fatalf("body = %s", body)
+	}
+}
+
+func TestSamples(t *testing.T) {
+	e := New("dev")
+	e.ObserveDNS(probe.DNSResult{Resolver: "1.1.1.1:53", OK: true, LatencyMs: 7})
+
+	var got []string
+	for _, s := range e.Samples() {
+		if s.Name == "edgeprobe_dns_queries_total" {
+			got = append(got, fmt.Sprint(s.Labels, s.Value))
+		}
+	}
+	if want := "[[{resolver 1.1.1.1:53} {result ok}] 1 [{resolver 1.1.1.1:53} {result error}] 0]"; fmt.Sprint(got) != want {
+		t.Fatalf("samples = %v, want %v", got, want)
+	}
+}
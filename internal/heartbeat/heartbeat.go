@@ -0,0 +1,121 @@
+package heartbeat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+	"github.com/iaserrat/edgeprobe/internal/statefile"
+)
+
+const stateVersion = 1
+
+// State is what a run leaves behind. Clean is set when it stopped normally
+// rather than being killed or losing power.
+type State struct {
+	Version   int       `json:"version"`
+	StartTS   time.Time `json:"start_ts"`
+	LastAlive time.Time `json:"last_alive"`
+	Clean     bool      `json:"clean"`
+}
+
+// Tracker records the current run. The file is rewritten and synced on every
+// Beat, so after a crash or power cut LastAlive is at most one beat interval
+// stale.
+type Tracker struct {
+	path string
+	clk  clock.Clock
+	prev State
+	ok   bool
+	lost bool
+	cur  State
+}
+
+// Start reads the previous run's state from path and replaces it with the
+// current run's. An unreadable file is reported but does not stop the run.
+func Start(path string, clk clock.Clock) (*Tracker, error) {
+	now := clk.Now().UTC()
+	t := &Tracker{path: path, clk: clk, cur: State{Version: stateVersion, StartTS: now, LastAlive: now}}
+
+	prev, ok, loadErr := load(path)
+	t.prev, t.ok, t.lost = prev, ok, loadErr != nil
+	if err := t.save(); err != nil {
+		return t, err
+	}
+	return t, loadErr
+}
+
+// Previous returns the state of the last run, if there was one.
+func (t *Tracker) Previous() (State, bool) {
+	return t.prev, t.ok
+}
+
+// PreviousLost reports that a previous run left a state file that could not
+// be read, so it is known to have stopped but not when.
+func (t *Tracker) PreviousLost() bool {
+	return t.lost
+}
+
+// Gap is how long edgeprobe was down before this run: from the previous
+// run's last heartbeat to this start. It is zero without a previous run or
+// when the clock went backwards.
+func (t *Tracker) Gap() time.Duration {
+	if !t.ok {
+		return 0
+	}
+	return max(t.cur.StartTS.Sub(t.prev.LastAlive), 0)
+}
+
+func (t *Tracker) StartTS() time.Time {
+	return t.cur.StartTS
+}
+
+func (t *Tracker) Uptime() time.Duration {
+	return t.clk.Now().Sub(t.cur.StartTS)
+}
+
+// Beat moves the last-alive time to now.
+func (t *Tracker) Beat() error {
+	t.cur.LastAlive = t.clk.Now().UTC()
+	return t.save()
+}
+
+// Stop records a clean shutdown.
+func (t *Tracker) Stop() error {
+	t.cur.LastAlive = t.clk.Now().UTC()
+	t.cur.Clean = true
+	return t.save()
+}
+
+func load(path string) (State, bool, error) {
+	var s State
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, fmt.Errorf("read heartbeat: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, false, fmt.Errorf("decode heartbeat: %w", err)
+	}
+	if s.Version != stateVersion {
+		return s, false, fmt.Errorf("unsupported heartbeat version %d", s.Version)
+	}
+
+	return s, true, nil
+}
+
+func (t *Tracker) save() error {
+	b, err := json.Marshal(t.cur)
+	if err != nil {
+		return fmt.Errorf("marshal heartbeat: %w", err)
+	}
+
+	return statefile.Write(t.path, b, "heartbeat", true)
+}
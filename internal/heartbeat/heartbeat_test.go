@@ -0,0 +1,73 @@
+package heartbeat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+)
+
+func TestGapSinceLastBeat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "heartbeat.json")
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+
+	first, err := Start(path, clk)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if _, ok := first.Previous(); ok || first.Gap() != 0 {
+		t.Fatalf("first run has a previous run")
+	}
+
+	clk.Advance(time.Minute)
+	if err := first.Beat(); err != nil {
+		t.Fatalf("beat: %v", err)
+	}
+	if first.Uptime() != time.Minute {
+		t.Fatalf("uptime = %s", first.Uptime())
+	}
+
+	// Power cut: no Stop, and the next start is an hour later.
+	clk.Advance(time.Hour)
+	second, err := Start(path, clk)
+	if err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	prev, ok := second.Previous()
+	if !ok || prev.Clean || !prev.StartTS.Equal(start) {
+		t.Fatalf("previous = %+v, %v", prev, ok)
+	}
+	if second.Gap() != time.Hour {
+		t.Fatalf("gap = %s, want 1h", second.Gap())
+	}
+
+	clk.Advance(time.Minute)
+	if err := second.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	third, _ := Start(path, clk)
+	if prev, _ := third.Previous(); !prev.Clean || third.Gap() != 0 {
+		t.Fatalf("previous = %+v, gap %s", prev, third.Gap())
+	}
+}
+
+func TestCorruptFileStillStarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "heartbeat.json")
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := Start(path, clock.NewVirtual(time.Unix(100, 0)))
+	if err == nil {
+		t.Fatal("corrupt heartbeat was not reported")
+	}
+	if _, ok := tr.Previous(); ok || !tr.PreviousLost() {
+		t.Fatal("corrupt heartbeat was used or not reported as lost")
+	}
+	if again, err := Start(path, clock.NewVirtual(time.Unix(200, 0))); err != nil || again.Gap() != 100*time.Second {
+		t.Fatalf("restart after corrupt file: gap %s, err %v", again.Gap(), err)
+	}
+}
@@ -0,0 +1,180 @@
+package hostenv
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/iaserrat/edgeprobe/internal/diagnosis"
+)
+
+var (
+	procARPPath      = "/proc/net/arp"
+	resolvConfPath   = "/etc/resolv.conf"
+	resolvedConfPath = "/run/systemd/resolve/resolv.conf"
+)
+
+// NTP sync states.
+const (
+	NTPSynced   = "synced"
+	NTPUnsynced = "unsynced"
+	NTPUnknown  = "unknown"
+)
+
+// Config holds what Collect cannot find out by itself. Interface pins the
+// interface like diagnosis.interface; empty follows the default route.
+type Config struct {
+	Interface  string
+	Version    string
+	ConfigHash string
+}
+
+// Snapshot describes the host the measurements are taken from. Fields that
+// cannot be read on this host are left empty.
+type Snapshot struct {
+	Interface  string   `json:"interface"`
+	MAC        string   `json:"mac"`
+	LocalIPs   []string `json:"local_ips"`
+	Gateway    string   `json:"gateway"`
+	GatewayMAC string   `json:"gateway_mac"`
+	DNSServers []string `json:"dns_servers"`
+	Kernel     string   `json:"kernel"`
+	Version    string   `json:"version"`
+	Commit     string   `json:"commit"`
+	ConfigHash string   `json:"config_sha256"`
+	NTPSync    string   `json:"ntp_sync"`
+}
+
+func Collect(cfg Config) Snapshot {
+	s := Snapshot{
+		Interface:  cfg.Interface,
+		Version:    cfg.Version,
+		Commit:     commit(),
+		ConfigHash: cfg.ConfigHash,
+		Kernel:     kernelVersion(),
+		NTPSync:    ntpSync(),
+		DNSServers: dnsServers(),
+	}
+
+	if iface, gw, err := diagnosis.DefaultRoute(); err == nil {
+		if s.Interface == "" {
+			s.Interface = iface
+		}
+		if s.Interface == iface {
+			s.Gateway = gw
+			s.GatewayMAC = arpLookup(gw)
+		}
+	}
+
+	if iface, err := net.InterfaceByName(s.Interface); err == nil {
+		s.MAC = iface.HardwareAddr.String()
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, a := range addrs {
+				s.LocalIPs = append(s.LocalIPs, a.String())
+			}
+			sort.Strings(s.LocalIPs)
+		}
+	}
+
+	return s
+}
+
+// Hash identifies a snapshot: equal snapshots have equal hashes.
+func (s Snapshot) Hash() string {
+	b, _ := json.Marshal(s)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Changed lists the JSON names of the fields that differ between a and b.
+func Changed(a, b Snapshot) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Tag.Get("json"))
+		}
+	}
+	return changed
+}
+
+// commit is the VCS revision the binary was built from, if go build
+// recorded one.
+func commit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	var rev, dirty string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				dirty = "-dirty"
+			}
+		}
+	}
+	if rev == "" {
+		return ""
+	}
+	return rev + dirty
+}
+
+// dnsServers reads the system resolvers. Behind the systemd-resolved stub
+// the upstream servers are reported instead of 127.0.0.53.
+func dnsServers() []string {
+	servers := nameservers(resolvConfPath)
+	if len(servers) == 1 && servers[0] == "127.0.0.53" {
+		if upstream := nameservers(resolvedConfPath); len(upstream) > 0 {
+			return upstream
+		}
+	}
+	return servers
+}
+
+func nameservers(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// arpLookup finds ip in the kernel's neighbour table.
+func arpLookup(ip string) string {
+	f, err := os.Open(procARPPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && fields[0] == ip && fields[3] != "00:00:00:00:00:00" {
+			return fields[3]
+		}
+	}
+	return ""
+}
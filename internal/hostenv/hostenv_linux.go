@@ -0,0 +1,25 @@
+package hostenv
+
+import "golang.org/x/sys/unix"
+
+func kernelVersion() string {
+	var u unix.Utsname
+	if err := unix.Uname(&u); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(u.Release[:])
+}
+
+// ntpSync asks the kernel whether its clock is disciplined, which holds for
+// ntpd, chrony and systemd-timesyncd alike.
+func ntpSync() string {
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	if err != nil {
+		return NTPUnknown
+	}
+	if state == unix.TIME_ERROR || tx.Status&unix.STA_UNSYNC != 0 {
+		return NTPUnsynced
+	}
+	return NTPSynced
+}
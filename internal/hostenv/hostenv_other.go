@@ -0,0 +1,11 @@
+//go:build !linux
+
+package hostenv
+
+func kernelVersion() string {
+	return ""
+}
+
+func ntpSync() string {
+	return NTPUnknown
+}
@@ -0,0 +1,66 @@
+package hostenv
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDNSServersBehindResolvedStub(t *testing.T) {
+	oldConf, oldResolved := resolvConfPath, resolvedConfPath
+	defer func() { resolvConfPath, resolvedConfPath = oldConf, oldResolved }()
+
+	resolvConfPath = writeFile(t, "resolv.conf", "# stub\nnameserver 127.0.0.53\noptions edns0\n")
+	resolvedConfPath = writeFile(t, "upstream.conf", "nameserver 192.168.1.1\nnameserver 1.1.1.1\nsearch lan\n")
+	if got, want := dnsServers(), []string{"192.168.1.1", "1.1.1.1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dns servers = %v, want %v", got, want)
+	}
+
+	resolvConfPath = writeFile(t, "plain.conf", "nameserver 9.9.9.9\n")
+	if got := dnsServers(); !reflect.DeepEqual(got, []string{"9.9.9.9"}) {
+		t.Fatalf("dns servers = %v", got)
+	}
+}
+
+func TestARPLookup(t *testing.T) {
+	old := procARPPath
+	defer func() { procARPPath = old }()
+
+	procARPPath = writeFile(t, "arp", `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0
+192.168.1.7      0x1         0x0         00:00:00:00:00:00     *        eth0
+`)
+	if got := arpLookup("192.168.1.1"); got != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("gateway mac = %q", got)
+	}
+	if got := arpLookup("192.168.1.7"); got != "" {
+		t.Fatalf("incomplete entry = %q", got)
+	}
+}
+
+func TestHashAndChanged(t *testing.T) {
+	a := Snapshot{Interface: "eth0", Gateway: "192.168.1.1", DNSServers: []string{"1.1.1.1"}, NTPSync: NTPSynced}
+	b := a
+	if a.Hash() != b.Hash() || len(Changed(a, b)) != 0 {
+		t.Fatal("equal snapshots differ")
+	}
+
+	b.Gateway = "10.0.0.1"
+	b.NTPSync = NTPUnsynced
+	if a.Hash() == b.Hash() {
+		t.Fatal("hash did not change")
+	}
+	if got := Changed(a, b); !reflect.DeepEqual(got, []string{"gateway", "ntp_sync"}) {
+		t.Fatalf("changed = %v", got)
+	}
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAgentRecordValidation(t *testing.T) {
+	logger := NewWriter(&flakyWriter{}, testConfig())
+	defer logger.Close()
+
+	tests := []struct {
+		name    string
+		rec     Emittable
+		wantErr string
+	}{
+		{
+			name: "no target",
+			rec:  &AgentStop{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "agent_stop"}}, Reason: "signal"},
+		},
+		{
+			name: "with target",
+			rec:  &ProbeError{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "probe_error", Target: "1.1.1.1"}}, Probe: "ping", Err: "boom"},
+		},
+		{
+			name:    "outage_id",
+			rec:     &ProbeError{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "probe_error", OutageID: "o-1"}}, Probe: "ping", Err: "boom"},
+			wantErr: "outage_id",
+		},
+		{
+			name:    "incident_id",
+			rec:     &AgentStop{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "agent_stop", IncidentID: "i-1"}}, Reason: "signal"},
+			wantErr: "incident_id",
+		},
+		{
+			name:    "record rules",
+			rec:     &WorkerRestart{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "worker_restart"}}, Worker: "dns"},
+			wantErr: "attempt",
+		},
+		{
+			name: "gap unknown",
+			rec:  &AgentGap{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "agent_gap"}}, Err: "decode heartbeat: unexpected end of JSON input"},
+		},
+		{
+			name:    "gap without times",
+			rec:     &AgentGap{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "agent_gap"}}},
+			wantErr: "last_alive_ts",
+		},
+		{
+			name:    "unknown type",
+			rec:     &AgentStop{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "path_change"}}, Reason: "signal"},
+			wantErr: "unknown type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := logger.Emit(tt.rec)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("emit: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("emit error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Outage records still need a target.
+	if err := logger.Emit(&PathChange{BaseEvent: BaseEvent{Type: "path_change", OutageID: "o-1"}}); err == nil {
+		t.Fatal("path_change without target was accepted")
+	}
+}
+
+func TestAgentRecordsInSeparateFile(t *testing.T) {
+	events, agent := &flakyWriter{}, &flakyWriter{}
+	cfg := testConfig()
+	cfg.AgentWriter = agent
+	logger := NewWriter(events, cfg)
+
+	logger.Emit(pathChange("1.1.1.1"))
+	if err := logger.Emit(&AgentStart{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "agent_start"}}, PID: 42}); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	logger.Flush()
+
+	if strings.Contains(events.String(), "agent_start") || !strings.Contains(events.String(), "path_change") {
+		t.Fatalf("event log = %q", events.String())
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(agent.String()), &rec); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if rec["type"] != "agent_start" || rec["class"] != ClassAgent || rec["seq"] != 2.0 || rec["pid"] != 42.0 {
+		t.Fatalf("agent record = %v", rec)
+	}
+	if h := logger.Health(); h.Written != 2 || h.QueueCapacity != 2*DefaultQueueSize {
+		t.Fatalf("health = %+v", h)
+	}
+	logger.Close()
+}
+
+func TestEnvHashOnOutageRecords(t *testing.T) {
+	w := &flakyWriter{}
+	logger := NewWriter(w, testConfig())
+	logger.SetEnvHash("abc123")
+
+	logger.Emit(pathChange("1.1.1.1"))
+	logger.Emit(&IntervalStats{BaseEvent: BaseEvent{Type: "interval_stats", Target: "1.1.1.1"}})
+	logger.Emit(&Environment{AgentEvent: AgentEvent{BaseEvent: BaseEvent{Type: "environment"}}, Hash: "abc123"})
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines", len(lines))
+	}
+	for i, want := range []string{"abc123", "", ""} {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(lines[i]), &rec); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if got, _ := rec["env_hash"].(string); got != want {
+			t.Fatalf("%s env_hash = %q, want %q", rec["type"], got, want)
+		}
+	}
+}
@@ -7,21 +7,34 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
-	"sync/atomic"
 	"time"
 
+	"github.com/iaserrat/edgeprobe/internal/clock"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type Logger struct {
 	mu          sync.Mutex
-	writer      io.WriteCloser
+	file        *fileQueue
+	agentFile   *fileQueue
+	closed      bool
 	seq         uint64
 	toolName    string
 	toolVersion string
 	hostID      string
+	clock       clock.Clock
+	clockSource string
+	envHash     string
+	sinks       []*sinkQueue
 }
 
+// Config describes the log destination and the identity stamped on every
+// record. Clock defaults to the system clock; ClockSource names it in
+// clock_source and must be set to "replay" for virtual time. Records go to
+// the log file and to each of Sinks through their own bounded queues;
+// QueueSize sizes the file's and defaults to DefaultQueueSize. Agent records
+// go to AgentFile in Dir (AgentWriter for NewWriter) when it is set, and to
+// the main log otherwise.
 type Config struct {
 	Dir         string
 	MaxMB       int
@@ -29,6 +42,12 @@ type Config struct {
 	ToolName    string
 	ToolVersion string
 	HostID      string
+	Clock       clock.Clock
+	ClockSource string
+	QueueSize   int
+	Sinks       []SinkConfig
+	AgentFile   string
+	AgentWriter io.WriteCloser
 }
 
 func New(cfg Config) (*Logger, error) {
@@ -37,35 +56,156 @@ func New(cfg Config) (*Logger, error) {
 	}
 
 	logPath := filepath.Join(cfg.Dir, "edgeprobe.jsonl")
-	lj := &lumberjack.Logger{
-		Filename:   logPath,
+	if cfg.AgentFile != "" {
+		cfg.AgentWriter = rotated(filepath.Join(cfg.Dir, cfg.AgentFile), cfg)
+	}
+	return NewWriter(rotated(logPath, cfg), cfg), nil
+}
+
+func rotated(path string, cfg Config) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   path,
 		MaxSize:    cfg.MaxMB,
 		MaxBackups: cfg.MaxFiles,
 		Compress:   false,
 	}
+}
+
+// NewWriter creates a logger that writes JSONL to w instead of a rotated
+// file in cfg.Dir.
+func NewWriter(w io.WriteCloser, cfg Config) *Logger {
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+	source := cfg.ClockSource
+	if source == "" {
+		source = ClockSystem
+	}
+
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
 
-	return &Logger{
-		writer:      lj,
+	l := &Logger{
+		file:        startFileQueue(w, clk, size),
 		toolName:    cfg.ToolName,
 		toolVersion: cfg.ToolVersion,
 		hostID:      cfg.HostID,
-	}, nil
+		clock:       clk,
+		clockSource: source,
+	}
+	if cfg.AgentWriter != nil {
+		l.agentFile = startFileQueue(cfg.AgentWriter, clk, size)
+	}
+	for _, sc := range cfg.Sinks {
+		l.sinks = append(l.sinks, startSink(sc))
+	}
+	return l
 }
 
+// Close writes the records still queued to the log files and closes them
+// first, since they are the evidence that must survive. The sinks then get
+// closeTimeout between them to deliver what they hold. Records emitted after
+// Close are rejected.
 func (l *Logger) Close() error {
-	if l == nil || l.writer == nil {
+	if l == nil || l.file == nil {
 		return nil
 	}
 
-	return l.writer.Close()
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	err := l.file.close()
+	if l.agentFile != nil {
+		if aerr := l.agentFile.close(); err == nil {
+			err = aerr
+		}
+	}
+
+	deadline := l.clock.Now().Add(closeTimeout)
+	for _, q := range l.sinks {
+		if serr := q.close(l.clock, deadline.Sub(l.clock.Now())); serr != nil {
+			fmt.Fprintf(os.Stderr, "close log sink %s: %v\n", q.cfg.Name, serr)
+		}
+	}
+	return err
+}
+
+// Flush waits until every record emitted so far has been written to the log
+// files or dropped. Emit is not held up while it waits.
+func (l *Logger) Flush() {
+	l.mu.Lock()
+	files := []*fileQueue{l.file}
+	if l.agentFile != nil {
+		files = append(files, l.agentFile)
+	}
+	sent := make([]uint64, len(files))
+	for i, q := range files {
+		sent[i] = q.sent.Load()
+	}
+	l.mu.Unlock()
+
+	for i, q := range files {
+		q.flush(sent[i])
+	}
+}
+
+// Health reports the file queue and sink counters. With a separate agent
+// file the two queues are added up.
+func (l *Logger) Health() Health {
+	h := l.file.health()
+	if l.agentFile != nil {
+		a := l.agentFile.health()
+		h.QueueDepth += a.QueueDepth
+		h.QueueCapacity += a.QueueCapacity
+		h.Written += a.Written
+		h.Dropped += a.Dropped
+		h.Delayed += a.Delayed
+		h.WriteErrors += a.WriteErrors
+		if a.LastError != "" {
+			h.LastError = a.LastError
+		}
+	}
+	for _, q := range l.sinks {
+		h.Sinks = append(h.Sinks, q.stats())
+	}
+	return h
+}
+
+// SetEnvHash sets the env_hash stamped on outage and incident records from
+// now on.
+func (l *Logger) SetEnvHash(hash string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.envHash = hash
 }
 
 type Emittable interface {
 	Base() *BaseEvent
 }
 
+// agentRecord is implemented by records embedding AgentEvent.
+type agentRecord interface {
+	agent() *AgentEvent
+}
+
+// validator is implemented by records with rules beyond the base event.
+type validator interface {
+	validate() error
+}
+
+// Emit stamps record and queues it for the log file and the sinks. It only
+// fails for records that are invalid or emitted after Close; write errors
+// are retried in the background and show up in Health.
 func (l *Logger) Emit(record Emittable) error {
-	if l == nil || l.writer == nil {
+	if l == nil || l.file == nil {
 		return fmt.Errorf("logger not initialized")
 	}
 	if record == nil {
@@ -77,11 +217,18 @@ func (l *Logger) Emit(record Emittable) error {
 		return fmt.Errorf("log record missing base event")
 	}
 
-	now := time.Now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return fmt.Errorf("logger closed")
+	}
+
+	now := l.clock.Now().UTC()
 	base.TSUTC = now.Format(time.RFC3339Nano)
 	base.TSUnixMS = now.UnixMilli()
-	base.Seq = atomic.AddUint64(&l.seq, 1)
-	base.ClockSource = "system"
+	base.Seq = l.seq + 1
+	base.ClockSource = l.clockSource
 	base.SchemaVersion = 2
 	if base.ToolName == "" {
 		base.ToolName = l.toolName
@@ -92,10 +239,29 @@ func (l *Logger) Emit(record Emittable) error {
 	if base.HostID == "" {
 		base.HostID = l.hostID
 	}
+	if base.EnvHash == "" && (base.OutageID != "" || base.IncidentID != "") {
+		base.EnvHash = l.envHash
+	}
+
+	file := l.file
+	if a, ok := record.(agentRecord); ok {
+		a.agent().Class = ClassAgent
+		if !agentTypes[base.Type] {
+			return fmt.Errorf("agent record has unknown type %q", base.Type)
+		}
+		if l.agentFile != nil {
+			file = l.agentFile
+		}
+	}
 
 	if err := validateBase(base); err != nil {
 		return err
 	}
+	if v, ok := record.(validator); ok {
+		if err := v.validate(); err != nil {
+			return err
+		}
+	}
 
 	b, err := json.Marshal(record)
 	if err != nil {
@@ -103,18 +269,65 @@ func (l *Logger) Emit(record Emittable) error {
 	}
 
 	b = append(b, '\n')
+	l.seq++
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	file.enqueue(b)
+	for _, q := range l.sinks {
+		q.offer(base, b)
+	}
+	return nil
+}
 
-	_, err = l.writer.Write(b)
-	return err
+// EmitHealth logs a logger_health record with the current counters.
+func (l *Logger) EmitHealth() error {
+	h := l.Health()
+	rec := &LoggerHealth{
+		AgentEvent:    AgentEvent{BaseEvent: BaseEvent{Type: "logger_health"}},
+		QueueDepth:    h.QueueDepth,
+		QueueCapacity: h.QueueCapacity,
+		Written:       h.Written,
+		Dropped:       h.Dropped,
+		Delayed:       h.Delayed,
+		WriteErrors:   h.WriteErrors,
+		LastError:     h.LastError,
+	}
+	for _, s := range h.Sinks {
+		rec.Sinks = append(rec.Sinks, SinkHealth{Name: s.Name, Dropped: s.Dropped, Failed: s.Failed})
+	}
+	return l.Emit(rec)
 }
 
 func (l *Logger) Write(record Emittable) error {
 	return l.Emit(record)
 }
 
+const (
+	ClockSystem = "system"
+	ClockReplay = "replay"
+)
+
+// ClassAgent is the class of records about edgeprobe itself.
+const ClassAgent = "agent"
+
+var steadyStateTypes = map[string]bool{
+	"interval_stats":      true,
+	"availability_report": true,
+	"anomaly":             true,
+}
+
+// agentTypes describe edgeprobe itself rather than a target's connectivity.
+var agentTypes = map[string]bool{
+	"agent_start":    true,
+	"agent_stop":     true,
+	"config_loaded":  true,
+	"probe_error":    true,
+	"worker_restart": true,
+	"agent_gap":      true,
+	"heartbeat":      true,
+	"environment":    true,
+	"logger_health":  true,
+}
+
 func validateBase(base *BaseEvent) error {
 	if base.TSUTC == "" || base.TSUnixMS == 0 {
 		return fmt.Errorf("invalid timestamps on log record")
@@ -122,11 +335,17 @@ func validateBase(base *BaseEvent) error {
 	if base.Type == "" {
 		return fmt.Errorf("log record missing type")
 	}
-	if base.Target == "" {
-		return fmt.Errorf("log record missing target")
-	}
-	if base.OutageID == "" {
-		return fmt.Errorf("log record missing outage_id")
+	if agentTypes[base.Type] {
+		if err := validateAgent(base); err != nil {
+			return err
+		}
+	} else {
+		if base.Target == "" {
+			return fmt.Errorf("log record missing target")
+		}
+		if base.OutageID == "" && !steadyStateTypes[base.Type] {
+			return fmt.Errorf("log record missing outage_id")
+		}
 	}
 	if base.ToolName == "" {
 		return fmt.Errorf("log record missing tool_name")
@@ -140,9 +359,21 @@ func validateBase(base *BaseEvent) error {
 	if base.SchemaVersion != 2 {
 		return fmt.Errorf("log record schema_version must be 2")
 	}
-	if base.ClockSource != "system" {
-		return fmt.Errorf("log record clock_source must be system")
+	if base.ClockSource != ClockSystem && base.ClockSource != ClockReplay {
+		return fmt.Errorf("log record clock_source must be %s or %s", ClockSystem, ClockReplay)
 	}
 
 	return nil
 }
+
+// validateAgent applies the agent record rules: target is optional, and they
+// never belong to an outage or incident.
+func validateAgent(base *BaseEvent) error {
+	if base.OutageID != "" {
+		return fmt.Errorf("%s record must not carry outage_id", base.Type)
+	}
+	if base.IncidentID != "" {
+		return fmt.Errorf("%s record must not carry incident_id", base.Type)
+	}
+	return nil
+}
@@ -82,6 +82,8 @@ func TestEmitPopulatesBaseFields(t *testing.T) {
 		}
 	}
 
+	logger.Flush()
+
 	logPath := filepath.Join(dir, "edgeprobe.jsonl")
 	data, err := os.ReadFile(logPath)
 	if err != nil {
@@ -0,0 +1,187 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+)
+
+// Backpressure and retry timing for the log file.
+const (
+	// enqueueWait is how long Emit waits for room in a full queue before
+	// dropping the record.
+	enqueueWait = 100 * time.Millisecond
+	retryMin    = 100 * time.Millisecond
+	retryMax    = 5 * time.Second
+	// closeTimeout bounds how long Close keeps retrying a failing file.
+	closeTimeout = 5 * time.Second
+)
+
+// Health counts what happened to records since the logger started. Delayed
+// records were written late, after waiting for queue space or a write retry;
+// Dropped ones never reached the file.
+type Health struct {
+	QueueDepth    int
+	QueueCapacity int
+	Written       uint64
+	Dropped       uint64
+	Delayed       uint64
+	WriteErrors   uint64
+	LastError     string
+	Sinks         []SinkStats
+}
+
+// fileQueue writes lines to the log file from its own goroutine, retrying
+// failed writes (a full disk, say) with backoff so the event path never
+// waits on the disk.
+type fileQueue struct {
+	w     io.WriteCloser
+	clk   clock.Clock
+	ch    chan []byte
+	done  chan struct{}
+	abort chan struct{}
+
+	// sent counts lines put on ch and handled those written or dropped by
+	// run; flush waits for handled to catch up.
+	sent        atomic.Uint64
+	written     atomic.Uint64
+	dropped     atomic.Uint64
+	delayed     atomic.Uint64
+	writeErrors atomic.Uint64
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	handled uint64
+	lastErr string
+}
+
+func startFileQueue(w io.WriteCloser, clk clock.Clock, size int) *fileQueue {
+	q := &fileQueue{
+		w:     w,
+		clk:   clk,
+		ch:    make(chan []byte, size),
+		done:  make(chan struct{}),
+		abort: make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// enqueue is called with the logger's lock held, so lines keep seq order.
+func (q *fileQueue) enqueue(line []byte) {
+	select {
+	case q.ch <- line:
+		q.sent.Add(1)
+		return
+	default:
+	}
+
+	timer := q.clk.NewTimer(enqueueWait)
+	defer timer.Stop()
+	select {
+	case q.ch <- line:
+		q.sent.Add(1)
+		q.delayed.Add(1)
+	case <-timer.C():
+		q.dropped.Add(1)
+	}
+}
+
+// flush waits until the first n lines sent have been written or dropped.
+func (q *fileQueue) flush(n uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.handled < n {
+		q.cond.Wait()
+	}
+}
+
+func (q *fileQueue) run() {
+	defer close(q.done)
+
+	for line := range q.ch {
+		q.write(line)
+
+		q.mu.Lock()
+		q.handled++
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}
+}
+
+func (q *fileQueue) write(line []byte) {
+	backoff := retryMin
+	for attempt := 0; ; attempt++ {
+		n, err := q.w.Write(line)
+		if err == nil {
+			q.written.Add(1)
+			if attempt > 0 {
+				q.delayed.Add(1)
+				fmt.Fprintf(os.Stderr, "log write recovered after %d attempts\n", attempt+1)
+			}
+			return
+		}
+
+		q.writeErrors.Add(1)
+		q.mu.Lock()
+		q.lastErr = err.Error()
+		q.mu.Unlock()
+		if attempt == 0 {
+			fmt.Fprintf(os.Stderr, "log write failed: %v (retrying)\n", err)
+		}
+		if n > 0 && n < len(line) {
+			// End the fragment so the retried line starts on its own; readers
+			// skip the broken one.
+			line = append([]byte{'\n'}, line...)
+		}
+
+		timer := q.clk.NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-q.abort:
+			timer.Stop()
+			q.dropped.Add(1)
+			return
+		}
+		backoff = min(2*backoff, retryMax)
+	}
+}
+
+// close writes what is queued, giving a failing file closeTimeout before the
+// rest is dropped, then closes the file.
+func (q *fileQueue) close() error {
+	close(q.ch)
+
+	timer := q.clk.NewTimer(closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-q.done:
+	case <-timer.C():
+		close(q.abort)
+		<-q.done
+	}
+	return q.w.Close()
+}
+
+func (q *fileQueue) health() Health {
+	q.mu.Lock()
+	lastErr := q.lastErr
+	q.mu.Unlock()
+
+	return Health{
+		QueueDepth:    len(q.ch),
+		QueueCapacity: cap(q.ch),
+		Written:       q.written.Load(),
+		Dropped:       q.dropped.Load(),
+		Delayed:       q.delayed.Load(),
+		WriteErrors:   q.writeErrors.Load(),
+		LastError:     lastErr,
+	}
+}
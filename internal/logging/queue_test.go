@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyWriter fails its first failures writes, the first one after writing
+// part of the line, like a disk that fills up mid-record.
+type flakyWriter struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	failures int
+	gate     chan struct{}
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if w.gate != nil {
+		<-w.gate
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failures > 0 {
+		w.failures--
+		n := 0
+		if w.buf.Len() == 0 {
+			n, _ = w.buf.Write(p[:5])
+		}
+		return n, syscall.ENOSPC
+	}
+	return w.buf.Write(p)
+}
+
+func (w *flakyWriter) Close() error { return nil }
+
+func (w *flakyWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func testConfig() Config {
+	return Config{ToolName: "edgeprobe", ToolVersion: "test", HostID: "host-1"}
+}
+
+func pathChange(target string) *PathChange {
+	return &PathChange{BaseEvent: BaseEvent{Type: "path_change", Target: target, OutageID: "o-1"}}
+}
+
+func TestWriteErrorsAreRetried(t *testing.T) {
+	w := &flakyWriter{failures: 2}
+	logger := NewWriter(w, testConfig())
+
+	for _, target := range []string{"1.1.1.1", "8.8.8.8"} {
+		if err := logger.Emit(pathChange(target)); err != nil {
+			t.Fatalf("emit while the disk is full: %v", err)
+		}
+	}
+	logger.Flush()
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	if len(lines) != 3 || json.Valid([]byte(lines[0])) || !json.Valid([]byte(lines[1])) || !json.Valid([]byte(lines[2])) {
+		t.Fatalf("log = %q, want a cut-off fragment then two whole records", w.String())
+	}
+
+	h := logger.Health()
+	if h.Written != 2 || h.WriteErrors != 2 || h.Delayed != 1 || h.Dropped != 0 || !strings.Contains(h.LastError, "no space") {
+		t.Fatalf("health = %+v", h)
+	}
+	logger.Close()
+}
+
+func TestFullQueueDropsWithoutBlocking(t *testing.T) {
+	w := &flakyWriter{gate: make(chan struct{})}
+	cfg := testConfig()
+	cfg.QueueSize = 1
+	logger := NewWriter(w, cfg)
+
+	const n = 4
+	for i := 0; i < n; i++ {
+		if err := logger.Emit(pathChange("1.1.1.1")); err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	}
+	close(w.gate)
+	logger.Close()
+
+	h := logger.Health()
+	if h.Dropped < 2 || h.Written+h.Dropped != n {
+		t.Fatalf("health = %+v, want the overflow dropped and counted", h)
+	}
+	if err := logger.Emit(pathChange("1.1.1.1")); err == nil {
+		t.Fatalf("emit after close succeeded")
+	}
+}
+
+func TestEmitHealth(t *testing.T) {
+	w := &flakyWriter{}
+	cfg := testConfig()
+	cfg.Sinks = []SinkConfig{{Name: "stdout", Sink: NewWriterSink(&bytes.Buffer{})}}
+	logger := NewWriter(w, cfg)
+
+	logger.Emit(pathChange("1.1.1.1"))
+	logger.Flush()
+	if err := logger.EmitHealth(); err != nil {
+		t.Fatalf("emit health: %v", err)
+	}
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &rec); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if rec["type"] != "logger_health" || rec["written"] != 1.0 || rec["queue_capacity"] != float64(DefaultQueueSize) {
+		t.Fatalf("record = %v", rec)
+	}
+	if sinks, ok := rec["sinks"].([]any); !ok || len(sinks) != 1 {
+		t.Fatalf("sinks = %v", rec["sinks"])
+	}
+}
+
+func TestFlushDoesNotBlockEmit(t *testing.T) {
+	w := &flakyWriter{gate: make(chan struct{})}
+	logger := NewWriter(w, testConfig())
+	logger.Emit(pathChange("1.1.1.1"))
+
+	flushed := make(chan struct{})
+	go func() {
+		logger.Flush()
+		close(flushed)
+	}()
+
+	emitted := make(chan error, 1)
+	go func() { emitted <- logger.Emit(pathChange("8.8.8.8")) }()
+	select {
+	case err := <-emitted:
+		if err != nil {
+			t.Fatalf("emit: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("emit waited for a flush stuck on the disk")
+	}
+
+	close(w.gate)
+	<-flushed
+	logger.Close()
+	if got := strings.Count(w.String(), "\n"); got != 2 {
+		t.Fatalf("log file has %d lines, want 2", got)
+	}
+}
@@ -1,6 +1,9 @@
 package logging
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type BaseEvent struct {
 	TSUTC         string `json:"ts_utc"`
@@ -9,11 +12,13 @@ type BaseEvent struct {
 	Type          string `json:"type"`
 	Target        string `json:"target"`
 	OutageID      string `json:"outage_id"`
+	IncidentID    string `json:"incident_id"`
 	SchemaVersion int    `json:"schema_version"`
 	ToolName      string `json:"tool_name"`
 	ToolVersion   string `json:"tool_version"`
 	HostID        string `json:"host_id"`
 	ClockSource   string `json:"clock_source"`
+	EnvHash       string `json:"env_hash,omitempty"`
 }
 
 func (b *BaseEvent) Base() *BaseEvent {
@@ -22,25 +27,60 @@ func (b *BaseEvent) Base() *BaseEvent {
 
 type DegradationRecord struct {
 	BaseEvent
-	Reason              string  `json:"reason"`
-	LossPct             float64 `json:"loss_pct"`
-	RttP95Ms            float64 `json:"rtt_p95_ms"`
-	ConsecutiveFailures int     `json:"consecutive_failures"`
+	ImpactStartTS       *time.Time    `json:"impact_start_ts,omitempty"`
+	ImpactEndTS         *time.Time    `json:"impact_end_ts,omitempty"`
+	Reason              string        `json:"reason"`
+	LossPct             float64       `json:"loss_pct"`
+	RttP95Ms            float64       `json:"rtt_p95_ms"`
+	MOS                 float64       `json:"mos"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	Windows             []WindowStats `json:"windows"`
+}
+
+type WindowStats struct {
+	WindowSecs int     `json:"window_secs"`
+	Samples    int     `json:"samples"`
+	LossPct    float64 `json:"loss_pct"`
+	RttP95Ms   float64 `json:"rtt_p95_ms"`
+	RttAvgMs   float64 `json:"rtt_avg_ms"`
+	JitterMs   float64 `json:"jitter_ms"`
+	RFactor    float64 `json:"r_factor"`
+	MOS        float64 `json:"mos"`
 }
 
 type OutageSummary struct {
 	BaseEvent
-	StartTS            time.Time `json:"start_ts"`
-	EndTS              time.Time `json:"end_ts"`
-	DurationMs         int64     `json:"duration_ms"`
-	LossPctMax         float64   `json:"loss_pct_max"`
-	RttP95MaxMs        float64   `json:"rtt_p95_max_ms"`
-	RttAvgMaxMs        float64   `json:"rtt_avg_max_ms"`
-	ConsecutiveFailMax int       `json:"consecutive_failures_max"`
-	PingSent           int       `json:"ping_sent"`
-	PingRecv           int       `json:"ping_recv"`
-	DNSErrors          int       `json:"dns_errors"`
-	TracerouteCount    int       `json:"traceroute_count"`
+	StartTS            time.Time     `json:"start_ts"`
+	EndTS              time.Time     `json:"end_ts"`
+	DurationMs         int64         `json:"duration_ms"`
+	EndReason          string        `json:"end_reason"`
+	ImpactStartTS      time.Time     `json:"impact_start_ts"`
+	ImpactEndTS        time.Time     `json:"impact_end_ts"`
+	ImpactDurationMs   int64         `json:"impact_duration_ms"`
+	LossPctMax         float64       `json:"loss_pct_max"`
+	RttP95MaxMs        float64       `json:"rtt_p95_max_ms"`
+	RttAvgMaxMs        float64       `json:"rtt_avg_max_ms"`
+	MOSMin             float64       `json:"mos_min"`
+	ConsecutiveFailMax int           `json:"consecutive_failures_max"`
+	PingSent           int           `json:"ping_sent"`
+	PingRecv           int           `json:"ping_recv"`
+	DNSErrors          int           `json:"dns_errors"`
+	TracerouteCount    int           `json:"traceroute_count"`
+	FlapCount          int           `json:"flap_count"`
+	WindowsMax         []WindowStats `json:"windows_max"`
+}
+
+type FlappingStart struct {
+	BaseEvent
+	StartTS time.Time `json:"start_ts"`
+	Changes int       `json:"state_changes"`
+}
+
+type FlappingEnd struct {
+	BaseEvent
+	StartTS   time.Time `json:"start_ts"`
+	EndTS     time.Time `json:"end_ts"`
+	FlapCount int       `json:"flap_count"`
 }
 
 type TracerouteResult struct {
@@ -63,3 +103,268 @@ type PathChange struct {
 	PrevHops     []TracerouteHop `json:"prev_hops"`
 	NewHops      []TracerouteHop `json:"new_hops"`
 }
+
+type IncidentStart struct {
+	BaseEvent
+	StartTS       time.Time `json:"start_ts"`
+	ImpactStartTS time.Time `json:"impact_start_ts"`
+}
+
+type IncidentEnd struct {
+	BaseEvent
+	StartTS       time.Time `json:"start_ts"`
+	EndTS         time.Time `json:"end_ts"`
+	DurationMs    int64     `json:"duration_ms"`
+	ImpactStartTS time.Time `json:"impact_start_ts"`
+	ImpactEndTS   time.Time `json:"impact_end_ts"`
+	Scope         string    `json:"scope"`
+	Targets       []string  `json:"targets"`
+	OutageIDs     []string  `json:"outage_ids"`
+	TargetsTotal  int       `json:"targets_total"`
+}
+
+type Diagnosis struct {
+	BaseEvent
+	FaultDomain string   `json:"fault_domain"`
+	Confidence  string   `json:"confidence"`
+	Evidence    []string `json:"evidence"`
+}
+
+type IntervalStats struct {
+	BaseEvent
+	StartTS       time.Time `json:"start_ts"`
+	EndTS         time.Time `json:"end_ts"`
+	Sent          int       `json:"sent"`
+	Recv          int       `json:"recv"`
+	LossPct       float64   `json:"loss_pct"`
+	RttMinMs      float64   `json:"rtt_min_ms"`
+	RttAvgMs      float64   `json:"rtt_avg_ms"`
+	RttP50Ms      float64   `json:"rtt_p50_ms"`
+	RttP95Ms      float64   `json:"rtt_p95_ms"`
+	RttMaxMs      float64   `json:"rtt_max_ms"`
+	JitterMs      float64   `json:"jitter_ms"`
+	RFactor       float64   `json:"r_factor"`
+	MOS           float64   `json:"mos"`
+	DNSSent       int       `json:"dns_sent"`
+	DNSOK         int       `json:"dns_ok"`
+	DNSSuccessPct float64   `json:"dns_success_pct"`
+}
+
+type AvailabilityReport struct {
+	BaseEvent
+	Period                 string    `json:"period"`
+	PeriodStartTS          time.Time `json:"period_start_ts"`
+	PeriodEndTS            time.Time `json:"period_end_ts"`
+	AsOfTS                 time.Time `json:"as_of_ts"`
+	Final                  bool      `json:"final"`
+	DowntimeMs             int64     `json:"downtime_ms"`
+	AvailabilityPct        float64   `json:"availability_pct"`
+	Outages                int       `json:"outages"`
+	SLAPct                 float64   `json:"sla_pct,omitempty"`
+	AllowedDowntimeMs      int64     `json:"allowed_downtime_ms"`
+	ErrorBudgetRemainingMs int64     `json:"error_budget_remaining_ms"`
+	SLAMet                 *bool     `json:"sla_met,omitempty"`
+}
+
+type Anomaly struct {
+	BaseEvent
+	Metric         string  `json:"metric"`
+	Value          float64 `json:"value"`
+	BaselineMean   float64 `json:"baseline_mean"`
+	BaselineStdDev float64 `json:"baseline_stddev"`
+	ZScore         float64 `json:"z_score"`
+	HourOfWeek     int     `json:"hour_of_week"`
+	Samples        int     `json:"baseline_samples"`
+}
+
+type ProbeSample struct {
+	BaseEvent
+	Phase    string    `json:"phase"`
+	SampleTS time.Time `json:"sample_ts"`
+	Seq      int       `json:"seq"`
+	OK       bool      `json:"ok"`
+	RttMs    *float64  `json:"rtt_ms"`
+	Failure  string    `json:"failure,omitempty"`
+}
+
+type PcapSaved struct {
+	BaseEvent
+	Path       string    `json:"path"`
+	Interface  string    `json:"interface"`
+	Filter     string    `json:"filter"`
+	Packets    int       `json:"packets"`
+	Bytes      int64     `json:"bytes"`
+	StartTS    time.Time `json:"start_ts"`
+	EndTS      time.Time `json:"end_ts"`
+	StopReason string    `json:"stop_reason"`
+	Err        string    `json:"err,omitempty"`
+}
+
+// AgentEvent is the base of agent records, which describe edgeprobe itself
+// rather than a target: its lifecycle, configuration and own errors. They
+// never carry an outage_id or incident_id; target is set only when the
+// record is about one target's worker. Class is always "agent".
+type AgentEvent struct {
+	BaseEvent
+	Class string `json:"class"`
+}
+
+func (a *AgentEvent) agent() *AgentEvent {
+	return a
+}
+
+type AgentStart struct {
+	AgentEvent
+	PID        int    `json:"pid"`
+	ConfigPath string `json:"config_path"`
+	GoVersion  string `json:"go_version"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+}
+
+func (r *AgentStart) validate() error {
+	if r.PID <= 0 {
+		return fmt.Errorf("agent_start missing pid")
+	}
+	return nil
+}
+
+type AgentStop struct {
+	AgentEvent
+	Reason   string `json:"reason"`
+	Err      string `json:"err,omitempty"`
+	UptimeMs int64  `json:"uptime_ms"`
+}
+
+func (r *AgentStop) validate() error {
+	if r.Reason == "" {
+		return fmt.Errorf("agent_stop missing reason")
+	}
+	return nil
+}
+
+type ConfigLoaded struct {
+	AgentEvent
+	Path    string   `json:"path"`
+	SHA256  string   `json:"sha256"`
+	Targets []string `json:"targets"`
+}
+
+func (r *ConfigLoaded) validate() error {
+	if r.Path == "" || r.SHA256 == "" {
+		return fmt.Errorf("config_loaded missing path or sha256")
+	}
+	return nil
+}
+
+// ProbeError is written when a probe worker stops with an error. Fatal ones
+// stop the daemon; the rest are followed by a worker_restart.
+type ProbeError struct {
+	AgentEvent
+	Probe string `json:"probe"`
+	Err   string `json:"err"`
+	Fatal bool   `json:"fatal"`
+}
+
+func (r *ProbeError) validate() error {
+	if r.Probe == "" || r.Err == "" {
+		return fmt.Errorf("probe_error missing probe or err")
+	}
+	return nil
+}
+
+type WorkerRestart struct {
+	AgentEvent
+	Worker    string `json:"worker"`
+	Attempt   int    `json:"attempt"`
+	BackoffMs int64  `json:"backoff_ms"`
+}
+
+func (r *WorkerRestart) validate() error {
+	if r.Worker == "" || r.Attempt <= 0 {
+		return fmt.Errorf("worker_restart missing worker or attempt")
+	}
+	return nil
+}
+
+// AgentGap is written at startup when edgeprobe was down for longer than
+// the configured threshold, so the gap is not mistaken for a quiet period.
+// When the previous run's heartbeat could not be read the gap is unknown:
+// the times are left out and Err says why.
+type AgentGap struct {
+	AgentEvent
+	LastAliveTS *time.Time `json:"last_alive_ts,omitempty"`
+	GapMs       int64      `json:"gap_ms,omitempty"`
+	PrevStartTS *time.Time `json:"prev_start_ts,omitempty"`
+	CleanStop   bool       `json:"clean_stop"`
+	Err         string     `json:"err,omitempty"`
+}
+
+func (r *AgentGap) validate() error {
+	if r.Err == "" && (r.LastAliveTS == nil || r.GapMs <= 0) {
+		return fmt.Errorf("agent_gap missing last_alive_ts or gap_ms")
+	}
+	return nil
+}
+
+// Heartbeat marks edgeprobe as running; one every IntervalMs.
+type Heartbeat struct {
+	AgentEvent
+	StartTS    time.Time `json:"start_ts"`
+	UptimeMs   int64     `json:"uptime_ms"`
+	IntervalMs int64     `json:"interval_ms"`
+}
+
+func (r *Heartbeat) validate() error {
+	if r.IntervalMs <= 0 {
+		return fmt.Errorf("heartbeat missing interval_ms")
+	}
+	return nil
+}
+
+// Environment describes the host the measurements are taken from. It is
+// written at startup and whenever the host changes; outage records carry
+// its Hash in env_hash.
+type Environment struct {
+	AgentEvent
+	Hash       string   `json:"hash"`
+	PrevHash   string   `json:"prev_hash,omitempty"`
+	Changed    []string `json:"changed,omitempty"`
+	Interface  string   `json:"interface"`
+	MAC        string   `json:"mac"`
+	LocalIPs   []string `json:"local_ips"`
+	Gateway    string   `json:"gateway"`
+	GatewayMAC string   `json:"gateway_mac"`
+	DNSServers []string `json:"dns_servers"`
+	Kernel     string   `json:"kernel"`
+	Version    string   `json:"version"`
+	Commit     string   `json:"commit"`
+	ConfigHash string   `json:"config_sha256"`
+	NTPSync    string   `json:"ntp_sync"`
+}
+
+func (r *Environment) validate() error {
+	if r.Hash == "" {
+		return fmt.Errorf("environment missing hash")
+	}
+	return nil
+}
+
+// LoggerHealth reports the logger's own counters.
+type LoggerHealth struct {
+	AgentEvent
+	QueueDepth    int          `json:"queue_depth"`
+	QueueCapacity int          `json:"queue_capacity"`
+	Written       uint64       `json:"written"`
+	Dropped       uint64       `json:"dropped"`
+	Delayed       uint64       `json:"delayed"`
+	WriteErrors   uint64       `json:"write_errors"`
+	LastError     string       `json:"last_error,omitempty"`
+	Sinks         []SinkHealth `json:"sinks,omitempty"`
+}
+
+type SinkHealth struct {
+	Name    string `json:"name"`
+	Dropped uint64 `json:"dropped"`
+	Failed  uint64 `json:"failed"`
+}
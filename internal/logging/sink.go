@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+)
+
+// DefaultQueueSize is used for a sink configured without a queue size.
+const DefaultQueueSize = 1024
+
+// Sink is an output for log records besides the log file. Write gets the
+// encoded JSONL line, trailing newline included.
+type Sink interface {
+	Write(base BaseEvent, line []byte) error
+	Close() error
+}
+
+// Filter selects the records a sink gets. An empty list matches everything.
+type Filter struct {
+	Types   []string
+	Targets []string
+}
+
+func (f Filter) Match(base *BaseEvent) bool {
+	return matches(f.Types, base.Type) && matches(f.Targets, base.Target)
+}
+
+func matches(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SinkConfig attaches a sink to a logger.
+type SinkConfig struct {
+	Name      string
+	Sink      Sink
+	Filter    Filter
+	QueueSize int
+}
+
+// SinkStats counts the records a sink lost: Dropped because its queue was
+// full, Failed because Write returned an error.
+type SinkStats struct {
+	Name    string
+	Dropped uint64
+	Failed  uint64
+}
+
+type queuedRecord struct {
+	base BaseEvent
+	line []byte
+}
+
+// sinkQueue feeds one sink from its own goroutine, so a slow or failing sink
+// only ever loses its own records.
+type sinkQueue struct {
+	cfg     SinkConfig
+	ch      chan queuedRecord
+	done    chan struct{}
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+func startSink(cfg SinkConfig) *sinkQueue {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	q := &sinkQueue{cfg: cfg, ch: make(chan queuedRecord, cfg.QueueSize), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+func (q *sinkQueue) offer(base *BaseEvent, line []byte) {
+	if !q.cfg.Filter.Match(base) {
+		return
+	}
+	select {
+	case q.ch <- queuedRecord{base: *base, line: line}:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+func (q *sinkQueue) run() {
+	defer close(q.done)
+
+	failing := false
+	for rec := range q.ch {
+		err := q.write(rec)
+		switch {
+		case err != nil && !failing:
+			fmt.Fprintf(os.Stderr, "log sink %s: %v (further errors suppressed until it recovers)\n", q.cfg.Name, err)
+			failing = true
+		case err == nil && failing:
+			fmt.Fprintf(os.Stderr, "log sink %s: recovered after %d failed records\n", q.cfg.Name, q.failed.Load())
+			failing = false
+		}
+	}
+}
+
+// write turns a panicking sink into a failed record.
+func (q *sinkQueue) write(rec queuedRecord) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		if err != nil {
+			q.failed.Add(1)
+		}
+	}()
+	return q.cfg.Sink.Write(rec.base, rec.line)
+}
+
+// close delivers what is queued within wait, then closes the sink. A sink
+// still busy after wait is abandoned, unclosed, with its records.
+func (q *sinkQueue) close(clk clock.Clock, wait time.Duration) error {
+	close(q.ch)
+
+	timer := clk.NewTimer(max(wait, 0))
+	defer timer.Stop()
+	select {
+	case <-q.done:
+	case <-timer.C():
+		return fmt.Errorf("gave up after %s with %d records queued", closeTimeout, len(q.ch))
+	}
+	return q.cfg.Sink.Close()
+}
+
+func (q *sinkQueue) stats() SinkStats {
+	return SinkStats{Name: q.cfg.Name, Dropped: q.dropped.Load(), Failed: q.failed.Load()}
+}
+
+// WriterSink writes lines to w, such as os.Stdout.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(_ BaseEvent, line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.w.Write(line)
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+)
+
+type bufCloser struct {
+	mu sync.Mutex
+	bytes.Buffer
+}
+
+func (b *bufCloser) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *bufCloser) Close() error { return nil }
+
+type recordingSink struct {
+	mu    sync.Mutex
+	types []string
+	block chan struct{}
+	err   error
+}
+
+func (s *recordingSink) Write(base BaseEvent, _ []byte) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.types = append(s.types, base.Type+"/"+base.Target)
+	return s.err
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+type panickingSink struct{}
+
+func (panickingSink) Write(BaseEvent, []byte) error { panic("boom") }
+func (panickingSink) Close() error                  { return nil }
+
+func TestSinksFilterAndIsolateFailures(t *testing.T) {
+	file := &bufCloser{}
+	filtered := &recordingSink{}
+	stuck := &recordingSink{block: make(chan struct{})}
+	broken := &recordingSink{err: errors.New("disk gone")}
+
+	logger := NewWriter(file, Config{
+		ToolName:    "edgeprobe",
+		ToolVersion: "test",
+		HostID:      "host-1",
+		Sinks: []SinkConfig{
+			{Name: "filtered", Sink: filtered, Filter: Filter{Types: []string{"path_change"}, Targets: []string{"1.1.1.1"}}},
+			{Name: "stuck", Sink: stuck, QueueSize: 1},
+			{Name: "broken", Sink: broken},
+			{Name: "panics", Sink: panickingSink{}},
+		},
+	})
+
+	records := []struct{ typ, target string }{
+		{"path_change", "1.1.1.1"},
+		{"path_change", "8.8.8.8"},
+		{"outage_summary", "1.1.1.1"},
+		{"path_change", "1.1.1.1"},
+	}
+	for _, r := range records {
+		if err := logger.Emit(&PathChange{BaseEvent: BaseEvent{Type: r.typ, Target: r.target, OutageID: "o-1"}}); err != nil {
+			t.Fatalf("emit with a stuck sink: %v", err)
+		}
+	}
+	logger.Flush()
+	if got := strings.Count(file.String(), "\n"); got != len(records) {
+		t.Fatalf("log file has %d lines, want %d", got, len(records))
+	}
+
+	close(stuck.block)
+	if err := logger.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if got := strings.Join(filtered.types, " "); got != "path_change/1.1.1.1 path_change/1.1.1.1" {
+		t.Fatalf("filtered sink got %s", got)
+	}
+	stats := logger.Health().Sinks
+	// The stuck sink holds at most one record in Write and one in its queue.
+	if stats[1].Dropped < 2 || int(stats[1].Dropped)+len(stuck.types) != len(records) {
+		t.Fatalf("stuck sink stats = %+v, wrote %d", stats[1], len(stuck.types))
+	}
+	if stats[2].Failed != 4 || stats[3].Failed != 4 {
+		t.Fatalf("stats = %+v, want every record failed on the broken sinks", stats)
+	}
+}
+
+func TestCloseWritesFileBeforeHungSink(t *testing.T) {
+	file := &bufCloser{}
+	hung := &recordingSink{block: make(chan struct{})}
+	defer close(hung.block)
+
+	clk := clock.NewVirtual(time.Unix(1000, 0))
+	cfg := testConfig()
+	cfg.Clock = clk
+	cfg.Sinks = []SinkConfig{{Name: "hung", Sink: hung}}
+	logger := NewWriter(file, cfg)
+	for i := 0; i < 3; i++ {
+		logger.Emit(pathChange("1.1.1.1"))
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- logger.Close() }()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			if got := strings.Count(file.String(), "\n"); got != 3 {
+				t.Fatalf("log file has %d lines, want 3", got)
+			}
+			return
+		case <-time.After(time.Millisecond):
+			clk.Advance(time.Second)
+		}
+	}
+}
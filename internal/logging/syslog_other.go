@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package logging
+
+import "errors"
+
+type SyslogSink struct{}
+
+func NewSyslogSink(network, addr, tag string) (*SyslogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+func (s *SyslogSink) Write(BaseEvent, []byte) error { return nil }
+
+func (s *SyslogSink) Close() error { return nil }
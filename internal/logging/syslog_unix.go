@@ -0,0 +1,45 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+// warningTypes go to syslog at warning priority; everything else is info.
+var warningTypes = map[string]bool{
+	"incident_start":    true,
+	"degradation_start": true,
+	"flapping_start":    true,
+	"path_change":       true,
+}
+
+// SyslogSink sends each record as one syslog message under LOG_DAEMON.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the local syslog daemon when network and addr
+// are empty, otherwise to addr over network ("udp" or "tcp").
+func NewSyslogSink(network, addr, tag string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog: %w", err)
+	}
+
+	return &SyslogSink{w: w}, nil
+}
+
+func (s *SyslogSink) Write(base BaseEvent, line []byte) error {
+	msg := strings.TrimRight(string(line), "\n")
+	if warningTypes[base.Type] {
+		return s.w.Warning(msg)
+	}
+	return s.w.Info(msg)
+}
+
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,286 @@
+package loki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+	"github.com/iaserrat/edgeprobe/internal/logging"
+	"github.com/iaserrat/edgeprobe/internal/spool"
+)
+
+// Config describes the Loki push endpoint. Tags are static labels added to
+// every stream next to job, type, target and host_id.
+type Config struct {
+	URL        string
+	Username   string
+	Password   string
+	TenantID   string
+	Tags       map[string]string
+	BatchSize  int
+	BatchWait  time.Duration
+	Timeout    time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	UserAgent  string
+	Clock      clock.Clock
+}
+
+// Sink forwards log lines to Loki. Every line is spooled to disk before it
+// is sent, so lines written while the uplink is down, which is when outages
+// are logged, are pushed in order once it is back, even across restarts.
+type Sink struct {
+	cfg    Config
+	clk    clock.Clock
+	spool  *spool.Spool
+	http   *http.Client
+	notify chan struct{}
+}
+
+// entry is one spooled line with the labels of its stream.
+type entry struct {
+	Labels map[string]string `json:"labels"`
+	TSNano int64             `json:"ts_ns"`
+	Line   string            `json:"line"`
+}
+
+// errPermanent marks a batch Loki will never accept, such as lines older
+// than its retention allows; it is dropped rather than retried.
+var errPermanent = errors.New("rejected by loki")
+
+func New(cfg Config, sp *spool.Spool) *Sink {
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+
+	return &Sink{
+		cfg:    cfg,
+		clk:    clk,
+		spool:  sp,
+		http:   &http.Client{Timeout: cfg.Timeout},
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Write spools one log line; it is sent later by Run. It implements
+// logging.Sink.
+func (s *Sink) Write(base logging.BaseEvent, line []byte) error {
+	ts := base.TSUnixMS * int64(time.Millisecond)
+	if t, err := time.Parse(time.RFC3339Nano, base.TSUTC); err == nil {
+		ts = t.UnixNano()
+	}
+
+	b, err := json.Marshal(entry{Labels: s.labels(base), TSNano: ts, Line: string(bytes.TrimRight(line, "\n"))})
+	if err == nil {
+		err = s.spool.Append(b)
+	}
+	if err != nil {
+		return fmt.Errorf("loki spool: %w", err)
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close is a no-op: the spool outlives the logger and is closed by whoever
+// runs the sink.
+func (s *Sink) Close() error {
+	return nil
+}
+
+func (s *Sink) labels(base logging.BaseEvent) map[string]string {
+	labels := map[string]string{"job": "edgeprobe"}
+	for k, v := range s.cfg.Tags {
+		labels[k] = v
+	}
+	labels["type"] = base.Type
+	labels["host_id"] = base.HostID
+	if base.Target != "" {
+		labels["target"] = base.Target
+	}
+	return labels
+}
+
+// Run pushes spooled lines until ctx is cancelled. Lines are sent once
+// BatchWait has passed since the first unsent one, or sooner when a batch
+// fills up.
+func (s *Sink) Run(ctx context.Context) {
+	var waitC, retryC <-chan time.Time
+	var backoff time.Duration
+	if s.spool.Pending() {
+		retryC = s.clk.NewTimer(0).C()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+			if retryC != nil || waitC != nil {
+				continue
+			}
+			if !s.batchFull() {
+				waitC = s.clk.NewTimer(s.cfg.BatchWait).C()
+				continue
+			}
+		case <-waitC:
+			waitC = nil
+			if retryC != nil {
+				continue
+			}
+		case <-retryC:
+			retryC = nil
+		}
+
+		if err := s.Flush(ctx); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			backoff = nextBackoff(backoff, s.cfg.MinBackoff, s.cfg.MaxBackoff)
+			fmt.Fprintf(os.Stderr, "loki: %v (retrying in %s)\n", err, backoff)
+			retryC = s.clk.NewTimer(backoff).C()
+			continue
+		}
+		backoff = 0
+	}
+}
+
+func (s *Sink) batchFull() bool {
+	recs, _, err := s.spool.Read(s.cfg.BatchSize)
+	return err == nil && len(recs) >= s.cfg.BatchSize
+}
+
+func nextBackoff(prev, lo, hi time.Duration) time.Duration {
+	if prev == 0 {
+		return lo
+	}
+	return min(2*prev, hi)
+}
+
+// Flush pushes every spooled line, oldest first. It stops at the first
+// batch that may succeed later and leaves it spooled.
+func (s *Sink) Flush(ctx context.Context) error {
+	if err := s.spool.Sync(); err != nil {
+		return err
+	}
+	for s.spool.Pending() {
+		recs, cur, err := s.spool.Read(s.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(recs) == 0 {
+			return nil
+		}
+
+		err = s.push(ctx, recs)
+		if errors.Is(err, errPermanent) {
+			fmt.Fprintf(os.Stderr, "loki: dropping %d lines: %v\n", len(recs), err)
+		} else if err != nil {
+			return err
+		}
+		if err := s.spool.Ack(cur); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type pushRequest struct {
+	Streams []pushStream `json:"streams"`
+}
+
+type pushStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// encodePush groups entries into streams by label set, keeping their order
+// within each stream.
+func encodePush(recs [][]byte) ([]byte, error) {
+	var req pushRequest
+	index := make(map[string]int)
+	for _, rec := range recs {
+		var e entry
+		if err := json.Unmarshal(rec, &e); err != nil {
+			continue
+		}
+		key := streamKey(e.Labels)
+		i, ok := index[key]
+		if !ok {
+			i = len(req.Streams)
+			index[key] = i
+			req.Streams = append(req.Streams, pushStream{Stream: e.Labels})
+		}
+		req.Streams[i].Values = append(req.Streams[i].Values, [2]string{strconv.FormatInt(e.TSNano, 10), e.Line})
+	}
+	return json.Marshal(req)
+}
+
+func streamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q,", k, labels[k])
+	}
+	return b.String()
+}
+
+func (s *Sink) push(ctx context.Context, recs [][]byte) error {
+	body, err := encodePush(recs)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", s.cfg.UserAgent)
+	}
+	if s.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.cfg.TenantID)
+	}
+	if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5:
+		return fmt.Errorf("endpoint returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	default:
+		return fmt.Errorf("%w: %s: %s", errPermanent, resp.Status, bytes.TrimSpace(msg))
+	}
+}
@@ -0,0 +1,162 @@
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+	"github.com/iaserrat/edgeprobe/internal/logging"
+	"github.com/iaserrat/edgeprobe/internal/spool"
+)
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+type standIn struct {
+	mu       sync.Mutex
+	statuses []int
+	pushes   []pushRequest
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := http.StatusNoContent
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	if status/100 != 2 {
+		http.Error(w, "unavailable", status)
+		return
+	}
+	if r.Header.Get("X-Scope-OrgID") != "home" {
+		http.Error(w, "no tenant", http.StatusUnauthorized)
+		return
+	}
+	var req pushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.pushes = append(s.pushes, req)
+	w.WriteHeader(status)
+}
+
+func emit(t *testing.T, logger *logging.Logger, typ, target string) {
+	t.Helper()
+	err := logger.Emit(&logging.OutageSummary{BaseEvent: logging.BaseEvent{Type: typ, Target: target, OutageID: target + "-1"}})
+	if err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+}
+
+func TestSpoolsThroughOutageAndRestart(t *testing.T) {
+	srv := &standIn{statuses: []int{http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	clk := clock.NewVirtual(time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC))
+	cfg := Config{URL: ts.URL, TenantID: "home", Tags: map[string]string{"site": "flat"}, BatchSize: 10, Timeout: time.Second, Clock: clk}
+
+	sp, err := spool.Open(dir, 0)
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	sink := New(cfg, sp)
+	logger := logging.NewWriter(nopCloser{io.Discard}, logging.Config{ToolName: "edgeprobe", ToolVersion: "test", HostID: "pi", Clock: clk, Sinks: []logging.SinkConfig{{Name: "loki", Sink: sink}}})
+
+	emit(t, logger, "outage_summary", "1.1.1.1")
+	clk.Advance(time.Second)
+	emit(t, logger, "outage_summary", "8.8.8.8")
+
+	// The uplink is down: nothing is lost, and the lines survive a restart.
+	logger.Close()
+	if err := sink.Flush(context.Background()); err == nil {
+		t.Fatalf("flush succeeded during outage")
+	}
+	sp.Close()
+	sp, err = spool.Open(dir, 0)
+	if err != nil {
+		t.Fatalf("reopen spool: %v", err)
+	}
+	defer sp.Close()
+	sink = New(cfg, sp)
+	logger = logging.NewWriter(nopCloser{io.Discard}, logging.Config{ToolName: "edgeprobe", ToolVersion: "test", HostID: "pi", Clock: clk, Sinks: []logging.SinkConfig{{Name: "loki", Sink: sink}}})
+	clk.Advance(time.Second)
+	emit(t, logger, "outage_summary", "1.1.1.1")
+	logger.Close()
+
+	if err := sink.Flush(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if sp.Pending() {
+		t.Fatalf("lines left in the spool")
+	}
+
+	if len(srv.pushes) != 1 || len(srv.pushes[0].Streams) != 2 {
+		t.Fatalf("pushes = %+v, want one push with two streams", srv.pushes)
+	}
+	first := srv.pushes[0].Streams[0]
+	want := map[string]string{"job": "edgeprobe", "type": "outage_summary", "target": "1.1.1.1", "host_id": "pi", "site": "flat"}
+	if len(first.Stream) != len(want) {
+		t.Fatalf("labels = %v, want %v", first.Stream, want)
+	}
+	for k, v := range want {
+		if first.Stream[k] != v {
+			t.Fatalf("labels = %v, want %v", first.Stream, want)
+		}
+	}
+	if len(first.Values) != 2 || first.Values[0][0] >= first.Values[1][0] {
+		t.Fatalf("values = %v, want two lines in time order", first.Values)
+	}
+	var line map[string]any
+	if err := json.Unmarshal([]byte(first.Values[0][1]), &line); err != nil || line["seq"] != 1.0 {
+		t.Fatalf("line = %q, want the first JSONL record", first.Values[0][1])
+	}
+}
+
+func TestRunPushesAfterBatchWait(t *testing.T) {
+	srv := &standIn{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	sp, err := spool.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	defer sp.Close()
+
+	clk := clock.NewVirtual(time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC))
+	sink := New(Config{URL: ts.URL, TenantID: "home", BatchSize: 10, BatchWait: 5 * time.Second, Timeout: time.Second, Clock: clk}, sp)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sink.Run(ctx)
+	}()
+
+	sink.Write(logging.BaseEvent{Type: "path_change", Target: "1.1.1.1", HostID: "pi", TSUnixMS: 1}, []byte("{}\n"))
+	deadline := time.Now().Add(2 * time.Second)
+	for sp.Pending() && time.Now().Before(deadline) {
+		clk.Advance(time.Second)
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if sp.Pending() || len(srv.pushes) != 1 {
+		t.Fatalf("pushes = %+v, pending %v", srv.pushes, sp.Pending())
+	}
+	if got := srv.pushes[0].Streams[0].Values[0]; got[0] != "1000000" || got[1] != "{}" {
+		t.Fatalf("value = %v", got)
+	}
+}
@@ -0,0 +1,333 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/statefile"
+)
+
+const EventAnomaly EventType = "anomaly"
+
+const (
+	baselineEvery   = time.Minute
+	hoursPerWeek    = 7 * 24
+	baselineVersion = 1
+)
+
+// BaselineConfig controls the per-target hour-of-week baselines. Every minute
+// the primary window stats are folded into the bucket for the current hour of
+// the week; a value more than Sigma standard deviations above a bucket with at
+// least MinSamples entries is an anomaly. Sigma 0 keeps learning but never
+// reports.
+type BaselineConfig struct {
+	Sigma      float64
+	MinSamples int
+	Location   *time.Location
+}
+
+type Anomaly struct {
+	Target         string
+	OutageID       string
+	IncidentID     string
+	TS             time.Time
+	Metric         string
+	Value          float64
+	BaselineMean   float64
+	BaselineStdDev float64
+	ZScore         float64
+	HourOfWeek     int
+	Samples        int
+}
+
+func (a Anomaly) Type() EventType { return EventAnomaly }
+
+var baselineMetrics = []string{MetricRttAvgMs, MetricRttP95Ms, MetricLossPct}
+
+// Minimum spread per metric, so a perfectly stable hour does not turn every
+// 1ms wobble into an anomaly.
+var baselineMinStdDev = map[string]float64{
+	MetricRttAvgMs: 2,
+	MetricRttP95Ms: 2,
+	MetricLossPct:  1,
+}
+
+type runningStats struct {
+	N    int     `json:"n"`
+	Mean float64 `json:"mean"`
+	M2   float64 `json:"m2"`
+}
+
+func (r *runningStats) add(v float64) {
+	r.N++
+	delta := v - r.Mean
+	r.Mean += delta / float64(r.N)
+	r.M2 += delta * (v - r.Mean)
+}
+
+func (r runningStats) stdDev() float64 {
+	if r.N < 2 {
+		return 0
+	}
+	return math.Sqrt(r.M2 / float64(r.N-1))
+}
+
+func (r *runningStats) merge(o runningStats) {
+	if o.N == 0 {
+		return
+	}
+	if r.N == 0 {
+		*r = o
+		return
+	}
+	n := r.N + o.N
+	delta := o.Mean - r.Mean
+	r.Mean += delta * float64(o.N) / float64(n)
+	r.M2 += o.M2 + delta*delta*float64(r.N)*float64(o.N)/float64(n)
+	r.N = n
+}
+
+// baseline holds one bucket per hour of the week, Monday 00:00 first, for
+// each of baselineMetrics.
+type baseline struct {
+	Buckets [][hoursPerWeek]runningStats `json:"buckets"`
+	last    time.Time
+	flagged []bool
+}
+
+func newBaseline() *baseline {
+	return &baseline{
+		Buckets: make([][hoursPerWeek]runningStats, len(baselineMetrics)),
+		flagged: make([]bool, len(baselineMetrics)),
+	}
+}
+
+func hourOfWeek(ts time.Time) int {
+	return ((int(ts.Weekday())+6)%7)*24 + ts.Hour()
+}
+
+func baselineValue(metric string, s WindowStats) (float64, bool) {
+	switch metric {
+	case MetricLossPct:
+		return s.LossPct, s.Samples > 0
+	case MetricRttP95Ms:
+		return s.RttP95Ms, s.Samples > 0 && s.LossPct < 100
+	default:
+		return s.RttAvgMs, s.Samples > 0 && s.LossPct < 100
+	}
+}
+
+// observeBaseline checks the primary window against the target's baseline and
+// then learns from it. Anomalies are reported once per excursion. Nothing is
+// learned during an outage, and an anomalous value is learned only up to the
+// anomaly threshold, so an excursion cannot drag the baseline up far enough
+// to hide itself while a lasting shift still moves it a little every minute.
+func (d *Detector) observeBaseline(target string, state *targetState, stats WindowStats, ts time.Time) []Event {
+	if state.baseline == nil {
+		state.baseline = newBaseline()
+	}
+	b := state.baseline
+	if ts.Sub(b.last) < baselineEvery {
+		return nil
+	}
+	b.last = ts
+
+	loc := d.baseline.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	hour := hourOfWeek(ts.In(loc))
+
+	var events []Event
+	for i, metric := range baselineMetrics {
+		v, ok := baselineValue(metric, stats)
+		if !ok {
+			continue
+		}
+		bucket := &b.Buckets[i][hour]
+		learn := v
+
+		if d.baseline.Sigma > 0 && bucket.N >= d.baseline.MinSamples && bucket.N >= 2 {
+			std := math.Max(bucket.stdDev(), baselineMinStdDev[metric])
+			z := (v - bucket.Mean) / std
+			anomalous := z >= d.baseline.Sigma
+			learn = math.Min(v, bucket.Mean+d.baseline.Sigma*std)
+			if anomalous && !b.flagged[i] {
+				a := Anomaly{
+					Target:         target,
+					TS:             ts,
+					Metric:         metric,
+					Value:          v,
+					BaselineMean:   bucket.Mean,
+					BaselineStdDev: bucket.stdDev(),
+					ZScore:         z,
+					HourOfWeek:     hour,
+					Samples:        bucket.N,
+				}
+				if state.inOutage {
+					a.OutageID = state.outageID
+					a.IncidentID = state.incidentID
+				}
+				events = append(events, a)
+			}
+			b.flagged[i] = anomalous
+		}
+
+		if !state.inOutage {
+			bucket.add(learn)
+		}
+	}
+
+	return events
+}
+
+// Baselines is the persisted form of every target's baseline. It is kept
+// apart from the detector snapshot because it is large and changes slowly.
+type Baselines struct {
+	Version int                  `json:"version"`
+	TakenAt time.Time            `json:"taken_at"`
+	Targets map[string]*baseline `json:"targets"`
+}
+
+func (d *Detector) Baselines(ts time.Time) Baselines {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := Baselines{Version: baselineVersion, TakenAt: ts, Targets: make(map[string]*baseline, len(d.states))}
+	for target, state := range d.states {
+		if b := state.baseline; b != nil {
+			out.Targets[target] = &baseline{Buckets: append([][hoursPerWeek]runningStats(nil), b.Buckets...)}
+		}
+	}
+
+	return out
+}
+
+// RestoreBaselines loads saved baselines for the configured targets.
+func (d *Detector) RestoreBaselines(saved Baselines, targets []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, target := range targets {
+		b := saved.Targets[target]
+		if b == nil || len(b.Buckets) != len(baselineMetrics) {
+			continue
+		}
+		restored := newBaseline()
+		copy(restored.Buckets, b.Buckets)
+		d.stateFor(target).baseline = restored
+	}
+}
+
+func SaveBaselines(path string, b Baselines) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("marshal baselines: %w", err)
+	}
+
+	return statefile.Write(path, data, "baselines", true)
+}
+
+func LoadBaselines(path string) (Baselines, bool, error) {
+	var b Baselines
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, false, nil
+	}
+	if err != nil {
+		return b, false, fmt.Errorf("read baselines: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &b); err != nil {
+		return b, false, fmt.Errorf("decode baselines: %w", err)
+	}
+	if b.Version != baselineVersion {
+		return b, false, fmt.Errorf("unsupported baselines version %d", b.Version)
+	}
+
+	return b, true, nil
+}
+
+type HourStats struct {
+	Samples  int
+	RttAvgMs float64
+	RttP95Ms float64
+	LossPct  float64
+}
+
+type CongestionReport struct {
+	Target         string
+	Hours          [24]HourStats
+	Peak           HourStats
+	OffPeak        HourStats
+	RttIncreasePct float64
+	WorstHour      int
+}
+
+// Congestion folds the learned baselines into hour-of-day profiles and
+// compares the peak hours with the rest of the day.
+func Congestion(saved Baselines, peakHours []int) []CongestionReport {
+	peak := make(map[int]bool, len(peakHours))
+	for _, h := range peakHours {
+		peak[h] = true
+	}
+
+	targets := make([]string, 0, len(saved.Targets))
+	for target := range saved.Targets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	var reports []CongestionReport
+	for _, target := range targets {
+		b := saved.Targets[target]
+		if len(b.Buckets) != len(baselineMetrics) {
+			continue
+		}
+
+		var hours [3][24]runningStats
+		for i := range baselineMetrics {
+			for how := 0; how < hoursPerWeek; how++ {
+				hours[i][how%24].merge(b.Buckets[i][how])
+			}
+		}
+
+		report := CongestionReport{Target: target, WorstHour: -1}
+		var peakAgg, offAgg [3]runningStats
+		worst := 0.0
+		for h := 0; h < 24; h++ {
+			hs := hourStats(hours[0][h], hours[1][h], hours[2][h])
+			report.Hours[h] = hs
+			if hs.Samples > 0 && hs.RttAvgMs > worst {
+				worst = hs.RttAvgMs
+				report.WorstHour = h
+			}
+			agg := &offAgg
+			if peak[h] {
+				agg = &peakAgg
+			}
+			for i := range baselineMetrics {
+				agg[i].merge(hours[i][h])
+			}
+		}
+		report.Peak = hourStats(peakAgg[0], peakAgg[1], peakAgg[2])
+		report.OffPeak = hourStats(offAgg[0], offAgg[1], offAgg[2])
+		if report.OffPeak.RttAvgMs > 0 && report.Peak.Samples > 0 {
+			report.RttIncreasePct = (report.Peak.RttAvgMs/report.OffPeak.RttAvgMs - 1) * 100
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+func hourStats(rttAvg, rttP95, loss runningStats) HourStats {
+	return HourStats{Samples: loss.N, RttAvgMs: rttAvg.Mean, RttP95Ms: rttP95.Mean, LossPct: loss.Mean}
+}
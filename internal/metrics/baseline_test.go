@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBaselineAnomaly(t *testing.T) {
+	d := NewDetector(Config{
+		Window:   time.Minute,
+		Interval: time.Second,
+		Baseline: BaselineConfig{Sigma: 3, MinSamples: 30},
+	})
+	state := d.stateFor("a")
+
+	// Monday 20:00 UTC, learned over several weeks.
+	monday := time.Date(2026, 9, 7, 20, 0, 0, 0, time.UTC)
+	for week := 0; week < 4; week++ {
+		for m := 0; m < 15; m++ {
+			ts := monday.AddDate(0, 0, 7*week).Add(time.Duration(m) * time.Minute)
+			rtt := 40.0 + float64(m%3)
+			if events := d.observeBaseline("a", state, WindowStats{Samples: 60, RttAvgMs: rtt, RttP95Ms: rtt + 5}, ts); len(events) != 0 {
+				t.Fatalf("unexpected anomaly while learning: %+v", events)
+			}
+		}
+	}
+
+	now := monday.AddDate(0, 0, 28)
+	observe := func(m int, rtt float64) []Event {
+		return d.observeBaseline("a", state, WindowStats{Samples: 60, RttAvgMs: rtt, RttP95Ms: rtt + 5}, now.Add(time.Duration(m)*time.Minute))
+	}
+
+	events := observe(0, 80)
+	if len(events) != 2 {
+		t.Fatalf("expected rtt_avg and rtt_p95 anomalies, got %+v", events)
+	}
+	a := events[0].(Anomaly)
+	if a.Metric != MetricRttAvgMs || a.HourOfWeek != 20 || a.ZScore < 3 || math.Abs(a.BaselineMean-41) > 0.1 {
+		t.Fatalf("unexpected anomaly: %+v", a)
+	}
+	if events := observe(1, 80); len(events) != 0 {
+		t.Fatalf("anomaly should be reported once per excursion, got %+v", events)
+	}
+	if events := observe(2, 41); len(events) != 0 {
+		t.Fatalf("unexpected anomaly at baseline: %+v", events)
+	}
+	if events := observe(3, 90); len(events) != 2 {
+		t.Fatalf("expected a new excursion to be reported, got %+v", events)
+	}
+}
+
+func TestBaselineIgnoresOutagesAndExcursions(t *testing.T) {
+	d := NewDetector(Config{
+		Window:   time.Minute,
+		Interval: time.Second,
+		Baseline: BaselineConfig{Sigma: 3, MinSamples: 30},
+	})
+	state := d.stateFor("a")
+
+	monday := time.Date(2026, 9, 7, 20, 0, 0, 0, time.UTC)
+	observe := func(week, m int, rtt float64) []Event {
+		ts := monday.AddDate(0, 0, 7*week).Add(time.Duration(m) * time.Minute)
+		return d.observeBaseline("a", state, WindowStats{Samples: 60, RttAvgMs: rtt, RttP95Ms: rtt + 5}, ts)
+	}
+	for week := 0; week < 4; week++ {
+		for m := 0; m < 15; m++ {
+			observe(week, m, 40+float64(m%3))
+		}
+	}
+	learned := state.baseline.Buckets[0][20]
+
+	// Half an hour of outage adds nothing to the hour.
+	state.inOutage = true
+	for m := 0; m < 30; m++ {
+		observe(4, m, 500)
+	}
+	state.inOutage = false
+	if got := state.baseline.Buckets[0][20]; got != learned {
+		t.Fatalf("learned during outage: %+v, was %+v", got, learned)
+	}
+
+	// A long excursion outside an outage is clamped, so the hour still
+	// knows what normal looks like afterwards.
+	for m := 0; m < 30; m++ {
+		observe(5, m, 200)
+	}
+	if mean := state.baseline.Buckets[0][20].Mean; mean > 50 {
+		t.Fatalf("baseline mean dragged to %.1f by the excursion", mean)
+	}
+	observe(5, 30, 41)
+	if events := observe(5, 31, 80); len(events) != 2 {
+		t.Fatalf("expected the next excursion to be reported, got %+v", events)
+	}
+}
+
+func TestCongestionReport(t *testing.T) {
+	b := newBaseline()
+	for how := 0; how < hoursPerWeek; how++ {
+		rtt := 20.0
+		if how%24 == 20 {
+			rtt = 50
+		}
+		for i := 0; i < 10; i++ {
+			b.Buckets[0][how].add(rtt)
+			b.Buckets[1][how].add(rtt + 10)
+			b.Buckets[2][how].add(0)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "baselines.json")
+	if err := SaveBaselines(path, Baselines{Version: baselineVersion, Targets: map[string]*baseline{"a": b}}); err != nil {
+		t.Fatalf("save baselines: %v", err)
+	}
+	saved, ok, err := LoadBaselines(path)
+	if err != nil || !ok {
+		t.Fatalf("load baselines: ok=%v err=%v", ok, err)
+	}
+
+	reports := Congestion(saved, []int{19, 20, 21, 22})
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reports))
+	}
+	r := reports[0]
+	if r.WorstHour != 20 || r.Hours[20].RttAvgMs != 50 || r.Hours[20].Samples != 70 {
+		t.Fatalf("unexpected hourly profile: worst=%d hour20=%+v", r.WorstHour, r.Hours[20])
+	}
+	if r.Peak.RttAvgMs != 27.5 || r.OffPeak.RttAvgMs != 20 || r.RttIncreasePct != 37.5 {
+		t.Fatalf("unexpected peak comparison: peak=%+v offpeak=%+v increase=%v", r.Peak, r.OffPeak, r.RttIncreasePct)
+	}
+}
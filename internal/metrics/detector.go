@@ -3,7 +3,6 @@ package metrics
 import (
 	"fmt"
 	"sort"
-	"strings"
 	"sync"
 	"time"
 )
@@ -29,10 +28,14 @@ type Event interface {
 type OutageStart struct {
 	Target              string
 	OutageID            string
+	IncidentID          string
+	ImpactStartTS       time.Time
 	Reason              string
 	LossPct             float64
 	RttP95Ms            float64
+	MOS                 float64
 	ConsecutiveFailures int
+	Windows             []WindowStats
 }
 
 func (o OutageStart) Type() EventType { return EventOutageStart }
@@ -40,10 +43,15 @@ func (o OutageStart) Type() EventType { return EventOutageStart }
 type OutageEnd struct {
 	Target              string
 	OutageID            string
+	IncidentID          string
+	ImpactStartTS       time.Time
+	ImpactEndTS         time.Time
 	Reason              string
 	LossPct             float64
 	RttP95Ms            float64
+	MOS                 float64
 	ConsecutiveFailures int
+	Windows             []WindowStats
 }
 
 func (o OutageEnd) Type() EventType { return EventOutageEnd }
@@ -51,56 +59,104 @@ func (o OutageEnd) Type() EventType { return EventOutageEnd }
 type OutageSummary struct {
 	Target             string
 	OutageID           string
+	IncidentID         string
+	IncidentScope      string
 	StartTS            time.Time
 	EndTS              time.Time
 	DurationMs         int64
+	StartReason        string
+	EndReason          string
+	ImpactStartTS      time.Time
+	ImpactEndTS        time.Time
+	ImpactDurationMs   int64
 	LossPctMax         float64
 	RttP95MaxMs        float64
 	RttAvgMaxMs        float64
+	MOSMin             float64
 	ConsecutiveFailMax int
 	PingSent           int
 	PingRecv           int
 	DNSErrors          int
 	TracerouteCount    int
+	FlapCount          int
+	WindowsMax         []WindowStats
 }
 
 func (o OutageSummary) Type() EventType { return EventOutageSummary }
 
 type Detector struct {
 	window    time.Duration
+	interval  time.Duration
+	spans     []time.Duration
+	primary   int
+	rules     []compiledRule
+	slowMs    float64
+	flap      FlapConfig
+	baseline  BaselineConfig
 	mu        sync.Mutex
 	states    map[string]*targetState
 	idCounter int64
+	incident  *incidentState
+	dnsSent   int
+	dnsOK     int
 }
 
 type pingSample struct {
-	ts  time.Time
-	ok  bool
-	rtt float64
+	ts       time.Time
+	ok       bool
+	rtt      float64
+	nextDiff float64
+	paired   bool
 }
 
 type targetState struct {
-	windowSamples []pingSample
-	consecFail    int
-	inOutage      bool
-	outageID      string
-	outageStart   time.Time
-	clearSince    *time.Time
+	windows     []*sampleWindow
+	consecFail  int
+	inOutage    bool
+	outageID    string
+	incidentID  string
+	outageStart time.Time
+	startReason string
+	clearSince  *time.Time
+	failSince   time.Time
+	impactStart time.Time
+	lastBad     time.Time
 
 	lossPctMax      float64
 	rttP95MaxMs     float64
 	rttAvgMaxMs     float64
+	mosMin          float64
 	consecFailMax   int
 	pingSent        int
 	pingRecv        int
 	dnsErrors       int
 	tracerouteCount int
+	windowsMax      []WindowStats
+	scratch         []WindowStats
+	interval        *intervalAccumulator
+
+	hold      *outageHold
+	flapCount int
+	flapping  bool
+	flapSince time.Time
+	changes   []time.Time
+
+	baseline *baseline
 }
 
-func NewDetector(windowSecs int) *Detector {
+func NewDetector(cfg Config) *Detector {
+	spans, rules := compileRules(cfg.Window, cfg.Rules)
+
 	return &Detector{
-		window: time.Duration(windowSecs) * time.Second,
-		states: make(map[string]*targetState),
+		window:   cfg.Window,
+		interval: cfg.Interval,
+		spans:    spans,
+		primary:  spanIndex(spans, cfg.Window),
+		rules:    rules,
+		slowMs:   slowThreshold(rules),
+		flap:     cfg.Flap,
+		baseline: cfg.Baseline,
+		states:   make(map[string]*targetState),
 	}
 }
 
@@ -109,34 +165,55 @@ func (d *Detector) ProcessPing(target string, ts time.Time, ok bool, rttMs float
 	defer d.mu.Unlock()
 
 	state := d.stateFor(target)
-	state.windowSamples = append(state.windowSamples, pingSample{ts: ts, ok: ok, rtt: rttMs})
-	state.windowSamples = pruneWindow(state.windowSamples, ts, d.window)
+	sample := pingSample{ts: ts, ok: ok, rtt: rttMs}
+	for _, w := range state.windows {
+		w.add(sample)
+	}
+	if state.interval == nil {
+		state.interval = newIntervalAccumulator(ts)
+	}
+	state.interval.add(sample)
 
 	if ok {
 		state.consecFail = 0
 	} else {
+		if state.consecFail == 0 {
+			state.failSince = ts
+		}
 		state.consecFail++
 	}
+	windows := state.stats()
+	stats := windows[d.primary]
+	reason, outage := evaluateRules(d.rules, windows, state.consecFail)
+	// A MOS score has no single bad sample, so the impact lasts as long as a
+	// MOS rule stays tripped.
+	if sample.bad(d.slowMs) || mosTripped(d.rules, windows) {
+		state.lastBad = ts
+	}
 
-	stats := computeStats(state.windowSamples)
-	reason, outage := evaluateOutage(stats, state.consecFail)
-
-	var events []Event
+	events := d.observeBaseline(target, state, stats, ts)
 
 	if !state.inOutage && outage {
 		state.inOutage = true
 		state.outageID = d.nextOutageID(target, ts)
 		state.outageStart = ts
+		state.startReason = reason
 		state.clearSince = nil
-
-		state.lossPctMax = stats.lossPct
-		state.rttP95MaxMs = stats.rttP95
-		state.rttAvgMaxMs = stats.rttAvg
+		state.impactStart = d.impactOnset(state, windows, ts)
+		state.lastBad = ts
+
+		state.lossPctMax = stats.LossPct
+		state.rttP95MaxMs = stats.RttP95Ms
+		state.rttAvgMaxMs = stats.RttAvgMs
+		state.mosMin = stats.MOS
+		state.windowsMax = append([]WindowStats(nil), windows...)
 		state.consecFailMax = state.consecFail
 		state.pingSent = 0
 		state.pingRecv = 0
 		state.dnsErrors = 0
 		state.tracerouteCount = 0
+		state.flapCount = 0
+		d.recordChange(state, ts)
 
 		if ok {
 			state.pingSent = 1
@@ -146,13 +223,23 @@ func (d *Detector) ProcessPing(target string, ts time.Time, ok bool, rttMs float
 			state.pingRecv = 0
 		}
 
+		incidentID, incidentStart := d.joinIncident(target, state.outageID, ts, state.impactStart)
+		state.incidentID = incidentID
+		if incidentStart != nil {
+			events = append(events, *incidentStart)
+		}
+
 		events = append(events, OutageStart{
 			Target:              target,
 			OutageID:            state.outageID,
+			IncidentID:          state.incidentID,
+			ImpactStartTS:       state.impactStart,
 			Reason:              reason,
-			LossPct:             stats.lossPct,
-			RttP95Ms:            stats.rttP95,
+			LossPct:             stats.LossPct,
+			RttP95Ms:            stats.RttP95Ms,
+			MOS:                 stats.MOS,
 			ConsecutiveFailures: state.consecFail,
+			Windows:             append([]WindowStats(nil), windows...),
 		})
 
 		return events
@@ -165,14 +252,20 @@ func (d *Detector) ProcessPing(target string, ts time.Time, ok bool, rttMs float
 		} else {
 			state.pingSent++
 		}
-		if stats.lossPct > state.lossPctMax {
-			state.lossPctMax = stats.lossPct
+		if stats.LossPct > state.lossPctMax {
+			state.lossPctMax = stats.LossPct
 		}
-		if stats.rttP95 > state.rttP95MaxMs {
-			state.rttP95MaxMs = stats.rttP95
+		if stats.RttP95Ms > state.rttP95MaxMs {
+			state.rttP95MaxMs = stats.RttP95Ms
 		}
-		if stats.rttAvg > state.rttAvgMaxMs {
-			state.rttAvgMaxMs = stats.rttAvg
+		if stats.RttAvgMs > state.rttAvgMaxMs {
+			state.rttAvgMaxMs = stats.RttAvgMs
+		}
+		if stats.Samples > 0 && stats.MOS < state.mosMin {
+			state.mosMin = stats.MOS
+		}
+		for i, w := range windows {
+			state.windowsMax[i] = maxWindowStats(state.windowsMax[i], w)
 		}
 		if state.consecFail > state.consecFailMax {
 			state.consecFailMax = state.consecFail
@@ -180,57 +273,142 @@ func (d *Detector) ProcessPing(target string, ts time.Time, ok bool, rttMs float
 
 		if outage {
 			state.clearSince = nil
-		} else {
+			if state.hold != nil {
+				d.resumeOutage(state, ts)
+			}
+		} else if state.hold == nil {
 			if state.clearSince == nil {
 				t := ts
 				state.clearSince = &t
 			}
 			if ts.Sub(*state.clearSince) >= d.window {
-				endEvent := OutageEnd{
-					Target:              target,
-					OutageID:            state.outageID,
-					Reason:              "cleared",
-					LossPct:             stats.lossPct,
-					RttP95Ms:            stats.rttP95,
-					ConsecutiveFailures: state.consecFail,
-				}
-				summary := OutageSummary{
-					Target:             target,
-					OutageID:           state.outageID,
-					StartTS:            state.outageStart,
-					EndTS:              ts,
-					DurationMs:         ts.Sub(state.outageStart).Milliseconds(),
-					LossPctMax:         state.lossPctMax,
-					RttP95MaxMs:        state.rttP95MaxMs,
-					RttAvgMaxMs:        state.rttAvgMaxMs,
-					ConsecutiveFailMax: state.consecFailMax,
-					PingSent:           state.pingSent,
-					PingRecv:           state.pingRecv,
-					DNSErrors:          state.dnsErrors,
-					TracerouteCount:    state.tracerouteCount,
+				if !d.holdsOutages() {
+					return append(events, d.closeOutage(target, state, ts, "cleared", windows)...)
 				}
+				d.holdOutage(state, ts)
+			}
+		}
 
-				state.inOutage = false
-				state.outageID = ""
-				state.outageStart = time.Time{}
-				state.clearSince = nil
+		if e := d.updateFlapping(target, state, ts); e != nil {
+			events = append(events, e)
+		}
+		if d.holdExpired(state, ts) {
+			events = append(events, d.closeOutage(target, state, ts, "cleared", windows)...)
+		}
+	}
 
-				events = append(events, endEvent, summary)
-			}
+	return events
+}
+
+// closeOutage ends the target's outage. A held outage had already cleared, so
+// it ends at the hold time with the impact and counts it had then.
+func (d *Detector) closeOutage(target string, state *targetState, ts time.Time, reason string, windows []WindowStats) []Event {
+	var events []Event
+	if state.flapping {
+		events = append(events, d.flappingEnd(target, state, ts))
+	}
+
+	impactEnd := state.lastBad
+	if h := state.hold; h != nil {
+		ts = h.since
+		reason = "cleared"
+		impactEnd = h.impactEnd
+		state.pingSent = h.pingSent
+		state.pingRecv = h.pingRecv
+		state.hold = nil
+	}
+	if impactEnd.Before(state.impactStart) {
+		impactEnd = state.impactStart
+	}
+
+	stats := windows[d.primary]
+	endEvent := OutageEnd{
+		Target:              target,
+		OutageID:            state.outageID,
+		IncidentID:          state.incidentID,
+		ImpactStartTS:       state.impactStart,
+		ImpactEndTS:         impactEnd,
+		Reason:              reason,
+		LossPct:             stats.LossPct,
+		RttP95Ms:            stats.RttP95Ms,
+		MOS:                 stats.MOS,
+		ConsecutiveFailures: state.consecFail,
+		Windows:             append([]WindowStats(nil), windows...),
+	}
+	summary := OutageSummary{
+		Target:             target,
+		OutageID:           state.outageID,
+		IncidentID:         state.incidentID,
+		IncidentScope:      d.incidentScope(),
+		StartTS:            state.outageStart,
+		EndTS:              ts,
+		DurationMs:         ts.Sub(state.outageStart).Milliseconds(),
+		StartReason:        state.startReason,
+		EndReason:          reason,
+		ImpactStartTS:      state.impactStart,
+		ImpactEndTS:        impactEnd,
+		ImpactDurationMs:   impactEnd.Sub(state.impactStart).Milliseconds(),
+		LossPctMax:         state.lossPctMax,
+		RttP95MaxMs:        state.rttP95MaxMs,
+		RttAvgMaxMs:        state.rttAvgMaxMs,
+		MOSMin:             state.mosMin,
+		ConsecutiveFailMax: state.consecFailMax,
+		PingSent:           state.pingSent,
+		PingRecv:           state.pingRecv,
+		DNSErrors:          state.dnsErrors,
+		TracerouteCount:    state.tracerouteCount,
+		FlapCount:          state.flapCount,
+		WindowsMax:         state.windowsMax,
+	}
+
+	state.inOutage = false
+	state.outageID = ""
+	state.incidentID = ""
+	state.outageStart = time.Time{}
+	state.startReason = ""
+	state.impactStart = time.Time{}
+	state.clearSince = nil
+	state.windowsMax = nil
+
+	events = append(events, endEvent, summary)
+	if incidentEnd := d.leaveIncident(target, ts, impactEnd); incidentEnd != nil {
+		events = append(events, *incidentEnd)
+	}
+
+	return events
+}
+
+func (d *Detector) Flush(ts time.Time, reason string) []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	targets := make([]string, 0, len(d.states))
+	for target, state := range d.states {
+		if state.inOutage {
+			targets = append(targets, target)
 		}
 	}
+	sort.Strings(targets)
+
+	var events []Event
+	for _, target := range targets {
+		state := d.states[target]
+		events = append(events, d.closeOutage(target, state, ts, reason, state.stats())...)
+	}
 
 	return events
 }
 
 func (d *Detector) ProcessDNS(ts time.Time, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.dnsSent++
 	if ok {
+		d.dnsOK++
 		return
 	}
 
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
 	for _, state := range d.states {
 		if state.inOutage {
 			state.dnsErrors++
@@ -251,6 +429,42 @@ func (d *Detector) RecordTraceroute(target string, outageID string) {
 	}
 }
 
+// LiveStats is a target's detection window as of now, including samples
+// that have not reached an interval_stats record yet.
+type LiveStats struct {
+	Target   string
+	Window   time.Duration
+	Sent     int
+	Recv     int
+	LossPct  float64
+	RttMinMs float64
+	RttP50Ms float64
+	RttP95Ms float64
+	RttMaxMs float64
+	JitterMs float64
+	MOS      float64
+}
+
+// Live returns the detection window of every target, sorted by target.
+// Samples older than the window at now are dropped first, so a target whose
+// probes stopped reports an empty window rather than its last one.
+func (d *Detector) Live(now time.Time) []LiveStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := make([]LiveStats, 0, len(d.states))
+	for target, state := range d.states {
+		w := state.windows[d.primary]
+		w.prune(now)
+		live := w.live()
+		live.Target = target
+		stats = append(stats, live)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Target < stats[j].Target })
+
+	return stats
+}
+
 func (d *Detector) ActiveOutageID(target string) string {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -263,88 +477,104 @@ func (d *Detector) ActiveOutageID(target string) string {
 	return state.outageID
 }
 
+func (d *Detector) ActiveIncidentID(target string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := d.states[target]
+	if state == nil || !state.inOutage {
+		return ""
+	}
+
+	return state.incidentID
+}
+
 func (d *Detector) stateFor(target string) *targetState {
 	state := d.states[target]
 	if state == nil {
 		state = &targetState{}
+		for _, span := range d.spans {
+			state.windows = append(state.windows, newSampleWindow(span, d.capacityFor(span)))
+		}
 		d.states[target] = state
 	}
 
 	return state
 }
 
-func (d *Detector) nextOutageID(target string, ts time.Time) string {
-	d.idCounter++
-	return fmt.Sprintf("%s-%d-%06d", target, ts.UnixNano(), d.idCounter)
-}
+func (d *Detector) capacityFor(span time.Duration) int {
+	if d.interval <= 0 {
+		return 1
+	}
 
-type windowStats struct {
-	lossPct float64
-	rttP95  float64
-	rttAvg  float64
+	return int(span/d.interval) + 1
 }
 
-func computeStats(samples []pingSample) windowStats {
-	if len(samples) == 0 {
-		return windowStats{}
+// impactOnset picks the earliest bad sample behind each tripped rule and keeps
+// the most recent of those, so a stray loss early in a long window does not
+// stretch the impact of an outage that a shorter window explains on its own.
+func (d *Detector) impactOnset(state *targetState, windows []WindowStats, ts time.Time) time.Time {
+	onset := time.Time{}
+	for _, r := range trippedRules(d.rules, windows, state.consecFail) {
+		var first time.Time
+		switch r.Metric {
+		case MetricConsecutiveFailures:
+			first = state.failSince
+		case MetricLossPct:
+			first = state.windows[r.windowIdx].firstBad(0)
+		case MetricMOS:
+			first = state.windows[r.windowIdx].firstBad(d.slowMs)
+		default:
+			first = state.windows[r.windowIdx].firstBad(r.Threshold)
+		}
+		if first.After(onset) {
+			onset = first
+		}
+	}
+	if onset.IsZero() {
+		return ts
 	}
 
-	sent := len(samples)
-	recv := 0
-	var rtts []float64
-	var rttSum float64
+	return onset
+}
 
-	for _, s := range samples {
-		if s.ok {
-			recv++
-			rtts = append(rtts, s.rtt)
-			rttSum += s.rtt
-		}
+func (s *targetState) stats() []WindowStats {
+	if len(s.scratch) != len(s.windows) {
+		s.scratch = make([]WindowStats, len(s.windows))
 	}
-
-	lossPct := (1.0 - (float64(recv) / float64(sent))) * 100.0
-	var rttP95 float64
-	var rttAvg float64
-	if len(rtts) > 0 {
-		sort.Float64s(rtts)
-		idx := int(float64(len(rtts)-1) * 0.95)
-		rttP95 = rtts[idx]
-		rttAvg = rttSum / float64(len(rtts))
+	for i, w := range s.windows {
+		s.scratch[i] = w.stats()
 	}
 
-	return windowStats{lossPct: lossPct, rttP95: rttP95, rttAvg: rttAvg}
+	return s.scratch
 }
 
-func evaluateOutage(stats windowStats, consecutiveFailures int) (string, bool) {
-	var reasons []string
-	if stats.lossPct >= lossThresholdPct {
-		reasons = append(reasons, "loss_pct")
+func (d *Detector) nextOutageID(target string, ts time.Time) string {
+	d.idCounter++
+	return fmt.Sprintf("%s-%d-%06d", target, ts.UnixNano(), d.idCounter)
+}
+
+// maxWindowStats keeps the worst of each metric, which for MOS is the lowest.
+func maxWindowStats(a WindowStats, b WindowStats) WindowStats {
+	if b.LossPct > a.LossPct {
+		a.LossPct = b.LossPct
 	}
-	if stats.rttP95 >= rttP95ThresholdMs {
-		reasons = append(reasons, "rtt_p95_ms")
+	if b.RttP95Ms > a.RttP95Ms {
+		a.RttP95Ms = b.RttP95Ms
 	}
-	if consecutiveFailures >= consecutiveFailThresh {
-		reasons = append(reasons, "consecutive_failures")
+	if b.RttAvgMs > a.RttAvgMs {
+		a.RttAvgMs = b.RttAvgMs
 	}
-	if len(reasons) == 0 {
-		return "", false
+	if b.JitterMs > a.JitterMs {
+		a.JitterMs = b.JitterMs
 	}
-
-	return strings.Join(reasons, ","), true
-}
-
-func pruneWindow(samples []pingSample, now time.Time, window time.Duration) []pingSample {
-	cutoff := now.Add(-window)
-	idx := 0
-	for idx < len(samples) {
-		if samples[idx].ts.After(cutoff) || samples[idx].ts.Equal(cutoff) {
-			break
-		}
-		idx++
+	if b.Samples > 0 && (a.Samples == 0 || b.MOS < a.MOS) {
+		a.MOS = b.MOS
+		a.RFactor = b.RFactor
 	}
-	if idx == 0 {
-		return samples
+	if b.Samples > a.Samples {
+		a.Samples = b.Samples
 	}
 
-	return append([]pingSample(nil), samples[idx:]...)
+	return a
 }
@@ -8,7 +8,7 @@ import (
 )
 
 func TestNextOutageIDFormat(t *testing.T) {
-	d := NewDetector(60)
+	d := NewDetector(Config{Window: 60 * time.Second, Interval: time.Second})
 	ts := time.Unix(0, 123456789)
 	id := d.nextOutageID("target", ts)
 
@@ -24,3 +24,148 @@ func TestNextOutageIDFormat(t *testing.T) {
 		t.Fatalf("outage counter not zero-padded: %s", id)
 	}
 }
+
+func TestOutageImpactTimestamps(t *testing.T) {
+	d := NewDetector(Config{Window: 60 * time.Second, Interval: time.Second})
+	base := time.Unix(1000, 0)
+	at := func(i int) time.Time { return base.Add(time.Duration(i) * time.Second) }
+
+	var start *OutageStart
+	var summary *OutageSummary
+	for i := 0; i < 300; i++ {
+		ok := i < 100 || i > 104
+		for _, e := range d.ProcessPing("a", at(i), ok, 10) {
+			switch evt := e.(type) {
+			case OutageStart:
+				start = &evt
+			case OutageSummary:
+				summary = &evt
+			}
+		}
+	}
+
+	if start == nil || summary == nil {
+		t.Fatalf("expected outage start and summary")
+	}
+	if !start.ImpactStartTS.Equal(at(100)) {
+		t.Fatalf("impact start = %v, want %v", start.ImpactStartTS, at(100))
+	}
+	if !summary.StartTS.Equal(at(102)) {
+		t.Fatalf("detection start = %v, want %v", summary.StartTS, at(102))
+	}
+	if !summary.ImpactEndTS.Equal(at(104)) {
+		t.Fatalf("impact end = %v, want %v", summary.ImpactEndTS, at(104))
+	}
+	if summary.ImpactDurationMs != 4000 {
+		t.Fatalf("impact duration = %d, want 4000", summary.ImpactDurationMs)
+	}
+	if summary.DurationMs <= summary.ImpactDurationMs {
+		t.Fatalf("detection duration %d should include the clear window", summary.DurationMs)
+	}
+}
+
+func TestFlushClosesOpenOutages(t *testing.T) {
+	d := NewDetector(Config{Window: 10 * time.Second, Interval: time.Second})
+	base := time.Unix(1000, 0)
+
+	for i := 0; i < 5; i++ {
+		d.ProcessPing("a", base.Add(time.Duration(i)*time.Second), false, 0)
+		d.ProcessPing("b", base.Add(time.Duration(i)*time.Second), true, 10)
+	}
+	if d.ActiveOutageID("a") == "" {
+		t.Fatalf("expected open outage for a")
+	}
+
+	events := d.Flush(base.Add(5*time.Second), "shutdown")
+
+	var types []EventType
+	for _, e := range events {
+		types = append(types, e.Type())
+		switch evt := e.(type) {
+		case OutageEnd:
+			if evt.Reason != "shutdown" {
+				t.Fatalf("end reason = %s, want shutdown", evt.Reason)
+			}
+		case OutageSummary:
+			if evt.EndReason != "shutdown" || evt.PingSent == 0 {
+				t.Fatalf("unexpected partial summary: %+v", evt)
+			}
+		}
+	}
+	want := []EventType{EventOutageEnd, EventOutageSummary, EventIncidentEnd}
+	if len(types) != len(want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("events = %v, want %v", types, want)
+		}
+	}
+	if d.ActiveOutageID("a") != "" {
+		t.Fatalf("outage should be closed after flush")
+	}
+}
+
+func TestMOSOnlyOutageHasImpactEnd(t *testing.T) {
+	d := NewDetector(Config{
+		Window:   30 * time.Second,
+		Interval: time.Second,
+		Rules:    []Rule{{Metric: MetricMOS, Threshold: 3.6}},
+	})
+	base := time.Unix(1000, 0)
+	at := func(i int) time.Time { return base.Add(time.Duration(i) * time.Second) }
+
+	var summary *OutageSummary
+	for i := 0; i < 300 && summary == nil; i++ {
+		rtt := 20.0
+		if i >= 60 && i < 120 && i%2 == 0 {
+			rtt = 150
+		}
+		for _, e := range d.ProcessPing("a", at(i), true, rtt) {
+			if evt, ok := e.(OutageSummary); ok {
+				summary = &evt
+			}
+		}
+	}
+
+	if summary == nil {
+		t.Fatalf("expected the MOS outage to clear")
+	}
+	if summary.StartReason != MetricMOS {
+		t.Fatalf("start reason = %q, want %q", summary.StartReason, MetricMOS)
+	}
+	if !summary.ImpactEndTS.After(summary.ImpactStartTS) || summary.ImpactEndTS.Before(at(119)) {
+		t.Fatalf("impact = %v .. %v, want an end after the last jittery ping", summary.ImpactStartTS, summary.ImpactEndTS)
+	}
+	if summary.ImpactDurationMs <= 0 || summary.ImpactEndTS.After(summary.EndTS) {
+		t.Fatalf("impact duration = %d, end %v, outage end %v", summary.ImpactDurationMs, summary.ImpactEndTS, summary.EndTS)
+	}
+}
+
+func TestLiveStatsFollowWindow(t *testing.T) {
+	d := NewDetector(Config{Window: 10 * time.Second, Interval: time.Second})
+	base := time.Unix(1000, 0)
+
+	for i, rtt := range []float64{30, 10, 20, 0} {
+		d.ProcessPing("a", base.Add(time.Duration(i)*time.Second), rtt > 0, rtt)
+	}
+	d.ProcessPing("b", base, true, 5)
+
+	live := d.Live(base.Add(3 * time.Second))
+	if len(live) != 2 || live[0].Target != "a" || live[1].Target != "b" {
+		t.Fatalf("live = %+v", live)
+	}
+	a := live[0]
+	if a.Sent != 4 || a.Recv != 3 || a.LossPct != 25 || a.RttMinMs != 10 || a.RttP50Ms != 20 || a.RttMaxMs != 30 || a.JitterMs != 15 {
+		t.Fatalf("a = %+v", a)
+	}
+	if a.MOS <= 0 {
+		t.Fatalf("a mos = %v", a.MOS)
+	}
+
+	// Probes for a stopped: the window empties instead of freezing.
+	live = d.Live(base.Add(time.Minute))
+	if live[0].Sent != 0 || live[0].Recv != 0 {
+		t.Fatalf("stale window = %+v", live[0])
+	}
+}
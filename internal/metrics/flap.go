@@ -0,0 +1,131 @@
+package metrics
+
+import "time"
+
+const (
+	EventFlappingStart EventType = "flapping_start"
+	EventFlappingEnd   EventType = "flapping_end"
+)
+
+// FlapConfig controls outage merging and flap detection. A cleared outage is
+// held open for MergeGap; if the target trips again in that time the same
+// outage continues and its flap count grows. A target with at least Changes
+// outage state changes within Window is flapping, and its outage stays held
+// until the rate drops. Zero values disable each feature.
+type FlapConfig struct {
+	MergeGap time.Duration
+	Changes  int
+	Window   time.Duration
+}
+
+type FlappingStart struct {
+	Target     string
+	OutageID   string
+	IncidentID string
+	StartTS    time.Time
+	Changes    int
+}
+
+func (f FlappingStart) Type() EventType { return EventFlappingStart }
+
+type FlappingEnd struct {
+	Target     string
+	OutageID   string
+	IncidentID string
+	StartTS    time.Time
+	EndTS      time.Time
+	FlapCount  int
+}
+
+func (f FlappingEnd) Type() EventType { return EventFlappingEnd }
+
+// outageHold remembers where a cleared outage would have ended so that, if no
+// flap follows, it can be closed as of that moment.
+type outageHold struct {
+	since     time.Time
+	impactEnd time.Time
+	pingSent  int
+	pingRecv  int
+}
+
+func (d *Detector) holdsOutages() bool {
+	return d.flap.MergeGap > 0 || d.flapDetection()
+}
+
+func (d *Detector) flapDetection() bool {
+	return d.flap.Changes > 0 && d.flap.Window > 0
+}
+
+func (d *Detector) holdOutage(state *targetState, ts time.Time) {
+	state.hold = &outageHold{
+		since:     ts,
+		impactEnd: state.lastBad,
+		pingSent:  state.pingSent,
+		pingRecv:  state.pingRecv,
+	}
+	d.recordChange(state, ts)
+}
+
+func (d *Detector) resumeOutage(state *targetState, ts time.Time) {
+	state.hold = nil
+	state.flapCount++
+	d.recordChange(state, ts)
+}
+
+func (d *Detector) holdExpired(state *targetState, ts time.Time) bool {
+	return state.hold != nil && !state.flapping && ts.Sub(state.hold.since) >= d.flap.MergeGap
+}
+
+func (d *Detector) recordChange(state *targetState, ts time.Time) {
+	if d.flapDetection() {
+		state.changes = append(state.changes, ts)
+	}
+}
+
+// updateFlapping prunes state changes older than the flap window and reports a
+// transition into or out of the flapping state.
+func (d *Detector) updateFlapping(target string, state *targetState, ts time.Time) Event {
+	if !d.flapDetection() {
+		return nil
+	}
+
+	cutoff := ts.Add(-d.flap.Window)
+	keep := state.changes[:0]
+	for _, c := range state.changes {
+		if c.After(cutoff) {
+			keep = append(keep, c)
+		}
+	}
+	state.changes = keep
+
+	flapping := len(state.changes) >= d.flap.Changes
+	if flapping == state.flapping || !state.inOutage {
+		return nil
+	}
+
+	state.flapping = flapping
+	if flapping {
+		state.flapSince = ts
+		return FlappingStart{
+			Target:     target,
+			OutageID:   state.outageID,
+			IncidentID: state.incidentID,
+			StartTS:    ts,
+			Changes:    len(state.changes),
+		}
+	}
+
+	return d.flappingEnd(target, state, ts)
+}
+
+func (d *Detector) flappingEnd(target string, state *targetState, ts time.Time) FlappingEnd {
+	state.flapping = false
+	return FlappingEnd{
+		Target:     target,
+		OutageID:   state.outageID,
+		IncidentID: state.incidentID,
+		StartTS:    state.flapSince,
+		EndTS:      ts,
+		FlapCount:  state.flapCount,
+	}
+}
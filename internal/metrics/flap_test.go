@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func runPattern(d *Detector, base time.Time, seconds int, failing func(i int) bool) []Event {
+	var events []Event
+	for i := 0; i < seconds; i++ {
+		events = append(events, d.ProcessPing("a", base.Add(time.Duration(i)*time.Second), !failing(i), 10)...)
+	}
+	return events
+}
+
+func TestShortGapsMergeIntoOneOutage(t *testing.T) {
+	d := NewDetector(Config{Window: 10 * time.Second, Interval: time.Second, Flap: FlapConfig{MergeGap: time.Minute}})
+	base := time.Unix(1000, 0)
+
+	// Three five-second drops 40s apart, then quiet.
+	events := runPattern(d, base, 400, func(i int) bool { return i < 120 && i%40 < 5 })
+
+	var starts, summaries []Event
+	for _, e := range events {
+		switch e.(type) {
+		case OutageStart:
+			starts = append(starts, e)
+		case OutageSummary:
+			summaries = append(summaries, e)
+		}
+	}
+	if len(starts) != 1 || len(summaries) != 1 {
+		t.Fatalf("expected one merged outage, got %d starts and %d summaries", len(starts), len(summaries))
+	}
+
+	summary := summaries[0].(OutageSummary)
+	if summary.FlapCount != 2 {
+		t.Fatalf("flap count = %d, want 2", summary.FlapCount)
+	}
+	if !summary.ImpactEndTS.Equal(base.Add(84 * time.Second)) {
+		t.Fatalf("impact end = %v, want last failure", summary.ImpactEndTS)
+	}
+	if summary.EndTS.After(base.Add(120 * time.Second)) {
+		t.Fatalf("outage should end when the last flap cleared, got %v", summary.EndTS)
+	}
+	if summary.PingSent != int(summary.EndTS.Sub(summary.StartTS)/time.Second)+1 {
+		t.Fatalf("ping counts should stop at the end time: sent=%d", summary.PingSent)
+	}
+}
+
+func TestFlappingHoldsOutageUntilRateDrops(t *testing.T) {
+	cfg := Config{
+		Window:   10 * time.Second,
+		Interval: time.Second,
+		Flap:     FlapConfig{MergeGap: 30 * time.Second, Changes: 4, Window: 5 * time.Minute},
+	}
+	d := NewDetector(cfg)
+	base := time.Unix(1000, 0)
+
+	events := runPattern(d, base, 1000, func(i int) bool { return i < 200 && i%50 < 5 })
+
+	var flapStart *FlappingStart
+	var flapEnd *FlappingEnd
+	var summaries int
+	for _, e := range events {
+		switch evt := e.(type) {
+		case FlappingStart:
+			flapStart = &evt
+		case FlappingEnd:
+			flapEnd = &evt
+		case OutageSummary:
+			summaries++
+			if flapEnd == nil {
+				t.Fatalf("outage closed while still flapping")
+			}
+			if evt.FlapCount != 3 {
+				t.Fatalf("flap count = %d, want 3", evt.FlapCount)
+			}
+		}
+	}
+	if flapStart == nil || flapEnd == nil || summaries != 1 {
+		t.Fatalf("expected flap start, flap end and one summary: %v %v %d", flapStart, flapEnd, summaries)
+	}
+	if flapStart.OutageID != flapEnd.OutageID || flapEnd.FlapCount != 3 {
+		t.Fatalf("unexpected flap records: %+v %+v", flapStart, flapEnd)
+	}
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import "time"
+
+const EventProbeSample EventType = "probe_sample"
+
+// Phases of a recorded sample relative to the outage it documents.
+const (
+	PhasePreRoll  = "pre_roll"
+	PhaseOutage   = "outage"
+	PhasePostRoll = "post_roll"
+)
+
+// FlightConfig sets how much raw history is written when an outage opens and
+// how long samples keep streaming after it closes.
+type FlightConfig struct {
+	PreRoll  time.Duration
+	PostRoll time.Duration
+}
+
+// ProbeSample is one raw ping written around an outage.
+type ProbeSample struct {
+	Target     string
+	OutageID   string
+	IncidentID string
+	Phase      string
+	TS         time.Time
+	Seq        int
+	OK         bool
+	RTTMs      float64
+	Failure    string
+}
+
+func (p ProbeSample) Type() EventType { return EventProbeSample }
+
+// FlightRecorder keeps the last PreRoll of samples per target. While a target
+// is in an outage, and for PostRoll after, every sample is passed on; when an
+// outage opens the buffered history goes out first.
+type FlightRecorder struct {
+	cfg     FlightConfig
+	targets map[string]*flightState
+}
+
+// outageID and incidentID name the open outage, or the last one while its
+// post-roll runs.
+type flightState struct {
+	ring        []ProbeSample
+	open        bool
+	outageID    string
+	incidentID  string
+	postUntil   time.Time
+	lastEmitted time.Time
+}
+
+func NewFlightRecorder(cfg FlightConfig) *FlightRecorder {
+	return &FlightRecorder{cfg: cfg, targets: make(map[string]*flightState)}
+}
+
+// Record buffers s and returns the samples to log. outageID and incidentID
+// are the target's open outage after s was processed, empty when there is
+// none, so an outage that opened or closed on s is seen here.
+func (r *FlightRecorder) Record(s ProbeSample, outageID, incidentID string) []Event {
+	st := r.targets[s.Target]
+	if st == nil {
+		st = &flightState{}
+		r.targets[s.Target] = st
+	}
+
+	cutoff := s.TS.Add(-r.cfg.PreRoll)
+	drop := 0
+	for drop < len(st.ring) && !st.ring[drop].TS.After(cutoff) {
+		drop++
+	}
+	st.ring = st.ring[drop:]
+
+	var out []Event
+	switch {
+	case outageID != "":
+		if !st.open {
+			for _, prev := range st.ring {
+				if prev.TS.After(st.lastEmitted) {
+					out = append(out, st.tag(prev, outageID, incidentID, PhasePreRoll))
+				}
+			}
+		}
+		st.open = true
+		st.outageID, st.incidentID = outageID, incidentID
+		st.postUntil = time.Time{}
+		out = append(out, st.tag(s, outageID, incidentID, PhaseOutage))
+	case st.open:
+		st.open = false
+		if r.cfg.PostRoll > 0 {
+			st.postUntil = s.TS.Add(r.cfg.PostRoll)
+			out = append(out, st.tag(s, st.outageID, st.incidentID, PhasePostRoll))
+		}
+	case !st.postUntil.IsZero() && !s.TS.After(st.postUntil):
+		out = append(out, st.tag(s, st.outageID, st.incidentID, PhasePostRoll))
+	}
+
+	if r.cfg.PreRoll > 0 {
+		st.ring = append(st.ring, s)
+	}
+	return out
+}
+
+func (st *flightState) tag(s ProbeSample, outageID, incidentID, phase string) ProbeSample {
+	s.OutageID = outageID
+	s.IncidentID = incidentID
+	s.Phase = phase
+	st.lastEmitted = s.TS
+	return s
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlightRecorderPreAndPostRoll(t *testing.T) {
+	r := NewFlightRecorder(FlightConfig{PreRoll: 5 * time.Second, PostRoll: 3 * time.Second})
+	base := time.Unix(1000, 0)
+	record := func(i int, outageID string) []Event {
+		s := ProbeSample{Target: "a", TS: base.Add(time.Duration(i) * time.Second), Seq: i, OK: outageID == ""}
+		return r.Record(s, outageID, "inc")
+	}
+
+	for i := 0; i < 10; i++ {
+		if got := record(i, ""); len(got) != 0 {
+			t.Fatalf("sample %d logged outside an outage: %+v", i, got)
+		}
+	}
+
+	opened := record(10, "o1")
+	if len(opened) != 5 {
+		t.Fatalf("outage open wrote %d samples, want 4 pre-roll and the current one", len(opened))
+	}
+	first := opened[0].(ProbeSample)
+	if first.Seq != 6 || first.Phase != PhasePreRoll || first.OutageID != "o1" {
+		t.Fatalf("first pre-roll sample = %+v, want seq 6 tagged with the outage", first)
+	}
+	if last := opened[4].(ProbeSample); last.Seq != 10 || last.Phase != PhaseOutage {
+		t.Fatalf("current sample = %+v, want seq 10 in phase outage", last)
+	}
+
+	if got := record(11, "o1"); len(got) != 1 || got[0].(ProbeSample).Phase != PhaseOutage {
+		t.Fatalf("sample during outage = %+v", got)
+	}
+
+	var post []ProbeSample
+	for i := 12; i < 20; i++ {
+		for _, e := range record(i, "") {
+			post = append(post, e.(ProbeSample))
+		}
+	}
+	if len(post) != 4 || post[0].Seq != 12 || post[3].Seq != 15 {
+		t.Fatalf("post-roll = %+v, want seqs 12 to 15", post)
+	}
+	if post[3].OutageID != "o1" || post[3].Phase != PhasePostRoll {
+		t.Fatalf("post-roll sample = %+v, want it tagged with the closed outage", post[3])
+	}
+
+	// Samples already written in the post-roll are not repeated as pre-roll.
+	reopened := record(20, "o2")
+	if len(reopened) != 5 || reopened[0].(ProbeSample).Seq != 16 {
+		t.Fatalf("reopen wrote %+v, want seqs 16 to 20", reopened)
+	}
+}
@@ -0,0 +1,94 @@
+package metrics
+
+// RTTs are bucketed log-linearly: 0.1 ms steps below 100 ms, 1 ms steps below
+// 1 s, 10 ms steps below 10 s and a single overflow bucket above that. A
+// Fenwick tree over the bucket counts gives O(log n) inserts, removals and rank
+// queries regardless of how many samples the window holds.
+const (
+	fineLimitMs    = 100.0
+	mediumLimitMs  = 1000.0
+	coarseLimitMs  = 10000.0
+	fineBuckets    = 1000
+	mediumBuckets  = 900
+	coarseBuckets  = 900
+	overflowBucket = fineBuckets + mediumBuckets + coarseBuckets
+	bucketCount    = overflowBucket + 1
+)
+
+type rttHistogram struct {
+	tree  []int32
+	total int
+}
+
+func newRTTHistogram() rttHistogram {
+	return rttHistogram{tree: make([]int32, bucketCount+1)}
+}
+
+func (h *rttHistogram) add(rttMs float64) {
+	h.update(bucketFor(rttMs), 1)
+	h.total++
+}
+
+func (h *rttHistogram) remove(rttMs float64) {
+	h.update(bucketFor(rttMs), -1)
+	h.total--
+}
+
+func (h *rttHistogram) update(bucket int, delta int32) {
+	for i := bucket + 1; i < len(h.tree); i += i & -i {
+		h.tree[i] += delta
+	}
+}
+
+// rank returns the lower bound of the bucket holding the k-th smallest RTT (1-based).
+func (h *rttHistogram) rank(k int) float64 {
+	if k < 1 || k > h.total {
+		return 0
+	}
+
+	pos := 0
+	step := 1
+	for step*2 < len(h.tree) {
+		step *= 2
+	}
+	remaining := int32(k)
+	for ; step > 0; step /= 2 {
+		next := pos + step
+		if next < len(h.tree) && h.tree[next] < remaining {
+			pos = next
+			remaining -= h.tree[next]
+		}
+	}
+
+	return bucketValue(pos)
+}
+
+func bucketFor(rttMs float64) int {
+	const eps = 1e-9
+
+	switch {
+	case rttMs < 0:
+		return 0
+	case rttMs < fineLimitMs:
+		return int(rttMs*10 + eps)
+	case rttMs < mediumLimitMs:
+		return fineBuckets + int(rttMs-fineLimitMs+eps)
+	case rttMs < coarseLimitMs:
+		return fineBuckets + mediumBuckets + int((rttMs-mediumLimitMs)/10+eps)
+	default:
+		return overflowBucket
+	}
+}
+
+func bucketValue(bucket int) float64 {
+	switch {
+	case bucket < fineBuckets:
+		return float64(bucket) / 10
+	case bucket < fineBuckets+mediumBuckets:
+		return fineLimitMs + float64(bucket-fineBuckets)
+	case bucket < overflowBucket:
+		return mediumLimitMs + float64(bucket-fineBuckets-mediumBuckets)*10
+	default:
+		return coarseLimitMs
+	}
+}
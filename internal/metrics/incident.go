@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	ScopeAllTargets   = "all_targets"
+	ScopeSubset       = "subset"
+	ScopeSingleTarget = "single_target"
+)
+
+const (
+	EventIncidentStart EventType = "incident_start"
+	EventIncidentEnd   EventType = "incident_end"
+)
+
+type IncidentStart struct {
+	IncidentID    string
+	Target        string
+	OutageID      string
+	StartTS       time.Time
+	ImpactStartTS time.Time
+}
+
+func (i IncidentStart) Type() EventType { return EventIncidentStart }
+
+type IncidentEnd struct {
+	IncidentID    string
+	Target        string
+	OutageID      string
+	StartTS       time.Time
+	EndTS         time.Time
+	DurationMs    int64
+	ImpactStartTS time.Time
+	ImpactEndTS   time.Time
+	Scope         string
+	Targets       []string
+	OutageIDs     []string
+	TargetsTotal  int
+}
+
+func (i IncidentEnd) Type() EventType { return EventIncidentEnd }
+
+type incidentState struct {
+	id          string
+	start       time.Time
+	impactStart time.Time
+	impactEnd   time.Time
+	active      map[string]string
+	targets     []string
+	outageIDs   []string
+}
+
+func (d *Detector) joinIncident(target string, outageID string, ts time.Time, impactStart time.Time) (string, *IncidentStart) {
+	if d.incident == nil {
+		d.idCounter++
+		d.incident = &incidentState{
+			id:          fmt.Sprintf("incident-%d-%06d", ts.UnixNano(), d.idCounter),
+			start:       ts,
+			impactStart: impactStart,
+			active:      make(map[string]string),
+		}
+		d.incident.join(target, outageID)
+
+		return d.incident.id, &IncidentStart{
+			IncidentID:    d.incident.id,
+			Target:        target,
+			OutageID:      outageID,
+			StartTS:       ts,
+			ImpactStartTS: impactStart,
+		}
+	}
+
+	if impactStart.Before(d.incident.impactStart) {
+		d.incident.impactStart = impactStart
+	}
+	d.incident.join(target, outageID)
+	return d.incident.id, nil
+}
+
+func (d *Detector) leaveIncident(target string, ts time.Time, impactEnd time.Time) *IncidentEnd {
+	inc := d.incident
+	if inc == nil {
+		return nil
+	}
+
+	outageID, ok := inc.active[target]
+	if !ok {
+		return nil
+	}
+	delete(inc.active, target)
+	if impactEnd.After(inc.impactEnd) {
+		inc.impactEnd = impactEnd
+	}
+	if len(inc.active) > 0 {
+		return nil
+	}
+
+	d.incident = nil
+
+	targets := append([]string(nil), inc.targets...)
+	sort.Strings(targets)
+
+	return &IncidentEnd{
+		IncidentID:    inc.id,
+		Target:        target,
+		OutageID:      outageID,
+		StartTS:       inc.start,
+		EndTS:         ts,
+		DurationMs:    ts.Sub(inc.start).Milliseconds(),
+		ImpactStartTS: inc.impactStart,
+		ImpactEndTS:   inc.impactEnd,
+		Scope:         classifyScope(len(inc.targets), len(d.states)),
+		Targets:       targets,
+		OutageIDs:     append([]string(nil), inc.outageIDs...),
+		TargetsTotal:  len(d.states),
+	}
+}
+
+func (d *Detector) incidentScope() string {
+	if d.incident == nil {
+		return ""
+	}
+
+	return classifyScope(len(d.incident.targets), len(d.states))
+}
+
+func (i *incidentState) join(target string, outageID string) {
+	i.active[target] = outageID
+	i.outageIDs = append(i.outageIDs, outageID)
+	for _, t := range i.targets {
+		if t == target {
+			return
+		}
+	}
+	i.targets = append(i.targets, target)
+}
+
+func classifyScope(affected int, total int) string {
+	switch {
+	case affected >= total:
+		return ScopeAllTargets
+	case affected == 1:
+		return ScopeSingleTarget
+	default:
+		return ScopeSubset
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIncidentGroupsOverlappingOutages(t *testing.T) {
+	d := NewDetector(Config{Window: 10 * time.Second, Interval: time.Second})
+	base := time.Unix(1000, 0)
+	targets := []string{"a", "b", "c"}
+
+	var events []Event
+	for i := 0; i < 60; i++ {
+		ts := base.Add(time.Duration(i) * time.Second)
+		for _, target := range targets {
+			ok := true
+			if target != "c" && i >= 5 && i < 10 {
+				ok = false
+			}
+			events = append(events, d.ProcessPing(target, ts, ok, 10)...)
+		}
+	}
+
+	var starts []IncidentStart
+	var ends []IncidentEnd
+	outageIncidents := make(map[string]string)
+	for _, e := range events {
+		switch evt := e.(type) {
+		case IncidentStart:
+			starts = append(starts, evt)
+		case IncidentEnd:
+			ends = append(ends, evt)
+		case OutageStart:
+			outageIncidents[evt.OutageID] = evt.IncidentID
+		}
+	}
+
+	if len(starts) != 1 || len(ends) != 1 {
+		t.Fatalf("expected one incident, got %d starts and %d ends", len(starts), len(ends))
+	}
+	if len(outageIncidents) != 2 {
+		t.Fatalf("expected two outages, got %d", len(outageIncidents))
+	}
+	for outageID, incidentID := range outageIncidents {
+		if incidentID != starts[0].IncidentID {
+			t.Fatalf("outage %s has incident %q, want %q", outageID, incidentID, starts[0].IncidentID)
+		}
+	}
+
+	end := ends[0]
+	if end.Scope != ScopeSubset {
+		t.Fatalf("expected scope %s, got %s", ScopeSubset, end.Scope)
+	}
+	if len(end.Targets) != 2 || end.Targets[0] != "a" || end.Targets[1] != "b" {
+		t.Fatalf("unexpected incident targets: %v", end.Targets)
+	}
+	if end.TargetsTotal != 3 {
+		t.Fatalf("expected 3 total targets, got %d", end.TargetsTotal)
+	}
+}
+
+func TestClassifyScope(t *testing.T) {
+	cases := []struct {
+		affected int
+		total    int
+		want     string
+	}{
+		{affected: 3, total: 3, want: ScopeAllTargets},
+		{affected: 1, total: 1, want: ScopeAllTargets},
+		{affected: 1, total: 3, want: ScopeSingleTarget},
+		{affected: 2, total: 3, want: ScopeSubset},
+	}
+
+	for _, c := range cases {
+		if got := classifyScope(c.affected, c.total); got != c.want {
+			t.Fatalf("classifyScope(%d, %d) = %s, want %s", c.affected, c.total, got, c.want)
+		}
+	}
+}
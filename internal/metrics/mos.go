@@ -0,0 +1,23 @@
+package metrics
+
+// eModel estimates the R-factor and MOS of a voice call over a path with the
+// given round-trip latency, jitter and loss, using the simplified ITU-T G.107
+// E-model: jitter counts double against latency, plus 10ms of codec delay.
+func eModel(rttAvgMs, jitterMs, lossPct float64) (float64, float64) {
+	latency := rttAvgMs + 2*jitterMs + 10
+
+	r := 93.2 - latency/40
+	if latency >= 160 {
+		r = 93.2 - (latency-120)/10
+	}
+	r -= 2.5 * lossPct
+
+	switch {
+	case r < 0:
+		return 0, 1
+	case r > 100:
+		r = 100
+	}
+
+	return r, 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEModel(t *testing.T) {
+	cases := []struct {
+		rtt, jitter, loss float64
+		r, mos            float64
+	}{
+		{rtt: 20, jitter: 2, loss: 0, r: 92.35, mos: 4.39},
+		{rtt: 150, jitter: 10, loss: 0, r: 87.2, mos: 4.26},
+		{rtt: 40, jitter: 5, loss: 10, r: 66.7, mos: 3.44},
+		{rtt: 0, jitter: 0, loss: 100, r: 0, mos: 1},
+	}
+
+	for _, c := range cases {
+		r, mos := eModel(c.rtt, c.jitter, c.loss)
+		if math.Abs(r-c.r) > 0.01 || math.Abs(mos-c.mos) > 0.01 {
+			t.Fatalf("eModel(%v, %v, %v) = %.2f, %.2f; want %.2f, %.2f", c.rtt, c.jitter, c.loss, r, mos, c.r, c.mos)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const EventIntervalStats EventType = "interval_stats"
+
+type IntervalStats struct {
+	Target        string
+	OutageID      string
+	IncidentID    string
+	StartTS       time.Time
+	EndTS         time.Time
+	Sent          int
+	Recv          int
+	LossPct       float64
+	RttMinMs      float64
+	RttAvgMs      float64
+	RttP50Ms      float64
+	RttP95Ms      float64
+	RttMaxMs      float64
+	JitterMs      float64
+	RFactor       float64
+	MOS           float64
+	DNSSent       int
+	DNSOK         int
+	DNSSuccessPct float64
+}
+
+func (i IntervalStats) Type() EventType { return EventIntervalStats }
+
+type intervalAccumulator struct {
+	start     time.Time
+	sent      int
+	recv      int
+	rttSum    float64
+	rttMin    float64
+	rttMax    float64
+	rtts      rttHistogram
+	jitterSum float64
+	jitterN   int
+	lastRTT   float64
+	hasLast   bool
+}
+
+func newIntervalAccumulator(start time.Time) *intervalAccumulator {
+	return &intervalAccumulator{start: start, rtts: newRTTHistogram()}
+}
+
+func (a *intervalAccumulator) add(s pingSample) {
+	a.sent++
+	if !s.ok {
+		return
+	}
+
+	a.recv++
+	a.rttSum += s.rtt
+	if a.recv == 1 || s.rtt < a.rttMin {
+		a.rttMin = s.rtt
+	}
+	if s.rtt > a.rttMax {
+		a.rttMax = s.rtt
+	}
+	a.rtts.add(s.rtt)
+
+	if a.hasLast {
+		a.jitterSum += math.Abs(s.rtt - a.lastRTT)
+		a.jitterN++
+	}
+	a.lastRTT = s.rtt
+	a.hasLast = true
+}
+
+func (a *intervalAccumulator) reset(start time.Time) {
+	lastRTT, hasLast := a.lastRTT, a.hasLast
+	*a = intervalAccumulator{start: start, rtts: a.rtts, lastRTT: lastRTT, hasLast: hasLast}
+	a.rtts.clear()
+}
+
+func (h *rttHistogram) clear() {
+	for i := range h.tree {
+		h.tree[i] = 0
+	}
+	h.total = 0
+}
+
+func (d *Detector) Rollup(ts time.Time) []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	targets := make([]string, 0, len(d.states))
+	for target := range d.states {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	var dnsPct float64
+	if d.dnsSent > 0 {
+		dnsPct = float64(d.dnsOK) / float64(d.dnsSent) * 100.0
+	}
+
+	events := make([]Event, 0, len(targets))
+	for _, target := range targets {
+		state := d.states[target]
+		acc := state.interval
+		if acc == nil || acc.sent == 0 {
+			continue
+		}
+
+		stats := IntervalStats{
+			Target:        target,
+			StartTS:       acc.start,
+			EndTS:         ts,
+			Sent:          acc.sent,
+			Recv:          acc.recv,
+			LossPct:       float64(acc.sent-acc.recv) / float64(acc.sent) * 100.0,
+			DNSSent:       d.dnsSent,
+			DNSOK:         d.dnsOK,
+			DNSSuccessPct: dnsPct,
+		}
+		if state.inOutage {
+			stats.OutageID = state.outageID
+			stats.IncidentID = state.incidentID
+		}
+		if acc.recv > 0 {
+			stats.RttMinMs = acc.rttMin
+			stats.RttMaxMs = acc.rttMax
+			stats.RttAvgMs = acc.rttSum / float64(acc.recv)
+			stats.RttP50Ms = acc.rtts.rank(int(float64(acc.recv-1)*0.5) + 1)
+			stats.RttP95Ms = acc.rtts.rank(int(float64(acc.recv-1)*0.95) + 1)
+		}
+		if acc.jitterN > 0 {
+			stats.JitterMs = acc.jitterSum / float64(acc.jitterN)
+		}
+		stats.RFactor, stats.MOS = eModel(stats.RttAvgMs, stats.JitterMs, stats.LossPct)
+
+		events = append(events, stats)
+		acc.reset(ts)
+	}
+
+	d.dnsSent = 0
+	d.dnsOK = 0
+
+	return events
+}
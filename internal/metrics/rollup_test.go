@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollupComputesIntervalStats(t *testing.T) {
+	d := NewDetector(Config{Window: 60 * time.Second, Interval: time.Second})
+	base := time.Unix(1000, 0)
+
+	rtts := []float64{10, 20, 10, 40, 0}
+	for i, rtt := range rtts {
+		d.ProcessPing("a", base.Add(time.Duration(i)*time.Second), rtt > 0, rtt)
+	}
+	d.ProcessDNS(base, true)
+	d.ProcessDNS(base, false)
+
+	events := d.Rollup(base.Add(5 * time.Second))
+	if len(events) != 1 {
+		t.Fatalf("expected one interval_stats event, got %d", len(events))
+	}
+	stats := events[0].(IntervalStats)
+
+	if stats.Sent != 5 || stats.Recv != 4 {
+		t.Fatalf("sent/recv = %d/%d, want 5/4", stats.Sent, stats.Recv)
+	}
+	if stats.LossPct != 20 {
+		t.Fatalf("loss = %v, want 20", stats.LossPct)
+	}
+	if stats.RttMinMs != 10 || stats.RttMaxMs != 40 || stats.RttAvgMs != 20 {
+		t.Fatalf("min/avg/max = %v/%v/%v, want 10/20/40", stats.RttMinMs, stats.RttAvgMs, stats.RttMaxMs)
+	}
+	if stats.RttP50Ms != 10 {
+		t.Fatalf("p50 = %v, want 10", stats.RttP50Ms)
+	}
+	if want := 50.0 / 3; stats.JitterMs != want {
+		t.Fatalf("jitter = %v, want %v", stats.JitterMs, want)
+	}
+	if stats.DNSSent != 2 || stats.DNSSuccessPct != 50 {
+		t.Fatalf("dns = %d sent, %v%% ok", stats.DNSSent, stats.DNSSuccessPct)
+	}
+
+	d.ProcessPing("a", base.Add(6*time.Second), true, 30)
+	next := d.Rollup(base.Add(7 * time.Second))[0].(IntervalStats)
+	if next.Sent != 1 || !next.StartTS.Equal(base.Add(5*time.Second)) {
+		t.Fatalf("accumulator not reset: %+v", next)
+	}
+	if next.DNSSent != 0 {
+		t.Fatalf("dns counters not reset: %d", next.DNSSent)
+	}
+}
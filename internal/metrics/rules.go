@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MetricLossPct             = "loss_pct"
+	MetricRttP95Ms            = "rtt_p95_ms"
+	MetricRttAvgMs            = "rtt_avg_ms"
+	MetricConsecutiveFailures = "consecutive_failures"
+	MetricMOS                 = "mos"
+)
+
+type Rule struct {
+	Name      string
+	Metric    string
+	Window    time.Duration
+	Threshold float64
+}
+
+type Config struct {
+	Window   time.Duration
+	Interval time.Duration
+	Rules    []Rule
+	Flap     FlapConfig
+	Baseline BaselineConfig
+}
+
+type WindowStats struct {
+	Window   time.Duration
+	Samples  int
+	LossPct  float64
+	RttP95Ms float64
+	RttAvgMs float64
+	JitterMs float64
+	RFactor  float64
+	MOS      float64
+}
+
+func DefaultRules(window time.Duration) []Rule {
+	return []Rule{
+		{Name: MetricLossPct, Metric: MetricLossPct, Window: window, Threshold: lossThresholdPct},
+		{Name: MetricRttP95Ms, Metric: MetricRttP95Ms, Window: window, Threshold: rttP95ThresholdMs},
+		{Name: MetricConsecutiveFailures, Metric: MetricConsecutiveFailures, Threshold: consecutiveFailThresh},
+	}
+}
+
+type compiledRule struct {
+	Rule
+	windowIdx int
+}
+
+func compileRules(window time.Duration, rules []Rule) ([]time.Duration, []compiledRule) {
+	if len(rules) == 0 {
+		rules = DefaultRules(window)
+	}
+
+	spans := []time.Duration{window}
+	for _, r := range rules {
+		if r.Metric != MetricConsecutiveFailures && r.Window > 0 {
+			spans = append(spans, r.Window)
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i] < spans[j] })
+	spans = dedupDurations(spans)
+
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Window <= 0 {
+			r.Window = window
+		}
+		if r.Name == "" {
+			r.Name = r.Metric
+			if r.Metric != MetricConsecutiveFailures && r.Window != window {
+				r.Name = fmt.Sprintf("%s_%ds", r.Metric, int(r.Window.Seconds()))
+			}
+		}
+		compiled = append(compiled, compiledRule{Rule: r, windowIdx: spanIndex(spans, r.Window)})
+	}
+
+	return spans, compiled
+}
+
+func evaluateRules(rules []compiledRule, stats []WindowStats, consecutiveFailures int) (string, bool) {
+	var reasons []string
+	for _, r := range trippedRules(rules, stats, consecutiveFailures) {
+		reasons = append(reasons, r.Name)
+	}
+	if len(reasons) == 0 {
+		return "", false
+	}
+
+	return strings.Join(reasons, ","), true
+}
+
+func trippedRules(rules []compiledRule, stats []WindowStats, consecutiveFailures int) []compiledRule {
+	var tripped []compiledRule
+	for _, r := range rules {
+		if r.tripped(stats, consecutiveFailures) {
+			tripped = append(tripped, r)
+		}
+	}
+
+	return tripped
+}
+
+func mosTripped(rules []compiledRule, stats []WindowStats) bool {
+	for _, r := range rules {
+		if r.Metric == MetricMOS && r.tripped(stats, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func slowThreshold(rules []compiledRule) float64 {
+	var slow float64
+	for _, r := range rules {
+		if r.Metric != MetricRttP95Ms && r.Metric != MetricRttAvgMs {
+			continue
+		}
+		if slow == 0 || r.Threshold < slow {
+			slow = r.Threshold
+		}
+	}
+
+	return slow
+}
+
+// tripped compares the rule's metric with its threshold. MOS is the only
+// metric where lower is worse, and a window without samples has no score.
+func (r compiledRule) tripped(stats []WindowStats, consecutiveFailures int) bool {
+	if r.Metric == MetricMOS {
+		return stats[r.windowIdx].Samples > 0 && r.value(stats, consecutiveFailures) <= r.Threshold
+	}
+
+	return r.value(stats, consecutiveFailures) >= r.Threshold
+}
+
+func (r compiledRule) value(stats []WindowStats, consecutiveFailures int) float64 {
+	s := stats[r.windowIdx]
+	switch r.Metric {
+	case MetricLossPct:
+		return s.LossPct
+	case MetricRttP95Ms:
+		return s.RttP95Ms
+	case MetricRttAvgMs:
+		return s.RttAvgMs
+	case MetricConsecutiveFailures:
+		return float64(consecutiveFailures)
+	case MetricMOS:
+		return s.MOS
+	default:
+		return 0
+	}
+}
+
+func dedupDurations(in []time.Duration) []time.Duration {
+	out := in[:0]
+	for i, d := range in {
+		if i == 0 || d != in[i-1] {
+			out = append(out, d)
+		}
+	}
+
+	return out
+}
+
+func spanIndex(spans []time.Duration, span time.Duration) int {
+	for i, s := range spans {
+		if s == span {
+			return i
+		}
+	}
+
+	return 0
+}
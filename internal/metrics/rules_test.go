@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLongWindowRuleCatchesChronicLoss(t *testing.T) {
+	d := NewDetector(Config{
+		Window:   60 * time.Second,
+		Interval: time.Second,
+		Rules: []Rule{
+			{Metric: MetricLossPct, Window: 60 * time.Second, Threshold: 5},
+			{Metric: MetricLossPct, Window: 300 * time.Second, Threshold: 2},
+		},
+	})
+	base := time.Unix(1000, 0)
+
+	var start *OutageStart
+	for i := 0; i < 600 && start == nil; i++ {
+		ok := i%40 != 39
+		for _, e := range d.ProcessPing("a", base.Add(time.Duration(i)*time.Second), ok, 10) {
+			if evt, isStart := e.(OutageStart); isStart {
+				start = &evt
+			}
+		}
+	}
+
+	if start == nil {
+		t.Fatalf("expected chronic loss to open an outage")
+	}
+	if start.Reason != "loss_pct_300s" {
+		t.Fatalf("expected reason loss_pct_300s, got %s", start.Reason)
+	}
+	if len(start.Windows) != 2 || start.Windows[0].Window != 60*time.Second || start.Windows[1].Window != 300*time.Second {
+		t.Fatalf("unexpected window stats: %+v", start.Windows)
+	}
+}
+
+func TestShortWindowRuleCatchesBriefTotalLoss(t *testing.T) {
+	d := NewDetector(Config{
+		Window:   300 * time.Second,
+		Interval: time.Second,
+		Rules: []Rule{
+			{Metric: MetricLossPct, Window: 300 * time.Second, Threshold: 5},
+			{Metric: MetricLossPct, Window: 10 * time.Second, Threshold: 50},
+		},
+	})
+	base := time.Unix(1000, 0)
+
+	var reasons []string
+	for i := 0; i < 400; i++ {
+		ok := i < 350 || i >= 356
+		for _, e := range d.ProcessPing("a", base.Add(time.Duration(i)*time.Second), ok, 10) {
+			if evt, isStart := e.(OutageStart); isStart {
+				reasons = append(reasons, evt.Reason)
+			}
+		}
+	}
+
+	if len(reasons) != 1 {
+		t.Fatalf("expected one outage, got %v", reasons)
+	}
+	if !strings.Contains(reasons[0], "loss_pct_10s") || strings.Contains(reasons[0], "loss_pct,") {
+		t.Fatalf("expected only the short window rule to trip, got %s", reasons[0])
+	}
+}
+
+func TestMOSRuleTripsOnJitter(t *testing.T) {
+	d := NewDetector(Config{
+		Window:   30 * time.Second,
+		Interval: time.Second,
+		Rules:    []Rule{{Metric: MetricMOS, Threshold: 3.6}},
+	})
+	base := time.Unix(1000, 0)
+
+	var start *OutageStart
+	for i := 0; i < 120 && start == nil; i++ {
+		rtt := 20.0
+		if i >= 60 && i%2 == 0 {
+			rtt = 150
+		}
+		for _, e := range d.ProcessPing("a", base.Add(time.Duration(i)*time.Second), true, rtt) {
+			if evt, ok := e.(OutageStart); ok {
+				start = &evt
+			}
+		}
+	}
+
+	if start == nil {
+		t.Fatalf("expected jitter to push MOS below the threshold")
+	}
+	if start.Reason != "mos" || start.MOS > 3.6 || start.Windows[0].JitterMs == 0 {
+		t.Fatalf("unexpected start: reason=%s mos=%v windows=%+v", start.Reason, start.MOS, start.Windows)
+	}
+}
@@ -0,0 +1,277 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/statefile"
+)
+
+const snapshotVersion = 1
+
+type Snapshot struct {
+	Version   int                       `json:"version"`
+	TakenAt   time.Time                 `json:"taken_at"`
+	IDCounter int64                     `json:"id_counter"`
+	Incident  *incidentSnapshot         `json:"incident,omitempty"`
+	Targets   map[string]targetSnapshot `json:"targets"`
+}
+
+type sampleSnapshot struct {
+	TS  time.Time `json:"ts"`
+	OK  bool      `json:"ok"`
+	RTT float64   `json:"rtt"`
+}
+
+type targetSnapshot struct {
+	Samples         []sampleSnapshot `json:"samples"`
+	ConsecFail      int              `json:"consec_fail"`
+	FailSince       time.Time        `json:"fail_since"`
+	LastBad         time.Time        `json:"last_bad"`
+	InOutage        bool             `json:"in_outage"`
+	OutageID        string           `json:"outage_id,omitempty"`
+	IncidentID      string           `json:"incident_id,omitempty"`
+	OutageStart     time.Time        `json:"outage_start"`
+	StartReason     string           `json:"start_reason,omitempty"`
+	ImpactStart     time.Time        `json:"impact_start"`
+	ClearSince      *time.Time       `json:"clear_since,omitempty"`
+	LossPctMax      float64          `json:"loss_pct_max"`
+	RttP95MaxMs     float64          `json:"rtt_p95_max_ms"`
+	RttAvgMaxMs     float64          `json:"rtt_avg_max_ms"`
+	MOSMin          float64          `json:"mos_min"`
+	ConsecFailMax   int              `json:"consec_fail_max"`
+	PingSent        int              `json:"ping_sent"`
+	PingRecv        int              `json:"ping_recv"`
+	DNSErrors       int              `json:"dns_errors"`
+	TracerouteCount int              `json:"traceroute_count"`
+	WindowsMax      []WindowStats    `json:"windows_max,omitempty"`
+	Hold            *holdSnapshot    `json:"hold,omitempty"`
+	FlapCount       int              `json:"flap_count"`
+	Flapping        bool             `json:"flapping"`
+	FlapSince       time.Time        `json:"flap_since"`
+	Changes         []time.Time      `json:"changes,omitempty"`
+}
+
+type holdSnapshot struct {
+	Since     time.Time `json:"since"`
+	ImpactEnd time.Time `json:"impact_end"`
+	PingSent  int       `json:"ping_sent"`
+	PingRecv  int       `json:"ping_recv"`
+}
+
+type incidentSnapshot struct {
+	ID          string            `json:"id"`
+	Start       time.Time         `json:"start"`
+	ImpactStart time.Time         `json:"impact_start"`
+	ImpactEnd   time.Time         `json:"impact_end"`
+	Active      map[string]string `json:"active"`
+	Targets     []string          `json:"targets"`
+	OutageIDs   []string          `json:"outage_ids"`
+}
+
+func (d *Detector) Snapshot(ts time.Time) Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	snap := Snapshot{
+		Version:   snapshotVersion,
+		TakenAt:   ts,
+		IDCounter: d.idCounter,
+		Targets:   make(map[string]targetSnapshot, len(d.states)),
+	}
+
+	if inc := d.incident; inc != nil {
+		active := make(map[string]string, len(inc.active))
+		for k, v := range inc.active {
+			active[k] = v
+		}
+		snap.Incident = &incidentSnapshot{
+			ID:          inc.id,
+			Start:       inc.start,
+			ImpactStart: inc.impactStart,
+			ImpactEnd:   inc.impactEnd,
+			Active:      active,
+			Targets:     append([]string(nil), inc.targets...),
+			OutageIDs:   append([]string(nil), inc.outageIDs...),
+		}
+	}
+
+	for target, state := range d.states {
+		var samples []sampleSnapshot
+		state.windows[len(state.windows)-1].each(func(s pingSample) {
+			samples = append(samples, sampleSnapshot{TS: s.ts, OK: s.ok, RTT: s.rtt})
+		})
+
+		var hold *holdSnapshot
+		if h := state.hold; h != nil {
+			hold = &holdSnapshot{Since: h.since, ImpactEnd: h.impactEnd, PingSent: h.pingSent, PingRecv: h.pingRecv}
+		}
+
+		snap.Targets[target] = targetSnapshot{
+			Samples:         samples,
+			ConsecFail:      state.consecFail,
+			FailSince:       state.failSince,
+			LastBad:         state.lastBad,
+			InOutage:        state.inOutage,
+			OutageID:        state.outageID,
+			IncidentID:      state.incidentID,
+			OutageStart:     state.outageStart,
+			StartReason:     state.startReason,
+			ImpactStart:     state.impactStart,
+			ClearSince:      state.clearSince,
+			LossPctMax:      state.lossPctMax,
+			RttP95MaxMs:     state.rttP95MaxMs,
+			RttAvgMaxMs:     state.rttAvgMaxMs,
+			MOSMin:          state.mosMin,
+			ConsecFailMax:   state.consecFailMax,
+			PingSent:        state.pingSent,
+			PingRecv:        state.pingRecv,
+			DNSErrors:       state.dnsErrors,
+			TracerouteCount: state.tracerouteCount,
+			WindowsMax:      append([]WindowStats(nil), state.windowsMax...),
+			Hold:            hold,
+			FlapCount:       state.flapCount,
+			Flapping:        state.flapping,
+			FlapSince:       state.flapSince,
+			Changes:         append([]time.Time(nil), state.changes...),
+		}
+	}
+
+	return snap
+}
+
+// Restore loads a snapshot into an empty detector. When the snapshot is older
+// than maxGap the open outages cannot be resumed honestly, so they are closed
+// as interrupted at the snapshot time and the returned events must be logged.
+// Targets that are no longer configured are closed the same way and dropped.
+func (d *Detector) Restore(snap Snapshot, targets []string, now time.Time, maxGap time.Duration) []Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if snap.IDCounter > d.idCounter {
+		d.idCounter = snap.IDCounter
+	}
+	stale := now.Sub(snap.TakenAt) > maxGap
+
+	if snap.Incident != nil {
+		active := make(map[string]string, len(snap.Incident.Active))
+		for k, v := range snap.Incident.Active {
+			active[k] = v
+		}
+		d.incident = &incidentState{
+			id:          snap.Incident.ID,
+			start:       snap.Incident.Start,
+			impactStart: snap.Incident.ImpactStart,
+			impactEnd:   snap.Incident.ImpactEnd,
+			active:      active,
+			targets:     append([]string(nil), snap.Incident.Targets...),
+			outageIDs:   append([]string(nil), snap.Incident.OutageIDs...),
+		}
+	}
+
+	for target, ts := range snap.Targets {
+		state := d.stateFor(target)
+		for _, s := range ts.Samples {
+			for _, w := range state.windows {
+				w.add(pingSample{ts: s.TS, ok: s.OK, rtt: s.RTT})
+			}
+		}
+
+		state.consecFail = ts.ConsecFail
+		state.failSince = ts.FailSince
+		state.lastBad = ts.LastBad
+		state.inOutage = ts.InOutage
+		state.outageID = ts.OutageID
+		state.incidentID = ts.IncidentID
+		state.outageStart = ts.OutageStart
+		state.startReason = ts.StartReason
+		state.impactStart = ts.ImpactStart
+		state.clearSince = ts.ClearSince
+		state.lossPctMax = ts.LossPctMax
+		state.rttP95MaxMs = ts.RttP95MaxMs
+		state.rttAvgMaxMs = ts.RttAvgMaxMs
+		state.mosMin = ts.MOSMin
+		state.consecFailMax = ts.ConsecFailMax
+		state.pingSent = ts.PingSent
+		state.pingRecv = ts.PingRecv
+		state.dnsErrors = ts.DNSErrors
+		state.tracerouteCount = ts.TracerouteCount
+		state.windowsMax = ts.WindowsMax
+		if h := ts.Hold; h != nil {
+			state.hold = &outageHold{since: h.Since, impactEnd: h.ImpactEnd, pingSent: h.PingSent, pingRecv: h.PingRecv}
+		}
+		state.flapCount = ts.FlapCount
+		state.flapping = ts.Flapping
+		state.flapSince = ts.FlapSince
+		state.changes = ts.Changes
+		if state.inOutage && len(state.windowsMax) != len(state.windows) {
+			state.windowsMax = make([]WindowStats, len(state.windows))
+		}
+	}
+
+	known := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		known[target] = true
+	}
+
+	restored := make([]string, 0, len(d.states))
+	for target := range d.states {
+		restored = append(restored, target)
+	}
+	sort.Strings(restored)
+
+	var events []Event
+	for _, target := range restored {
+		state := d.states[target]
+		if !stale && known[target] {
+			continue
+		}
+		if state.inOutage {
+			events = append(events, d.closeOutage(target, state, snap.TakenAt, "interrupted", state.stats())...)
+		}
+		if !known[target] {
+			delete(d.states, target)
+			continue
+		}
+		for _, w := range state.windows {
+			w.prune(now)
+		}
+		state.consecFail = 0
+	}
+
+	return events
+}
+
+func SaveSnapshot(path string, snap Snapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("marshal snapshot: %w", err)
+	}
+
+	return statefile.Write(path, b, "snapshot", true)
+}
+
+func LoadSnapshot(path string) (Snapshot, bool, error) {
+	var snap Snapshot
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return snap, false, nil
+	}
+	if err != nil {
+		return snap, false, fmt.Errorf("read snapshot: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return snap, false, fmt.Errorf("decode snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return snap, false, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	return snap, true, nil
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openOutage(t *testing.T, d *Detector, base time.Time) string {
+	t.Helper()
+
+	for i := 0; i < 10; i++ {
+		for _, e := range d.ProcessPing("a", base.Add(time.Duration(i)*time.Second), i < 5, 10) {
+			if start, ok := e.(OutageStart); ok {
+				return start.OutageID
+			}
+		}
+	}
+
+	t.Fatalf("expected outage to open")
+	return ""
+}
+
+func TestSnapshotResumesOpenOutage(t *testing.T) {
+	cfg := Config{Window: 10 * time.Second, Interval: time.Second}
+	base := time.Unix(1000, 0)
+
+	d := NewDetector(cfg)
+	outageID := openOutage(t, d, base)
+
+	path := filepath.Join(t.TempDir(), "detector.json")
+	if err := SaveSnapshot(path, d.Snapshot(base.Add(10*time.Second))); err != nil {
+		t.Fatalf("save snapshot: %v", err)
+	}
+	snap, ok, err := LoadSnapshot(path)
+	if err != nil || !ok {
+		t.Fatalf("load snapshot: ok=%v err=%v", ok, err)
+	}
+
+	restored := NewDetector(cfg)
+	if events := restored.Restore(snap, []string{"a"}, base.Add(15*time.Second), time.Minute); len(events) != 0 {
+		t.Fatalf("expected no events on resume, got %v", events)
+	}
+	if got := restored.ActiveOutageID("a"); got != outageID {
+		t.Fatalf("active outage = %q, want %q", got, outageID)
+	}
+
+	var summary *OutageSummary
+	for i := 15; i < 60 && summary == nil; i++ {
+		for _, e := range restored.ProcessPing("a", base.Add(time.Duration(i)*time.Second), true, 10) {
+			if evt, ok := e.(OutageSummary); ok {
+				summary = &evt
+			}
+		}
+	}
+	if summary == nil || summary.OutageID != outageID {
+		t.Fatalf("expected resumed outage %s to close, got %+v", outageID, summary)
+	}
+}
+
+func TestSnapshotClosesStaleOutage(t *testing.T) {
+	cfg := Config{Window: 10 * time.Second, Interval: time.Second}
+	base := time.Unix(1000, 0)
+
+	d := NewDetector(cfg)
+	outageID := openOutage(t, d, base)
+	takenAt := base.Add(10 * time.Second)
+	snap := d.Snapshot(takenAt)
+
+	restored := NewDetector(cfg)
+	events := restored.Restore(snap, []string{"a"}, takenAt.Add(time.Hour), time.Minute)
+
+	var end *OutageEnd
+	var summary *OutageSummary
+	for _, e := range events {
+		switch evt := e.(type) {
+		case OutageEnd:
+			end = &evt
+		case OutageSummary:
+			summary = &evt
+		}
+	}
+	if end == nil || end.Reason != "interrupted" || end.OutageID != outageID {
+		t.Fatalf("expected interrupted end for %s, got %+v", outageID, end)
+	}
+	if summary == nil || !summary.EndTS.Equal(takenAt) {
+		t.Fatalf("expected summary ending at snapshot time, got %+v", summary)
+	}
+	if restored.ActiveOutageID("a") != "" {
+		t.Fatalf("stale outage should not stay open")
+	}
+}
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"math"
+	"time"
+)
+
+type sampleWindow struct {
+	span   time.Duration
+	ring   []pingSample
+	head   int
+	size   int
+	recv   int
+	rttSum float64
+	rtts   rttHistogram
+
+	// Each received sample carries the RTT difference to the next received
+	// one, so jitter can be kept as a running sum like the mean.
+	lastOK    int
+	jitterSum float64
+	pairs     int
+}
+
+func newSampleWindow(span time.Duration, capacity int) *sampleWindow {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &sampleWindow{
+		span:   span,
+		ring:   make([]pingSample, capacity),
+		rtts:   newRTTHistogram(),
+		lastOK: -1,
+	}
+}
+
+func (w *sampleWindow) add(s pingSample) {
+	w.prune(s.ts)
+	if w.size == len(w.ring) {
+		w.grow()
+	}
+
+	idx := (w.head + w.size) % len(w.ring)
+	w.ring[idx] = s
+	w.size++
+	if s.ok {
+		w.recv++
+		w.rttSum += s.rtt
+		w.rtts.add(s.rtt)
+		if w.lastOK >= 0 {
+			prev := &w.ring[w.lastOK]
+			prev.nextDiff = math.Abs(s.rtt - prev.rtt)
+			prev.paired = true
+			w.jitterSum += prev.nextDiff
+			w.pairs++
+		}
+		w.lastOK = idx
+	}
+}
+
+func (w *sampleWindow) prune(now time.Time) {
+	cutoff := now.Add(-w.span)
+	for w.size > 0 {
+		oldest := w.ring[w.head]
+		if !oldest.ts.Before(cutoff) {
+			break
+		}
+
+		w.ring[w.head] = pingSample{}
+		if w.head == w.lastOK {
+			w.lastOK = -1
+		}
+		w.head = (w.head + 1) % len(w.ring)
+		w.size--
+		if oldest.ok {
+			w.recv--
+			w.rttSum -= oldest.rtt
+			w.rtts.remove(oldest.rtt)
+		}
+		if oldest.paired {
+			w.jitterSum -= oldest.nextDiff
+			w.pairs--
+		}
+	}
+
+	if w.recv == 0 {
+		w.rttSum = 0
+	}
+	if w.pairs == 0 {
+		w.jitterSum = 0
+	}
+}
+
+func (w *sampleWindow) grow() {
+	ring := make([]pingSample, len(w.ring)*2)
+	for i := 0; i < w.size; i++ {
+		ring[i] = w.ring[(w.head+i)%len(w.ring)]
+	}
+	if w.lastOK >= 0 {
+		w.lastOK = (w.lastOK - w.head + len(w.ring)) % len(w.ring)
+	}
+	w.ring = ring
+	w.head = 0
+}
+
+func (w *sampleWindow) stats() WindowStats {
+	stats := WindowStats{Window: w.span, Samples: w.size}
+	if w.size == 0 {
+		return stats
+	}
+
+	stats.LossPct = (1.0 - (float64(w.recv) / float64(w.size))) * 100.0
+	if w.recv > 0 {
+		idx := int(float64(w.recv-1) * 0.95)
+		stats.RttP95Ms = w.rtts.rank(idx + 1)
+		stats.RttAvgMs = w.rttSum / float64(w.recv)
+	}
+	if w.pairs > 0 {
+		stats.JitterMs = w.jitterSum / float64(w.pairs)
+	}
+	stats.RFactor, stats.MOS = eModel(stats.RttAvgMs, stats.JitterMs, stats.LossPct)
+
+	return stats
+}
+
+// live is stats plus the RTT spread, for callers outside the rules.
+func (w *sampleWindow) live() LiveStats {
+	ws := w.stats()
+	live := LiveStats{Window: w.span, Sent: w.size, Recv: w.recv, LossPct: ws.LossPct, RttP95Ms: ws.RttP95Ms, JitterMs: ws.JitterMs, MOS: ws.MOS}
+	if w.recv == 0 {
+		return live
+	}
+
+	live.RttP50Ms = w.rtts.rank(int(float64(w.recv-1)*0.5) + 1)
+	first := true
+	w.each(func(s pingSample) {
+		if !s.ok {
+			return
+		}
+		if first || s.rtt < live.RttMinMs {
+			live.RttMinMs = s.rtt
+		}
+		if s.rtt > live.RttMaxMs {
+			live.RttMaxMs = s.rtt
+		}
+		first = false
+	})
+
+	return live
+}
+
+func (w *sampleWindow) each(fn func(pingSample)) {
+	for i := 0; i < w.size; i++ {
+		fn(w.ring[(w.head+i)%len(w.ring)])
+	}
+}
+
+func (w *sampleWindow) firstBad(slowMs float64) time.Time {
+	for i := 0; i < w.size; i++ {
+		s := w.ring[(w.head+i)%len(w.ring)]
+		if s.bad(slowMs) {
+			return s.ts
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s pingSample) bad(slowMs float64) bool {
+	return !s.ok || (slowMs > 0 && s.rtt >= slowMs)
+}
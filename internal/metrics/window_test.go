@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSampleWindowMatchesSortedStats(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	span := 30 * time.Second
+	w := newSampleWindow(span, 4)
+	base := time.Unix(1000, 0)
+
+	var all []pingSample
+	for i := 0; i < 2000; i++ {
+		s := pingSample{
+			ts:  base.Add(time.Duration(i) * 100 * time.Millisecond),
+			ok:  rng.Intn(10) != 0,
+			rtt: float64(rng.Intn(400)),
+		}
+		all = append(all, s)
+		w.add(s)
+
+		var inWindow []pingSample
+		for _, prev := range all {
+			if !prev.ts.Before(s.ts.Add(-span)) {
+				inWindow = append(inWindow, prev)
+			}
+		}
+
+		want := referenceStats(inWindow)
+		got := w.stats()
+		if got.RttP95Ms != want.RttP95Ms {
+			t.Fatalf("sample %d: p95 = %v, want %v", i, got.RttP95Ms, want.RttP95Ms)
+		}
+		if diff := got.LossPct - want.LossPct; diff > 1e-9 || diff < -1e-9 {
+			t.Fatalf("sample %d: loss = %v, want %v", i, got.LossPct, want.LossPct)
+		}
+		if diff := got.RttAvgMs - want.RttAvgMs; diff > 1e-6 || diff < -1e-6 {
+			t.Fatalf("sample %d: avg = %v, want %v", i, got.RttAvgMs, want.RttAvgMs)
+		}
+		if diff := got.JitterMs - want.JitterMs; diff > 1e-6 || diff < -1e-6 {
+			t.Fatalf("sample %d: jitter = %v, want %v", i, got.JitterMs, want.JitterMs)
+		}
+	}
+}
+
+func TestRTTHistogramResolution(t *testing.T) {
+	cases := []struct {
+		rtt  float64
+		want float64
+	}{
+		{rtt: 0.34, want: 0.3},
+		{rtt: 12.3, want: 12.3},
+		{rtt: 150, want: 150},
+		{rtt: 1234, want: 1230},
+		{rtt: 25000, want: coarseLimitMs},
+	}
+
+	for _, c := range cases {
+		h := newRTTHistogram()
+		h.add(c.rtt)
+		if got := h.rank(1); got != c.want {
+			t.Fatalf("rank of %v = %v, want %v", c.rtt, got, c.want)
+		}
+	}
+}
+
+func referenceStats(samples []pingSample) WindowStats {
+	if len(samples) == 0 {
+		return WindowStats{}
+	}
+
+	var rtts []float64
+	var sum, jitterSum float64
+	for _, s := range samples {
+		if s.ok {
+			if len(rtts) > 0 {
+				jitterSum += math.Abs(s.rtt - rtts[len(rtts)-1])
+			}
+			rtts = append(rtts, s.rtt)
+			sum += s.rtt
+		}
+	}
+
+	stats := WindowStats{LossPct: (1.0 - float64(len(rtts))/float64(len(samples))) * 100.0}
+	if len(rtts) > 1 {
+		stats.JitterMs = jitterSum / float64(len(rtts)-1)
+	}
+	if len(rtts) > 0 {
+		sort.Float64s(rtts)
+		stats.RttP95Ms = rtts[int(float64(len(rtts)-1)*0.95)]
+		stats.RttAvgMs = sum / float64(len(rtts))
+	}
+
+	return stats
+}
+
+func BenchmarkProcessPing(b *testing.B) {
+	for _, windowSecs := range []int{10, 60, 600, 3600} {
+		b.Run(fmt.Sprintf("window=%ds", windowSecs), func(b *testing.B) {
+			const intervalMS = 100
+			d := NewDetector(Config{
+				Window:   time.Duration(windowSecs) * time.Second,
+				Interval: time.Duration(intervalMS) * time.Millisecond,
+			})
+			rng := rand.New(rand.NewSource(1))
+			base := time.Unix(1000, 0)
+			step := time.Duration(intervalMS) * time.Millisecond
+
+			warmup := windowSecs * 1000 / intervalMS
+			for i := 0; i < warmup; i++ {
+				d.ProcessPing("target", base.Add(time.Duration(i)*step), true, float64(5+rng.Intn(40)))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ts := base.Add(time.Duration(warmup+i) * step)
+				d.ProcessPing("target", ts, true, float64(5+rng.Intn(40)))
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+	"github.com/iaserrat/edgeprobe/internal/probe"
+)
+
+// Aggregator feeds probe results into the detector and hands every resulting
+// event to emit. It holds no goroutine or timer of its own, so the daemon can
+// drive it from channels and tickers while a replay drives it step by step.
+// recorder is optional.
+type Aggregator struct {
+	detector *metrics.Detector
+	recorder *metrics.FlightRecorder
+	emit     func(metrics.Event)
+}
+
+func NewAggregator(detector *metrics.Detector, recorder *metrics.FlightRecorder, emit func(metrics.Event)) *Aggregator {
+	return &Aggregator{detector: detector, recorder: recorder, emit: emit}
+}
+
+func (a *Aggregator) Ping(p probe.PingResult) {
+	a.forward(a.detector.ProcessPing(p.Target, p.Time, p.OK, p.RTTMs))
+	if a.recorder != nil {
+		sample := metrics.ProbeSample{Target: p.Target, TS: p.Time, Seq: p.Seq, OK: p.OK, RTTMs: p.RTTMs, Failure: p.Failure}
+		a.forward(a.recorder.Record(sample, a.detector.ActiveOutageID(p.Target), a.detector.ActiveIncidentID(p.Target)))
+	}
+}
+
+func (a *Aggregator) DNS(d probe.DNSResult) {
+	a.detector.ProcessDNS(d.Time, d.OK)
+}
+
+func (a *Aggregator) Rollup(now time.Time) {
+	a.forward(a.detector.Rollup(now))
+}
+
+// Finish writes the last partial rollup, when rollups are enabled, and closes
+// every open outage. With keepOpen the outages are left open instead, for a
+// detector snapshot to carry them over to the next start.
+func (a *Aggregator) Finish(now time.Time, rollup, keepOpen bool) {
+	if rollup {
+		a.Rollup(now)
+	}
+	if !keepOpen {
+		a.forward(a.detector.Flush(now, "shutdown"))
+	}
+}
+
+func (a *Aggregator) forward(events []metrics.Event) {
+	for _, e := range events {
+		a.emit(e)
+	}
+}
@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+	"github.com/iaserrat/edgeprobe/internal/probe"
+)
+
+// startOutage feeds a until an outage opens and returns its ID.
+func startOutage(t *testing.T, agg *Aggregator, events *[]metrics.Event, base time.Time) string {
+	t.Helper()
+
+	for i := 0; i < 10; i++ {
+		agg.Ping(probe.PingResult{Target: "a", Time: base.Add(time.Duration(i) * time.Second), OK: i < 5, RTTMs: 10})
+		for _, e := range *events {
+			if start, ok := e.(metrics.OutageStart); ok {
+				return start.OutageID
+			}
+		}
+	}
+
+	t.Fatal("expected outage to open")
+	return ""
+}
+
+func TestRestartMidOutageResumes(t *testing.T) {
+	cfg := metrics.Config{Window: 10 * time.Second, Interval: time.Second}
+	base := time.Unix(1000, 0)
+	stop := base.Add(10 * time.Second)
+
+	var events []metrics.Event
+	detector := metrics.NewDetector(cfg)
+	agg := NewAggregator(detector, nil, func(e metrics.Event) { events = append(events, e) })
+	outageID := startOutage(t, agg, &events, base)
+
+	// A graceful stop with resume_after_stop: the outage must reach the
+	// snapshot still open.
+	events = nil
+	agg.Finish(stop, false, true)
+	if len(events) != 0 {
+		t.Fatalf("finish emitted %v", events)
+	}
+	path := filepath.Join(t.TempDir(), "detector.json")
+	if err := metrics.SaveSnapshot(path, detector.Snapshot(stop)); err != nil {
+		t.Fatalf("save snapshot: %v", err)
+	}
+
+	snap, ok, err := metrics.LoadSnapshot(path)
+	if err != nil || !ok {
+		t.Fatalf("load snapshot: ok=%v err=%v", ok, err)
+	}
+	restarted := metrics.NewDetector(cfg)
+	if events := restarted.Restore(snap, []string{"a"}, stop.Add(5*time.Second), time.Minute); len(events) != 0 {
+		t.Fatalf("expected no events on resume, got %v", events)
+	}
+	if got := restarted.ActiveOutageID("a"); got != outageID {
+		t.Fatalf("active outage after restart = %q, want %q", got, outageID)
+	}
+}
+
+func TestFinishClosesOutagesWithoutSnapshot(t *testing.T) {
+	cfg := metrics.Config{Window: 10 * time.Second, Interval: time.Second}
+	base := time.Unix(1000, 0)
+
+	var events []metrics.Event
+	detector := metrics.NewDetector(cfg)
+	agg := NewAggregator(detector, nil, func(e metrics.Event) { events = append(events, e) })
+	outageID := startOutage(t, agg, &events, base)
+
+	events = nil
+	agg.Finish(base.Add(10*time.Second), false, false)
+	var summary *metrics.OutageSummary
+	for _, e := range events {
+		if evt, ok := e.(metrics.OutageSummary); ok {
+			summary = &evt
+		}
+	}
+	if summary == nil || summary.OutageID != outageID || summary.EndReason != "shutdown" {
+		t.Fatalf("expected shutdown summary for %s, got %+v", outageID, summary)
+	}
+	if got := detector.ActiveOutageID("a"); got != "" {
+		t.Fatalf("outage %s still open after finish", got)
+	}
+}
@@ -0,0 +1,390 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/iaserrat/edgeprobe/internal/availability"
+	"github.com/iaserrat/edgeprobe/internal/capture"
+	"github.com/iaserrat/edgeprobe/internal/diagnosis"
+	"github.com/iaserrat/edgeprobe/internal/logging"
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+)
+
+// LinkObserver reports the egress interface, its operstate and the default
+// gateway. *diagnosis.LinkProbe is the production implementation.
+type LinkObserver interface {
+	Observe() (string, string, string)
+}
+
+// Capturer records packets for the length of an outage.
+// *capture.Manager is the production implementation.
+type Capturer interface {
+	Start(target, outageID, incidentID string)
+	Stop(outageID string)
+}
+
+// EventObserver sees every detector event before it is logged.
+// *exporter.Exporter is the production implementation.
+type EventObserver interface {
+	ObserveEvent(metrics.Event)
+}
+
+type TraceRequest struct {
+	Target     string
+	OutageID   string
+	IncidentID string
+}
+
+// Handler turns detector events into log records and side effects. Link,
+// Traces, Captures, Availability and Observer are optional; a replay leaves
+// them nil.
+type Handler struct {
+	Logger       *logging.Logger
+	Link         LinkObserver
+	Observations *diagnosis.Store
+	Traces       chan<- TraceRequest
+	Captures     Capturer
+	Availability *availability.Tracker
+	Observer     EventObserver
+}
+
+func (h *Handler) Handle(e metrics.Event) error {
+	if h.Observer != nil {
+		h.Observer.ObserveEvent(e)
+	}
+
+	switch evt := e.(type) {
+	case metrics.IncidentStart:
+		if err := h.Logger.Emit(&logging.IncidentStart{
+			BaseEvent: logging.BaseEvent{
+				Type:       "incident_start",
+				Target:     evt.Target,
+				OutageID:   evt.OutageID,
+				IncidentID: evt.IncidentID,
+			},
+			StartTS:       evt.StartTS,
+			ImpactStartTS: evt.ImpactStartTS,
+		}); err != nil {
+			return err
+		}
+		if h.Availability != nil {
+			return h.LogAvailability(h.Availability.Start(availability.AllTargets, evt.ImpactStartTS))
+		}
+	case metrics.IncidentEnd:
+		if err := h.Logger.Emit(&logging.IncidentEnd{
+			BaseEvent: logging.BaseEvent{
+				Type:       "incident_end",
+				Target:     evt.Target,
+				OutageID:   evt.OutageID,
+				IncidentID: evt.IncidentID,
+			},
+			StartTS:       evt.StartTS,
+			EndTS:         evt.EndTS,
+			DurationMs:    evt.DurationMs,
+			ImpactStartTS: evt.ImpactStartTS,
+			ImpactEndTS:   evt.ImpactEndTS,
+			Scope:         evt.Scope,
+			Targets:       evt.Targets,
+			OutageIDs:     evt.OutageIDs,
+			TargetsTotal:  evt.TargetsTotal,
+		}); err != nil {
+			return err
+		}
+		if h.Availability != nil {
+			return h.LogAvailability(h.Availability.End(availability.AllTargets, evt.ImpactStartTS, evt.ImpactEndTS))
+		}
+	case metrics.OutageStart:
+		if err := logDegradation(h.Logger, evt); err != nil {
+			return err
+		}
+		h.observeLink(evt.OutageID)
+		if h.Traces != nil {
+			h.Traces <- TraceRequest{Target: evt.Target, OutageID: evt.OutageID, IncidentID: evt.IncidentID}
+		}
+		if h.Captures != nil {
+			h.Captures.Start(evt.Target, evt.OutageID, evt.IncidentID)
+		}
+		if h.Availability != nil {
+			return h.LogAvailability(h.Availability.Start(evt.Target, evt.ImpactStartTS))
+		}
+	case metrics.OutageEnd:
+		if h.Captures != nil {
+			h.Captures.Stop(evt.OutageID)
+		}
+		if err := logDegradation(h.Logger, evt); err != nil {
+			return err
+		}
+	case metrics.OutageSummary:
+		if err := h.Logger.Emit(&logging.OutageSummary{
+			BaseEvent: logging.BaseEvent{
+				Type:       "outage_summary",
+				Target:     evt.Target,
+				OutageID:   evt.OutageID,
+				IncidentID: evt.IncidentID,
+			},
+			StartTS:            evt.StartTS,
+			EndTS:              evt.EndTS,
+			DurationMs:         evt.DurationMs,
+			EndReason:          evt.EndReason,
+			ImpactStartTS:      evt.ImpactStartTS,
+			ImpactEndTS:        evt.ImpactEndTS,
+			ImpactDurationMs:   evt.ImpactDurationMs,
+			LossPctMax:         evt.LossPctMax,
+			RttP95MaxMs:        evt.RttP95MaxMs,
+			RttAvgMaxMs:        evt.RttAvgMaxMs,
+			MOSMin:             evt.MOSMin,
+			ConsecutiveFailMax: evt.ConsecutiveFailMax,
+			PingSent:           evt.PingSent,
+			PingRecv:           evt.PingRecv,
+			DNSErrors:          evt.DNSErrors,
+			TracerouteCount:    evt.TracerouteCount,
+			FlapCount:          evt.FlapCount,
+			WindowsMax:         toLogWindows(evt.WindowsMax),
+		}); err != nil {
+			return err
+		}
+		if err := h.logDiagnosis(evt); err != nil {
+			return err
+		}
+		if h.Availability != nil {
+			return h.LogAvailability(h.Availability.End(evt.Target, evt.ImpactStartTS, evt.ImpactEndTS))
+		}
+	case metrics.FlappingStart:
+		if err := h.Logger.Emit(&logging.FlappingStart{
+			BaseEvent: logging.BaseEvent{
+				Type:       "flapping_start",
+				Target:     evt.Target,
+				OutageID:   evt.OutageID,
+				IncidentID: evt.IncidentID,
+			},
+			StartTS: evt.StartTS,
+			Changes: evt.Changes,
+		}); err != nil {
+			return err
+		}
+	case metrics.FlappingEnd:
+		if err := h.Logger.Emit(&logging.FlappingEnd{
+			BaseEvent: logging.BaseEvent{
+				Type:       "flapping_end",
+				Target:     evt.Target,
+				OutageID:   evt.OutageID,
+				IncidentID: evt.IncidentID,
+			},
+			StartTS:   evt.StartTS,
+			EndTS:     evt.EndTS,
+			FlapCount: evt.FlapCount,
+		}); err != nil {
+			return err
+		}
+	case metrics.Anomaly:
+		if err := h.Logger.Emit(&logging.Anomaly{
+			BaseEvent: logging.BaseEvent{
+				Type:       "anomaly",
+				Target:     evt.Target,
+				OutageID:   evt.OutageID,
+				IncidentID: evt.IncidentID,
+			},
+			Metric:         evt.Metric,
+			Value:          evt.Value,
+			BaselineMean:   evt.BaselineMean,
+			BaselineStdDev: evt.BaselineStdDev,
+			ZScore:         evt.ZScore,
+			HourOfWeek:     evt.HourOfWeek,
+			Samples:        evt.Samples,
+		}); err != nil {
+			return err
+		}
+	case metrics.ProbeSample:
+		var rtt *float64
+		if evt.OK {
+			rtt = &evt.RTTMs
+		}
+		if err := h.Logger.Emit(&logging.ProbeSample{
+			BaseEvent: logging.BaseEvent{
+				Type:       "probe_sample",
+				Target:     evt.Target,
+				OutageID:   evt.OutageID,
+				IncidentID: evt.IncidentID,
+			},
+			Phase:    evt.Phase,
+			SampleTS: evt.TS,
+			Seq:      evt.Seq,
+			OK:       evt.OK,
+			RttMs:    rtt,
+			Failure:  evt.Failure,
+		}); err != nil {
+			return err
+		}
+	case metrics.IntervalStats:
+		if err := h.Logger.Emit(&logging.IntervalStats{
+			BaseEvent: logging.BaseEvent{
+				Type:       "interval_stats",
+				Target:     evt.Target,
+				OutageID:   evt.OutageID,
+				IncidentID: evt.IncidentID,
+			},
+			StartTS:       evt.StartTS,
+			EndTS:         evt.EndTS,
+			Sent:          evt.Sent,
+			Recv:          evt.Recv,
+			LossPct:       evt.LossPct,
+			RttMinMs:      evt.RttMinMs,
+			RttAvgMs:      evt.RttAvgMs,
+			RttP50Ms:      evt.RttP50Ms,
+			RttP95Ms:      evt.RttP95Ms,
+			RttMaxMs:      evt.RttMaxMs,
+			JitterMs:      evt.JitterMs,
+			RFactor:       evt.RFactor,
+			MOS:           evt.MOS,
+			DNSSent:       evt.DNSSent,
+			DNSOK:         evt.DNSOK,
+			DNSSuccessPct: evt.DNSSuccessPct,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LogAvailability writes the reports and persists the tracker so downtime
+// already accounted for survives a crash.
+func (h *Handler) LogAvailability(reports []availability.Report) error {
+	for _, r := range reports {
+		rec := &logging.AvailabilityReport{
+			BaseEvent:              logging.BaseEvent{Type: "availability_report", Target: r.Key},
+			Period:                 r.Period,
+			PeriodStartTS:          r.StartTS,
+			PeriodEndTS:            r.EndTS,
+			AsOfTS:                 r.AsOfTS,
+			Final:                  r.Final,
+			DowntimeMs:             r.DowntimeMs,
+			AvailabilityPct:        r.AvailabilityPct,
+			Outages:                r.Outages,
+			SLAPct:                 r.SLAPct,
+			AllowedDowntimeMs:      r.AllowedDowntimeMs,
+			ErrorBudgetRemainingMs: r.ErrorBudgetRemainingMs,
+		}
+		if r.SLAPct > 0 {
+			met := r.SLAMet
+			rec.SLAMet = &met
+		}
+		if err := h.Logger.Emit(rec); err != nil {
+			return err
+		}
+	}
+
+	return h.Availability.Save()
+}
+
+// LogCapture writes the pcap_saved record for a finished capture. It is
+// called from the capture's goroutine.
+func (h *Handler) LogCapture(r capture.Result) error {
+	return h.Logger.Emit(&logging.PcapSaved{
+		BaseEvent: logging.BaseEvent{
+			Type:       "pcap_saved",
+			Target:     r.Target,
+			OutageID:   r.OutageID,
+			IncidentID: r.IncidentID,
+		},
+		Path:       r.Path,
+		Interface:  r.Interface,
+		Filter:     r.Filter,
+		Packets:    r.Packets,
+		Bytes:      r.Bytes,
+		StartTS:    r.StartTS,
+		EndTS:      r.EndTS,
+		StopReason: r.StopReason,
+		Err:        r.Err,
+	})
+}
+
+func toLogWindows(windows []metrics.WindowStats) []logging.WindowStats {
+	out := make([]logging.WindowStats, 0, len(windows))
+	for _, w := range windows {
+		out = append(out, logging.WindowStats{
+			WindowSecs: int(w.Window.Seconds()),
+			Samples:    w.Samples,
+			LossPct:    w.LossPct,
+			RttP95Ms:   w.RttP95Ms,
+			RttAvgMs:   w.RttAvgMs,
+			JitterMs:   w.JitterMs,
+			RFactor:    w.RFactor,
+			MOS:        w.MOS,
+		})
+	}
+	return out
+}
+
+func logDegradation(logger *logging.Logger, e metrics.Event) error {
+	var rec *logging.DegradationRecord
+	switch evt := e.(type) {
+	case metrics.OutageStart:
+		impactStart := evt.ImpactStartTS
+		rec = &logging.DegradationRecord{
+			BaseEvent:           logging.BaseEvent{Type: "degradation_start", Target: evt.Target, OutageID: evt.OutageID, IncidentID: evt.IncidentID},
+			ImpactStartTS:       &impactStart,
+			Reason:              evt.Reason,
+			LossPct:             evt.LossPct,
+			RttP95Ms:            evt.RttP95Ms,
+			MOS:                 evt.MOS,
+			ConsecutiveFailures: evt.ConsecutiveFailures,
+			Windows:             toLogWindows(evt.Windows),
+		}
+	case metrics.OutageEnd:
+		impactStart, impactEnd := evt.ImpactStartTS, evt.ImpactEndTS
+		rec = &logging.DegradationRecord{
+			BaseEvent:           logging.BaseEvent{Type: "degradation_end", Target: evt.Target, OutageID: evt.OutageID, IncidentID: evt.IncidentID},
+			ImpactStartTS:       &impactStart,
+			ImpactEndTS:         &impactEnd,
+			Reason:              evt.Reason,
+			LossPct:             evt.LossPct,
+			RttP95Ms:            evt.RttP95Ms,
+			MOS:                 evt.MOS,
+			ConsecutiveFailures: evt.ConsecutiveFailures,
+			Windows:             toLogWindows(evt.Windows),
+		}
+	default:
+		return fmt.Errorf("unexpected degradation event %s", e.Type())
+	}
+
+	return logger.Emit(rec)
+}
+
+func (h *Handler) observeLink(outageID string) {
+	if h.Link == nil {
+		return
+	}
+	iface, linkState, gateway := h.Link.Observe()
+	h.Observations.RecordLink(outageID, iface, linkState, gateway)
+}
+
+func (h *Handler) logDiagnosis(summary metrics.OutageSummary) error {
+	h.observeLink(summary.OutageID)
+	obs := h.Observations.Take(summary.OutageID)
+
+	diag := diagnosis.Classify(diagnosis.Evidence{
+		Target:    summary.Target,
+		Interface: obs.Interface,
+		LinkState: obs.LinkState,
+		Gateway:   obs.Gateway,
+		Trace:     obs.Trace,
+		Reason:    summary.StartReason,
+		PingSent:  summary.PingSent,
+		PingRecv:  summary.PingRecv,
+		DNSErrors: summary.DNSErrors,
+		Scope:     summary.IncidentScope,
+	})
+
+	return h.Logger.Emit(&logging.Diagnosis{
+		BaseEvent: logging.BaseEvent{
+			Type:       "diagnosis",
+			Target:     summary.Target,
+			OutageID:   summary.OutageID,
+			IncidentID: summary.IncidentID,
+		},
+		FaultDomain: diag.Domain,
+		Confidence:  diag.Confidence,
+		Evidence:    diag.Evidence,
+	})
+}
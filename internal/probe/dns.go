@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/iaserrat/edgeprobe/internal/clock"
 	"github.com/miekg/dns"
 )
 
@@ -13,6 +14,7 @@ type DNSConfig struct {
 	Timeout   time.Duration
 	Queries   []string
 	Resolvers []string
+	Clock     clock.Clock
 }
 
 func RunDNS(ctx context.Context, cfg DNSConfig, out chan<- DNSResult) error {
@@ -21,17 +23,22 @@ func RunDNS(ctx context.Context, cfg DNSConfig, out chan<- DNSResult) error {
 		return fmt.Errorf("dns queries or resolvers empty")
 	}
 
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
 	idx := 0
 	resIdx := 0
-	next := time.Now()
+	next := clk.Now()
 
 	for {
-		timer := time.NewTimer(time.Until(next))
+		timer := clk.NewTimer(next.Sub(clk.Now()))
 		select {
 		case <-ctx.Done():
 			timer.Stop()
 			return nil
-		case <-timer.C:
+		case <-timer.C():
 		}
 
 		query := cfg.Queries[idx%len(cfg.Queries)]
@@ -42,9 +49,12 @@ func RunDNS(ctx context.Context, cfg DNSConfig, out chan<- DNSResult) error {
 		msg := new(dns.Msg)
 		msg.SetQuestion(dns.Fqdn(query), dns.TypeA)
 
-		_, _, err := client.Exchange(msg, resolver)
-		ok := err == nil
-		out <- DNSResult{Time: time.Now().UTC(), OK: ok}
+		_, rtt, err := client.Exchange(msg, resolver)
+		res := DNSResult{Time: clk.Now().UTC(), Resolver: resolver, Query: query, OK: err == nil}
+		if res.OK {
+			res.LatencyMs = float64(rtt.Microseconds()) / 1000
+		}
+		out <- res
 
 		next = next.Add(cfg.Interval)
 	}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/iaserrat/edgeprobe/internal/clock"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 )
@@ -15,6 +16,7 @@ import (
 type PingConfig struct {
 	Interval time.Duration
 	Timeout  time.Duration
+	Clock    clock.Clock
 }
 
 func RunPing(ctx context.Context, target string, cfg PingConfig, out chan<- PingResult) error {
@@ -32,18 +34,25 @@ func RunPing(ctx context.Context, target string, cfg PingConfig, out chan<- Ping
 	}
 	defer conn.Close()
 
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
 	id := os.Getpid() & 0xffff
 	seq := 0
 	payload := []byte("edgeprobe")
-	next := time.Now()
+	next := clk.Now()
 
+	// Scheduling and result timestamps follow clk; the socket deadline and
+	// the RTT itself are measured on the wall clock the kernel uses.
 	for {
-		timer := time.NewTimer(time.Until(next))
+		timer := clk.NewTimer(next.Sub(clk.Now()))
 		select {
 		case <-ctx.Done():
 			timer.Stop()
 			return nil
-		case <-timer.C:
+		case <-timer.C():
 		}
 
 		seq++
@@ -64,7 +73,7 @@ func RunPing(ctx context.Context, target string, cfg PingConfig, out chan<- Ping
 
 		start := time.Now()
 		if _, err := conn.WriteTo(b, ipAddr); err != nil {
-			out <- PingResult{Target: target, Time: time.Now().UTC(), OK: false}
+			out <- PingResult{Target: target, Time: clk.Now().UTC(), Seq: seq, OK: false, Failure: FailSend}
 			next = next.Add(cfg.Interval)
 			continue
 		}
@@ -75,28 +84,44 @@ func RunPing(ctx context.Context, target string, cfg PingConfig, out chan<- Ping
 		elapsed := time.Since(start)
 
 		if err != nil {
-			out <- PingResult{Target: target, Time: time.Now().UTC(), OK: false}
+			failure := FailRecv
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				failure = FailTimeout
+			}
+			out <- PingResult{Target: target, Time: clk.Now().UTC(), Seq: seq, OK: false, Failure: failure}
 			next = next.Add(cfg.Interval)
 			continue
 		}
 
 		recv, err := icmp.ParseMessage(ipv4.ICMPTypeEchoReply.Protocol(), buf[:n])
 		if err != nil {
-			out <- PingResult{Target: target, Time: time.Now().UTC(), OK: false}
+			out <- PingResult{Target: target, Time: clk.Now().UTC(), Seq: seq, OK: false, Failure: FailMalformed}
 			next = next.Add(cfg.Interval)
 			continue
 		}
 
 		if recv.Type == ipv4.ICMPTypeEchoReply {
 			if echo, ok := recv.Body.(*icmp.Echo); ok && echo.ID == id {
-				out <- PingResult{Target: target, Time: time.Now().UTC(), OK: true, RTTMs: float64(elapsed.Milliseconds())}
+				out <- PingResult{Target: target, Time: clk.Now().UTC(), Seq: seq, OK: true, RTTMs: float64(elapsed.Milliseconds())}
 			} else {
-				out <- PingResult{Target: target, Time: time.Now().UTC(), OK: false}
+				out <- PingResult{Target: target, Time: clk.Now().UTC(), Seq: seq, OK: false, Failure: FailForeign}
 			}
 		} else {
-			out <- PingResult{Target: target, Time: time.Now().UTC(), OK: false}
+			out <- PingResult{Target: target, Time: clk.Now().UTC(), Seq: seq, OK: false, Failure: replyFailure(recv.Type)}
 		}
 
 		next = next.Add(cfg.Interval)
 	}
 }
+
+func replyFailure(t icmp.Type) string {
+	switch t {
+	case ipv4.ICMPTypeDestinationUnreachable:
+		return FailUnreachable
+	case ipv4.ICMPTypeTimeExceeded:
+		return FailTTLExceeded
+	default:
+		return FailUnexpected
+	}
+}
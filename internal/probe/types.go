@@ -2,14 +2,31 @@ package probe
 
 import "time"
 
+// Failure classes for a ping that got no usable echo reply.
+const (
+	FailTimeout     = "timeout"
+	FailSend        = "send_error"
+	FailRecv        = "recv_error"
+	FailMalformed   = "malformed_reply"
+	FailForeign     = "foreign_reply"
+	FailUnreachable = "unreachable"
+	FailTTLExceeded = "ttl_exceeded"
+	FailUnexpected  = "unexpected_reply"
+)
+
 type PingResult struct {
-	Target string
-	Time   time.Time
-	OK     bool
-	RTTMs  float64
+	Target  string
+	Time    time.Time
+	Seq     int
+	OK      bool
+	RTTMs   float64
+	Failure string
 }
 
 type DNSResult struct {
-	Time time.Time
-	OK   bool
+	Time      time.Time
+	Resolver  string
+	Query     string
+	OK        bool
+	LatencyMs float64
 }
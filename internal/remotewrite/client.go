@@ -0,0 +1,166 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/iaserrat/edgeprobe/internal/clock"
+	"github.com/iaserrat/edgeprobe/internal/exporter"
+	"github.com/iaserrat/edgeprobe/internal/spool"
+)
+
+// Config describes the remote_write endpoint and how hard to push.
+type Config struct {
+	URL        string
+	Username   string
+	Password   string
+	Labels     map[string]string
+	Interval   time.Duration
+	Timeout    time.Duration
+	BatchSize  int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	UserAgent  string
+	Clock      clock.Clock
+}
+
+// Client appends a scrape of the exporter to the WAL every Interval and
+// ships the WAL to the endpoint oldest first. Scrapes taken while the
+// endpoint is unreachable stay in the WAL, across restarts, until delivered.
+type Client struct {
+	cfg    Config
+	clk    clock.Clock
+	wal    *spool.Spool
+	gather func() []exporter.Sample
+	http   *http.Client
+}
+
+// errPermanent marks a batch the endpoint will never accept; it is dropped
+// rather than retried.
+var errPermanent = errors.New("rejected by endpoint")
+
+func New(cfg Config, wal *spool.Spool, gather func() []exporter.Sample) *Client {
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real{}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+
+	return &Client{cfg: cfg, clk: clk, wal: wal, gather: gather, http: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// Run pushes until ctx is cancelled. A last scrape goes to the WAL on the
+// way out so it is sent after the next start.
+func (c *Client) Run(ctx context.Context) {
+	ticker := c.clk.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	var retryC <-chan time.Time
+	var backoff time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			c.scrape()
+			return
+		case <-ticker.C():
+			c.scrape()
+			if retryC != nil {
+				continue
+			}
+		case <-retryC:
+			retryC = nil
+		}
+
+		if err := c.Flush(ctx); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			backoff = c.nextBackoff(backoff)
+			fmt.Fprintf(os.Stderr, "remote_write: %v (retrying in %s)\n", err, backoff)
+			timer := c.clk.NewTimer(backoff)
+			retryC = timer.C()
+			continue
+		}
+		backoff = 0
+	}
+}
+
+func (c *Client) scrape() {
+	rec := encodeWriteRequest(c.gather(), c.cfg.Labels, c.clk.Now().UnixMilli())
+	if err := c.wal.Append(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "remote_write wal: %v\n", err)
+	}
+}
+
+func (c *Client) nextBackoff(prev time.Duration) time.Duration {
+	if prev == 0 {
+		return c.cfg.MinBackoff
+	}
+	return min(2*prev, c.cfg.MaxBackoff)
+}
+
+// Flush sends every pending scrape, BatchSize scrapes per request. It stops
+// at the first batch that may succeed later and leaves it in the WAL.
+func (c *Client) Flush(ctx context.Context) error {
+	for c.wal.Pending() {
+		recs, cur, err := c.wal.Read(c.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(recs) == 0 {
+			return nil
+		}
+
+		err = c.send(ctx, bytes.Join(recs, nil))
+		if errors.Is(err, errPermanent) {
+			fmt.Fprintf(os.Stderr, "remote_write: dropping %d scrapes: %v\n", len(recs), err)
+		} else if err != nil {
+			return err
+		}
+		if err := c.wal.Ack(cur); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if c.cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", c.cfg.UserAgent)
+	}
+	if c.cfg.Username != "" {
+		req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5:
+		return fmt.Errorf("endpoint returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	default:
+		return fmt.Errorf("%w: %s: %s", errPermanent, resp.Status, bytes.TrimSpace(msg))
+	}
+}
@@ -0,0 +1,88 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/iaserrat/edgeprobe/internal/exporter"
+)
+
+// Protobuf wire types and the remote_write message fields that are used:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+//
+// Repeated fields concatenate, so encoded WriteRequests can be joined into a
+// batch without decoding them.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// encodeWriteRequest encodes one scrape: every sample at timestamp tsMs,
+// with extra labels added where the sample does not already set them.
+func encodeWriteRequest(samples []exporter.Sample, extra map[string]string, tsMs int64) []byte {
+	var out, series, msg []byte
+	for _, s := range samples {
+		labels := seriesLabels(s, extra)
+
+		series = series[:0]
+		for _, l := range labels {
+			msg = appendString(msg[:0], 1, l.Name)
+			msg = appendString(msg, 2, l.Value)
+			series = appendBytes(series, 1, msg)
+		}
+		msg = appendTag(msg[:0], 1, wireFixed64)
+		msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(s.Value))
+		msg = appendTag(msg, 2, wireVarint)
+		msg = binary.AppendUvarint(msg, uint64(tsMs))
+		series = appendBytes(series, 2, msg)
+
+		out = appendBytes(out, 1, series)
+	}
+	return out
+}
+
+// seriesLabels returns __name__, the sample's labels and the extra labels,
+// sorted by name as receivers require.
+func seriesLabels(s exporter.Sample, extra map[string]string) []exporter.Label {
+	labels := make([]exporter.Label, 0, len(s.Labels)+len(extra)+1)
+	labels = append(labels, exporter.Label{Name: "__name__", Value: s.Name})
+	labels = append(labels, s.Labels...)
+	for name, value := range extra {
+		if !hasLabel(s.Labels, name) {
+			labels = append(labels, exporter.Label{Name: name, Value: value})
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+func hasLabel(labels []exporter.Label, name string) bool {
+	for _, l := range labels {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, field int, msg []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
@@ -0,0 +1,182 @@
+package remotewrite
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/iaserrat/edgeprobe/internal/clock"
+	"github.com/iaserrat/edgeprobe/internal/exporter"
+	"github.com/iaserrat/edgeprobe/internal/spool"
+)
+
+type point struct {
+	series string
+	value  float64
+	ts     int64
+}
+
+// decodeWriteRequest flattens a WriteRequest into one point per sample, with
+// the series written as its labels in order. It assumes well-formed input.
+func decodeWriteRequest(b []byte) []point {
+	var points []point
+	for _, ts := range fields(b, 1) {
+		var labels []string
+		for _, l := range fields(ts, 1) {
+			kv := fields(l, 1, 2)
+			labels = append(labels, fmt.Sprintf("%s=%q", kv[0], kv[1]))
+		}
+		for _, s := range fields(ts, 2) {
+			value := math.Float64frombits(binary.LittleEndian.Uint64(s[1:9]))
+			stamp, _ := binary.Uvarint(s[10:])
+			points = append(points, point{series: strings.Join(labels, ","), value: value, ts: int64(stamp)})
+		}
+	}
+	return points
+}
+
+// fields returns the length-delimited fields of msg with the given numbers,
+// in order.
+func fields(msg []byte, nums ...int) [][]byte {
+	var out [][]byte
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		msg = msg[n:]
+		size, n := binary.Uvarint(msg)
+		body := msg[n : n+int(size)]
+		msg = msg[n+int(size):]
+		for _, num := range nums {
+			if int(tag>>3) == num {
+				out = append(out, body)
+			}
+		}
+	}
+	return out
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	samples := []exporter.Sample{
+		{Name: "edgeprobe_loss_ratio", Labels: []exporter.Label{{Name: "target", Value: "1.1.1.1"}}, Value: 0.25},
+		{Name: "edgeprobe_build_info", Labels: []exporter.Label{{Name: "version", Value: "dev"}, {Name: "instance", Value: "own"}}, Value: 1},
+	}
+	b := encodeWriteRequest(samples, map[string]string{"instance": "pi", "job": "edgeprobe"}, 1700000000123)
+
+	got := decodeWriteRequest(b)
+	want := []point{
+		{`__name__="edgeprobe_loss_ratio",instance="pi",job="edgeprobe",target="1.1.1.1"`, 0.25, 1700000000123},
+		{`__name__="edgeprobe_build_info",instance="own",job="edgeprobe",version="dev"`, 1, 1700000000123},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("decoded = %v, want %v", got, want)
+	}
+}
+
+// referenceWriteRequest is the same scrape marshalled by the upstream
+// protobuf runtime (google.golang.org/protobuf, deterministic) from the
+// remote_write WriteRequest schema.
+const referenceWriteRequest = "0a510a180a085f5f6e616d655f5f120c6564676570726f62655f75700a100a036a6f6212096564676570726f62650a110a067461726765741207312e312e312e31121009000000000000f03f1080d095ffbc310a420a1c0a085f5f6e616d655f5f12106564676570726f62655f7274745f6d730a100a036a6f6212096564676570726f626512100900000000000029401080d095ffbc31"
+
+func TestWriteRequestMatchesReference(t *testing.T) {
+	samples := []exporter.Sample{
+		{Name: "edgeprobe_up", Labels: []exporter.Label{{Name: "target", Value: "1.1.1.1"}}, Value: 1},
+		{Name: "edgeprobe_rtt_ms", Value: 12.5},
+	}
+	got := hex.EncodeToString(encodeWriteRequest(samples, map[string]string{"job": "edgeprobe"}, 1700000000000))
+	if got != referenceWriteRequest {
+		t.Fatalf("encoded = %s\nwant      %s", got, referenceWriteRequest)
+	}
+}
+
+type standIn struct {
+	mu       sync.Mutex
+	statuses []int
+	points   []point
+	requests int
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("X-Prometheus-Remote-Write-Version") == "" {
+		http.Error(w, "bad headers", http.StatusBadRequest)
+		return
+	}
+	status := http.StatusNoContent
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	if status/100 != 2 {
+		http.Error(w, "unavailable", status)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.points = append(s.points, decodeWriteRequest(raw)...)
+	w.WriteHeader(status)
+}
+
+func TestFlushRetriesAndKeepsOrder(t *testing.T) {
+	srv := &standIn{statuses: []int{http.StatusServiceUnavailable, http.StatusBadRequest}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	wal, err := spool.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	defer wal.Close()
+
+	clk := clock.NewVirtual(time.Unix(1700000000, 0))
+	value := 0.0
+	gather := func() []exporter.Sample {
+		value++
+		return []exporter.Sample{{Name: "edgeprobe_ping_sent_total", Value: value}}
+	}
+	c := New(Config{URL: ts.URL, BatchSize: 2, Timeout: time.Second, Clock: clk}, wal, gather)
+
+	for i := 0; i < 5; i++ {
+		c.scrape()
+		clk.Advance(15 * time.Second)
+	}
+
+	// The outage: the endpoint is down and every scrape stays in the WAL.
+	if err := c.Flush(context.Background()); err == nil || !wal.Pending() {
+		t.Fatalf("flush during 503 = %v, pending %v", err, wal.Pending())
+	}
+
+	// Then a batch is rejected outright and dropped, and the rest go through.
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if wal.Pending() {
+		t.Fatalf("scrapes left in the WAL")
+	}
+
+	var values []float64
+	for _, p := range srv.points {
+		values = append(values, p.value)
+	}
+	if !sort.Float64sAreSorted(values) || fmt.Sprint(values) != "[3 4 5]" {
+		t.Fatalf("delivered = %v, want [3 4 5]", values)
+	}
+	if srv.points[0].ts != clk.Now().Add(-45*time.Second).UnixMilli() {
+		t.Fatalf("timestamp = %d, want the scrape time", srv.points[0].ts)
+	}
+}
@@ -0,0 +1,112 @@
+package replay
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+	"github.com/iaserrat/edgeprobe/internal/diagnosis"
+	"github.com/iaserrat/edgeprobe/internal/logging"
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+	"github.com/iaserrat/edgeprobe/internal/pipeline"
+	"github.com/iaserrat/edgeprobe/internal/probe"
+)
+
+// Input is a recorded or synthetic probe stream. The two slices need not be
+// sorted; Run interleaves them by timestamp.
+type Input struct {
+	Pings []probe.PingResult
+	DNS   []probe.DNSResult
+}
+
+// Options configure a replay. Clock must be the clock the Logger was built
+// with, so record timestamps follow the replayed samples.
+type Options struct {
+	Clock       *clock.Virtual
+	Logger      *logging.Logger
+	Detector    metrics.Config
+	Recorder    *metrics.FlightRecorder
+	RollupEvery time.Duration
+}
+
+type sample struct {
+	ts   time.Time
+	ping *probe.PingResult
+	dns  *probe.DNSResult
+}
+
+// Run feeds in through a fresh detector and the same event handler the
+// daemon uses, advancing the virtual clock to each sample before it is
+// processed. Traceroutes, link observations and availability are not run.
+// It returns once every record is in the log.
+func Run(in Input, opts Options) error {
+	if opts.Clock == nil || opts.Logger == nil {
+		return fmt.Errorf("replay needs a virtual clock and a logger")
+	}
+
+	handler := &pipeline.Handler{Logger: opts.Logger, Observations: diagnosis.NewStore()}
+	var handleErr error
+	agg := pipeline.NewAggregator(metrics.NewDetector(opts.Detector), opts.Recorder, func(e metrics.Event) {
+		if handleErr == nil {
+			handleErr = handler.Handle(e)
+		}
+	})
+
+	drive(in, opts.Clock, opts.RollupEvery, agg, func() bool { return handleErr == nil })
+	opts.Logger.Flush()
+	return handleErr
+}
+
+// Outages replays in through a detector built from cfg and returns the
+// outages it closes. Outages still open when the samples run out end at the
+// last sample with reason "shutdown".
+func Outages(in Input, cfg metrics.Config) []metrics.OutageSummary {
+	var outages []metrics.OutageSummary
+	agg := pipeline.NewAggregator(metrics.NewDetector(cfg), nil, func(e metrics.Event) {
+		if o, ok := e.(metrics.OutageSummary); ok {
+			outages = append(outages, o)
+		}
+	})
+
+	drive(in, clock.NewVirtual(time.Time{}), 0, agg, func() bool { return true })
+	return outages
+}
+
+// drive steps agg through the merged samples, firing rollups on their
+// boundaries, until the samples run out or proceed reports false.
+func drive(in Input, clk *clock.Virtual, rollupEvery time.Duration, agg *pipeline.Aggregator, proceed func() bool) {
+	samples := make([]sample, 0, len(in.Pings)+len(in.DNS))
+	for i := range in.Pings {
+		samples = append(samples, sample{ts: in.Pings[i].Time, ping: &in.Pings[i]})
+	}
+	for i := range in.DNS {
+		samples = append(samples, sample{ts: in.DNS[i].Time, dns: &in.DNS[i]})
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].ts.Before(samples[j].ts) })
+
+	var nextRollup time.Time
+	if rollupEvery > 0 && len(samples) > 0 {
+		nextRollup = samples[0].ts.Add(rollupEvery)
+	}
+
+	for _, s := range samples {
+		for !nextRollup.IsZero() && !nextRollup.After(s.ts) {
+			clk.Set(nextRollup)
+			agg.Rollup(nextRollup)
+			nextRollup = nextRollup.Add(rollupEvery)
+		}
+
+		clk.Set(s.ts)
+		if s.ping != nil {
+			agg.Ping(*s.ping)
+		} else {
+			agg.DNS(*s.dns)
+		}
+		if !proceed() {
+			return
+		}
+	}
+
+	agg.Finish(clk.Now().UTC(), rollupEvery > 0, false)
+}
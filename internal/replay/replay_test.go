@@ -0,0 +1,151 @@
+package replay
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/clock"
+	"github.com/iaserrat/edgeprobe/internal/logging"
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+	"github.com/iaserrat/edgeprobe/internal/probe"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+var replayStart = time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+
+func at(i int) time.Time { return replayStart.Add(time.Duration(i) * time.Second) }
+
+func pings(target string, n int, fn func(i int) (bool, float64)) []probe.PingResult {
+	out := make([]probe.PingResult, 0, n)
+	for i := 0; i < n; i++ {
+		ok, rtt := fn(i)
+		p := probe.PingResult{Target: target, Time: at(i), Seq: i + 1, OK: ok, RTTMs: rtt}
+		if !ok {
+			p.RTTMs, p.Failure = 0, probe.FailTimeout
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func TestReplayGolden(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          Input
+		recorder    *metrics.FlightConfig
+		rollupEvery time.Duration
+	}{
+		{
+			name: "loss_outage",
+			in: Input{
+				Pings: pings("1.1.1.1", 240, func(i int) (bool, float64) {
+					return i < 60 || i >= 70, 12
+				}),
+				DNS: []probe.DNSResult{
+					{Time: at(30), OK: true},
+					{Time: at(65), OK: false},
+					{Time: at(90), OK: true},
+				},
+			},
+		},
+		{
+			name: "latency_rollup",
+			in: Input{
+				Pings: pings("8.8.8.8", 240, func(i int) (bool, float64) {
+					if i >= 40 && i < 70 {
+						return true, 400
+					}
+					return true, 20
+				}),
+			},
+			rollupEvery: time.Minute,
+		},
+		{
+			name: "flight_recorder",
+			in: Input{
+				Pings: pings("1.1.1.1", 100, func(i int) (bool, float64) {
+					return i < 20 || i >= 25, float64(10 + i%3)
+				}),
+			},
+			recorder: &metrics.FlightConfig{PreRoll: 5 * time.Second, PostRoll: 3 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			clk := clock.NewVirtual(replayStart)
+			logger := logging.NewWriter(nopCloser{&buf}, logging.Config{
+				ToolName:    "edgeprobe",
+				ToolVersion: "test",
+				HostID:      "replay",
+				Clock:       clk,
+				ClockSource: logging.ClockReplay,
+			})
+
+			opts := Options{
+				Clock:       clk,
+				Logger:      logger,
+				Detector:    metrics.Config{Window: 60 * time.Second, Interval: time.Second},
+				RollupEvery: tt.rollupEvery,
+			}
+			if tt.recorder != nil {
+				opts.Detector.Window = 20 * time.Second
+				opts.Recorder = metrics.NewFlightRecorder(*tt.recorder)
+			}
+			err := Run(tt.in, opts)
+			if err != nil {
+				t.Fatalf("replay: %v", err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatalf("write golden: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("replay output differs from %s (rerun with -update to accept):\n%s", golden, buf.String())
+			}
+		})
+	}
+}
+
+func TestReplayIsDeterministic(t *testing.T) {
+	in := Input{Pings: pings("1.1.1.1", 120, func(i int) (bool, float64) {
+		return i%7 != 0, float64(10 + i%5)
+	})}
+
+	run := func() string {
+		var buf bytes.Buffer
+		clk := clock.NewVirtual(replayStart)
+		logger := logging.NewWriter(nopCloser{&buf}, logging.Config{
+			ToolName:    "edgeprobe",
+			ToolVersion: "test",
+			HostID:      "replay",
+			Clock:       clk,
+			ClockSource: logging.ClockReplay,
+		})
+		if err := Run(in, Options{Clock: clk, Logger: logger, Detector: metrics.Config{Window: 30 * time.Second, Interval: time.Second}, RollupEvery: 20 * time.Second}); err != nil {
+			t.Fatalf("replay: %v", err)
+		}
+		return buf.String()
+	}
+
+	if first, second := run(), run(); first != second {
+		t.Fatalf("two replays of the same input differ:\n%s\n---\n%s", first, second)
+	}
+}
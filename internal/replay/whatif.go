@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"sort"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+)
+
+// Span is one outage's impact period, either logged by the daemon or found
+// by a what-if replay.
+type Span struct {
+	Target   string
+	OutageID string
+	Start    time.Time
+	End      time.Time
+	Open     bool
+}
+
+// SummarySpans turns replayed outage summaries into spans. An outage the
+// replay had to flush is marked open, since the samples ran out first.
+func SummarySpans(outages []metrics.OutageSummary) []Span {
+	spans := make([]Span, 0, len(outages))
+	for _, o := range outages {
+		spans = append(spans, Span{
+			Target:   o.Target,
+			OutageID: o.OutageID,
+			Start:    o.ImpactStartTS,
+			End:      o.ImpactEndTS,
+			Open:     o.EndReason == "shutdown",
+		})
+	}
+	return spans
+}
+
+func (s Span) Duration() time.Duration { return s.End.Sub(s.Start) }
+
+func (s Span) overlaps(o Span) bool {
+	return s.Target == o.Target && !s.Start.After(o.End) && !o.Start.After(s.End)
+}
+
+// Change pairs a logged outage with the what-if outage that overlaps it.
+// Either side is nil when only one run saw the outage.
+type Change struct {
+	Target string
+	Actual *Span
+	WhatIf *Span
+}
+
+// Delta is how much longer the what-if outage lasts than the logged one; an
+// outage only one side saw counts in full.
+func (c Change) Delta() time.Duration {
+	var d time.Duration
+	if c.WhatIf != nil {
+		d += c.WhatIf.Duration()
+	}
+	if c.Actual != nil {
+		d -= c.Actual.Duration()
+	}
+	return d
+}
+
+// Diff matches each logged outage with the earliest unmatched what-if outage
+// of the same target that overlaps it. Outages that split or merge under the
+// new rules therefore show up as a match plus one-sided entries.
+func Diff(actual, whatIf []Span) []Change {
+	actual = sortedSpans(actual)
+	whatIf = sortedSpans(whatIf)
+
+	used := make([]bool, len(whatIf))
+	var changes []Change
+	for i := range actual {
+		c := Change{Target: actual[i].Target, Actual: &actual[i]}
+		for j := range whatIf {
+			if !used[j] && actual[i].overlaps(whatIf[j]) {
+				used[j] = true
+				c.WhatIf = &whatIf[j]
+				break
+			}
+		}
+		changes = append(changes, c)
+	}
+	for j := range whatIf {
+		if !used[j] {
+			changes = append(changes, Change{Target: whatIf[j].Target, WhatIf: &whatIf[j]})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Target != changes[j].Target {
+			return changes[i].Target < changes[j].Target
+		}
+		return changes[i].start().Before(changes[j].start())
+	})
+	return changes
+}
+
+func (c Change) start() time.Time {
+	if c.Actual != nil && (c.WhatIf == nil || c.Actual.Start.Before(c.WhatIf.Start)) {
+		return c.Actual.Start
+	}
+	return c.WhatIf.Start
+}
+
+func sortedSpans(spans []Span) []Span {
+	out := append([]Span(nil), spans...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
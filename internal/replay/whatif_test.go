@@ -0,0 +1,62 @@
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/metrics"
+)
+
+func TestDiffMatchesOverlappingOutages(t *testing.T) {
+	span := func(target string, from, to int) Span {
+		return Span{Target: target, Start: at(from), End: at(to)}
+	}
+	actual := []Span{span("a", 100, 110), span("a", 500, 520), span("b", 100, 130)}
+	whatIf := []Span{span("a", 98, 125), span("a", 300, 305), span("b", 200, 210)}
+
+	changes := Diff(actual, whatIf)
+	if len(changes) != 5 {
+		t.Fatalf("got %d changes, want 5: %+v", len(changes), changes)
+	}
+
+	first := changes[0]
+	if first.Actual == nil || first.WhatIf == nil || first.Delta() != 17*time.Second {
+		t.Fatalf("first change = %+v, want a match 17s longer", first)
+	}
+	if c := changes[1]; c.Actual != nil || c.WhatIf == nil || c.Delta() != 5*time.Second {
+		t.Fatalf("second change = %+v, want a new 5s outage", c)
+	}
+	if c := changes[2]; c.Actual == nil || c.WhatIf != nil || c.Delta() != -20*time.Second {
+		t.Fatalf("third change = %+v, want a dropped 20s outage", c)
+	}
+	if changes[3].Target != "b" || changes[3].WhatIf != nil || changes[4].Actual != nil {
+		t.Fatalf("target b outages should not match: %+v %+v", changes[3], changes[4])
+	}
+}
+
+func TestOutagesUnderStricterRules(t *testing.T) {
+	in := Input{Pings: pings("a", 300, func(i int) (bool, float64) {
+		if i >= 100 && i < 130 {
+			return true, 180
+		}
+		return true, 20
+	})}
+
+	loose := Outages(in, metrics.Config{Window: 60 * time.Second, Interval: time.Second})
+	if len(loose) != 0 {
+		t.Fatalf("default rules opened %d outages, want none", len(loose))
+	}
+
+	strict := Outages(in, metrics.Config{
+		Window:   60 * time.Second,
+		Interval: time.Second,
+		Rules:    []metrics.Rule{{Name: "slow", Metric: metrics.MetricRttP95Ms, Window: 30 * time.Second, Threshold: 150}},
+	})
+	if len(strict) != 1 {
+		t.Fatalf("strict rules opened %d outages, want 1", len(strict))
+	}
+	spans := SummarySpans(strict)
+	if spans[0].Open || !spans[0].Start.Equal(at(100)) {
+		t.Fatalf("span = %+v, want a closed outage starting at the first slow ping", spans[0])
+	}
+}
@@ -0,0 +1,143 @@
+package samples
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/probe"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	KindPing = "ping"
+	KindDNS  = "dns"
+)
+
+// Record is one raw probe result. The log is kept apart from the event log
+// so it can be rotated on its own and replayed with other thresholds.
+type Record struct {
+	Kind     string    `json:"kind"`
+	TS       time.Time `json:"ts"`
+	Target   string    `json:"target,omitempty"`
+	Resolver string    `json:"resolver,omitempty"`
+	Query    string    `json:"query,omitempty"`
+	Seq      int       `json:"seq,omitempty"`
+	OK       bool      `json:"ok"`
+	RTTMs    float64   `json:"rtt_ms,omitempty"`
+	Failure  string    `json:"failure,omitempty"`
+}
+
+type Writer struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// Open appends to samples.jsonl in dir, rotating it by size.
+func Open(dir string, maxMB, maxFiles int) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create sample log dir: %w", err)
+	}
+
+	return NewWriter(&lumberjack.Logger{
+		Filename:   filepath.Join(dir, "samples.jsonl"),
+		MaxSize:    maxMB,
+		MaxBackups: maxFiles,
+		Compress:   false,
+	}), nil
+}
+
+func NewWriter(w io.WriteCloser) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Ping(p probe.PingResult) error {
+	return w.write(Record{Kind: KindPing, TS: p.Time, Target: p.Target, Seq: p.Seq, OK: p.OK, RTTMs: p.RTTMs, Failure: p.Failure})
+}
+
+func (w *Writer) DNS(d probe.DNSResult) error {
+	return w.write(Record{Kind: KindDNS, TS: d.Time, Resolver: d.Resolver, Query: d.Query, OK: d.OK, RTTMs: d.LatencyMs})
+}
+
+func (w *Writer) write(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal sample: %w", err)
+	}
+	b = append(b, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err = w.w.Write(b)
+	return err
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Close()
+}
+
+// Read loads the samples in dir, rotated files included, that fall in
+// [from, to). A zero bound is open. Results come back sorted by time.
+func Read(dir string, from, to time.Time) ([]probe.PingResult, []probe.DNSResult, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "samples*.jsonl"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("list sample logs: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no sample logs in %s", dir)
+	}
+
+	var pings []probe.PingResult
+	var dns []probe.DNSResult
+	for _, path := range paths {
+		if err := scan(path, func(rec Record) {
+			if (!from.IsZero() && rec.TS.Before(from)) || (!to.IsZero() && !rec.TS.Before(to)) {
+				return
+			}
+			switch rec.Kind {
+			case KindPing:
+				pings = append(pings, probe.PingResult{Target: rec.Target, Time: rec.TS, Seq: rec.Seq, OK: rec.OK, RTTMs: rec.RTTMs, Failure: rec.Failure})
+			case KindDNS:
+				dns = append(dns, probe.DNSResult{Time: rec.TS, Resolver: rec.Resolver, Query: rec.Query, OK: rec.OK, LatencyMs: rec.RTTMs})
+			}
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sort.SliceStable(pings, func(i, j int) bool { return pings[i].Time.Before(pings[j].Time) })
+	sort.SliceStable(dns, func(i, j int) bool { return dns[i].Time.Before(dns[j].Time) })
+	return pings, dns, nil
+}
+
+func scan(path string, fn func(Record)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open sample log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		fn(rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	return nil
+}
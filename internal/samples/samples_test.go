@@ -0,0 +1,57 @@
+package samples
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/probe"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	w, err := Open(dir, 1, 1)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		ts := base.Add(time.Duration(i) * time.Second)
+		if err := w.Ping(probe.PingResult{Target: "1.1.1.1", Time: ts, Seq: i + 1, OK: i != 2, RTTMs: 12.5}); err != nil {
+			t.Fatalf("write ping: %v", err)
+		}
+	}
+	if err := w.DNS(probe.DNSResult{Time: base.Add(3 * time.Second), OK: false}); err != nil {
+		t.Fatalf("write dns: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// A rotated file from earlier is read too.
+	old := `{"kind":"ping","ts":"2024-03-04T11:59:59Z","target":"1.1.1.1","ok":true,"rtt_ms":9}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "samples-2024-03-04T11-59-59.000.jsonl"), []byte(old), 0o644); err != nil {
+		t.Fatalf("write rotated: %v", err)
+	}
+
+	pings, dns, err := Read(dir, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(pings) != 6 || len(dns) != 1 {
+		t.Fatalf("read %d pings and %d dns, want 6 and 1", len(pings), len(dns))
+	}
+	if pings[0].RTTMs != 9 || pings[3].OK || pings[1].RTTMs != 12.5 || pings[3].Seq != 3 {
+		t.Fatalf("samples out of order or mangled: %+v", pings)
+	}
+
+	pings, dns, err = Read(dir, base.Add(time.Second), base.Add(3*time.Second))
+	if err != nil {
+		t.Fatalf("read range: %v", err)
+	}
+	if len(pings) != 2 || len(dns) != 0 {
+		t.Fatalf("range read %d pings and %d dns, want 2 and 0", len(pings), len(dns))
+	}
+}
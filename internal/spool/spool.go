@@ -0,0 +1,411 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iaserrat/edgeprobe/internal/statefile"
+)
+
+// segmentBytes is the size at which a new segment file is started. Segments
+// are the unit of deletion once acknowledged or when the spool is full.
+var segmentBytes int64 = 1 << 20
+
+// syncInterval bounds how often Append syncs the open segment. Sealed
+// segments are synced once, when the next one starts.
+var syncInterval = time.Second
+
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+	headerLen  = 8
+	maxRecord  = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Spool is a durable FIFO of records kept in segment files under one
+// directory. Records survive restarts until acknowledged; a crash can lose
+// those appended since the last sync, and the torn record it may leave at
+// the end of the newest segment is cut off on Open.
+type Spool struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	segments []uint64
+	sizes    map[uint64]int64
+	w        *os.File
+	dirty    bool
+	lastSync time.Time
+	cursor   Cursor
+	dropped  int64
+}
+
+// Cursor is a read position: a segment and a byte offset within it.
+type Cursor struct {
+	Segment uint64
+	Offset  int64
+}
+
+// Open opens or creates the spool in dir. Once the segments exceed maxBytes
+// the oldest are deleted, acknowledged or not; 0 means unbounded.
+func Open(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+
+	s := &Spool{dir: dir, maxBytes: maxBytes, sizes: make(map[uint64]int64)}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(p), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, id)
+		s.sizes[id] = info.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	if len(s.segments) == 0 {
+		if err := s.startSegment(1); err != nil {
+			return nil, err
+		}
+	} else {
+		last := s.segments[len(s.segments)-1]
+		if err := s.repair(last); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(s.segmentPath(last), os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open spool segment: %w", err)
+		}
+		s.w = f
+	}
+
+	s.cursor = s.readCursor()
+	return s, nil
+}
+
+// Append adds one record. It reaches the disk by the next sync, at most
+// syncInterval after the last one while appends go on; Sync and Close force
+// it there.
+func (s *Spool) Append(rec []byte) error {
+	if len(rec) > maxRecord {
+		return fmt.Errorf("spool record of %d bytes is too large", len(rec))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.segments[len(s.segments)-1]
+	if s.sizes[last] >= segmentBytes {
+		if err := s.startSegment(last + 1); err != nil {
+			return err
+		}
+		last++
+	}
+
+	buf := make([]byte, headerLen+len(rec))
+	binary.LittleEndian.PutUint32(buf, uint32(len(rec)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(rec, crcTable))
+	copy(buf[headerLen:], rec)
+	if _, err := s.w.Write(buf); err != nil {
+		return fmt.Errorf("write spool: %w", err)
+	}
+	s.sizes[last] += int64(len(buf))
+	s.dirty = true
+	if time.Since(s.lastSync) >= syncInterval {
+		if err := s.sync(); err != nil {
+			return err
+		}
+	}
+
+	s.enforceLimit()
+	return nil
+}
+
+// Sync flushes appended records to disk.
+func (s *Spool) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sync()
+}
+
+func (s *Spool) sync() error {
+	if !s.dirty {
+		return nil
+	}
+	if err := s.w.Sync(); err != nil {
+		return fmt.Errorf("sync spool: %w", err)
+	}
+	s.dirty = false
+	s.lastSync = time.Now()
+	return nil
+}
+
+// Read returns up to max unacknowledged records, oldest first, and the
+// cursor to pass to Ack once they have been delivered.
+func (s *Spool) Read(max int) ([][]byte, Cursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var recs [][]byte
+	cur := s.cursor
+	for len(recs) < max {
+		if s.sizes[cur.Segment] <= cur.Offset {
+			next, ok := s.after(cur.Segment)
+			if !ok {
+				break
+			}
+			cur = Cursor{Segment: next}
+			continue
+		}
+
+		got, off, err := s.readSegment(cur, max-len(recs))
+		if err != nil {
+			return nil, s.cursor, err
+		}
+		recs = append(recs, got...)
+		if off == cur.Offset {
+			// Nothing readable before the segment's end: skip the damage.
+			off = s.sizes[cur.Segment]
+		}
+		cur.Offset = off
+	}
+
+	return recs, cur, nil
+}
+
+// Ack marks everything before c as delivered and deletes spent segments.
+func (s *Spool) Ack(c Cursor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.Segment < s.cursor.Segment || (c.Segment == s.cursor.Segment && c.Offset < s.cursor.Offset) {
+		// The records were dropped by enforceLimit while being delivered.
+		return nil
+	}
+	if next, ok := s.after(c.Segment); ok && c.Offset >= s.sizes[c.Segment] {
+		c = Cursor{Segment: next}
+	}
+	s.cursor = c
+
+	for len(s.segments) > 1 && s.segments[0] < c.Segment {
+		s.removeOldest()
+	}
+	return s.writeCursor()
+}
+
+// Pending reports whether unacknowledged records remain.
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.after(s.cursor.Segment); ok {
+		return true
+	}
+	return s.cursor.Offset < s.sizes[s.cursor.Segment]
+}
+
+// Dropped counts segments deleted unread because the spool was full.
+func (s *Spool) Dropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.sync()
+	if cerr := s.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+func (s *Spool) startSegment(id uint64) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("create spool segment: %w", err)
+	}
+	if s.w != nil {
+		if err := s.sync(); err != nil {
+			f.Close()
+			return err
+		}
+		s.w.Close()
+	}
+	s.w = f
+	s.segments = append(s.segments, id)
+	s.sizes[id] = 0
+	return nil
+}
+
+func (s *Spool) after(id uint64) (uint64, bool) {
+	for _, seg := range s.segments {
+		if seg > id {
+			return seg, true
+		}
+	}
+	return 0, false
+}
+
+// enforceLimit drops the oldest segments, never the one being written,
+// until the spool fits in maxBytes. The cursor is only rewritten when it had
+// to move past a dropped segment.
+func (s *Spool) enforceLimit() {
+	if s.maxBytes <= 0 {
+		return
+	}
+
+	var total int64
+	for _, id := range s.segments {
+		total += s.sizes[id]
+	}
+	moved := false
+	for total > s.maxBytes && len(s.segments) > 1 {
+		oldest := s.segments[0]
+		total -= s.sizes[oldest]
+		if s.cursor.Segment <= oldest {
+			s.dropped++
+			s.cursor = Cursor{Segment: s.segments[1]}
+			moved = true
+		}
+		s.removeOldest()
+	}
+	if moved {
+		_ = s.writeCursor()
+	}
+}
+
+func (s *Spool) removeOldest() {
+	oldest := s.segments[0]
+	os.Remove(s.segmentPath(oldest))
+	delete(s.sizes, oldest)
+	s.segments = s.segments[1:]
+}
+
+// readSegment reads up to max whole records starting at c and returns them
+// with the offset just past the last one.
+func (s *Spool) readSegment(c Cursor, max int) ([][]byte, int64, error) {
+	f, err := os.Open(s.segmentPath(c.Segment))
+	if err != nil {
+		return nil, c.Offset, fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(c.Offset, io.SeekStart); err != nil {
+		return nil, c.Offset, err
+	}
+	r := bufio.NewReader(io.LimitReader(f, s.sizes[c.Segment]-c.Offset))
+
+	var recs [][]byte
+	off := c.Offset
+	for len(recs) < max {
+		rec, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		recs = append(recs, rec)
+		off += int64(headerLen + len(rec))
+	}
+	return recs, off, nil
+}
+
+var errCorrupt = errors.New("corrupt spool record")
+
+func readRecord(r io.Reader) ([]byte, error) {
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[:])
+	if n > maxRecord {
+		return nil, errCorrupt
+	}
+	rec := make([]byte, n)
+	if _, err := io.ReadFull(r, rec); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(rec, crcTable) != binary.LittleEndian.Uint32(hdr[4:]) {
+		return nil, errCorrupt
+	}
+	return rec, nil
+}
+
+// repair truncates a segment after its last intact record.
+func (s *Spool) repair(id uint64) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var good int64
+	for {
+		rec, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		good += int64(headerLen + len(rec))
+	}
+	if good < s.sizes[id] {
+		if err := f.Truncate(good); err != nil {
+			return fmt.Errorf("repair spool segment: %w", err)
+		}
+		s.sizes[id] = good
+	}
+	return nil
+}
+
+// readCursor falls back to the oldest record when the cursor is missing or
+// points at a segment that no longer exists.
+func (s *Spool) readCursor() Cursor {
+	oldest := Cursor{Segment: s.segments[0]}
+	b, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if err != nil {
+		return oldest
+	}
+	var c Cursor
+	if _, err := fmt.Sscanf(string(b), "%d %d", &c.Segment, &c.Offset); err != nil {
+		return oldest
+	}
+	size, ok := s.sizes[c.Segment]
+	if !ok || c.Offset > size {
+		return oldest
+	}
+	return c
+}
+
+func (s *Spool) writeCursor() error {
+	b := []byte(fmt.Sprintf("%d %d\n", s.cursor.Segment, s.cursor.Offset))
+	return statefile.Write(filepath.Join(s.dir, cursorFile), b, "spool cursor", false)
+}
@@ -0,0 +1,143 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readAll(t *testing.T, s *Spool, max int) ([]string, Cursor) {
+	t.Helper()
+	recs, cur, err := s.Read(max)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	out := make([]string, len(recs))
+	for i, r := range recs {
+		out[i] = string(r)
+	}
+	return out, cur
+}
+
+func TestAppendReadAckAcrossRestart(t *testing.T) {
+	defer func(n int64) { segmentBytes = n }(segmentBytes)
+	segmentBytes = 32
+
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := s.Append([]byte(fmt.Sprintf("rec-%d", i))); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	got, cur := readAll(t, s, 4)
+	if fmt.Sprint(got) != "[rec-0 rec-1 rec-2 rec-3]" {
+		t.Fatalf("first batch = %v", got)
+	}
+	if err := s.Ack(cur); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	s.Close()
+
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	got, cur = readAll(t, s, 100)
+	if fmt.Sprint(got) != "[rec-4 rec-5 rec-6 rec-7 rec-8 rec-9]" {
+		t.Fatalf("after restart = %v", got)
+	}
+	if !s.Pending() {
+		t.Fatalf("records pending before ack")
+	}
+	if err := s.Ack(cur); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if s.Pending() {
+		t.Fatalf("records pending after ack")
+	}
+	if segs, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segs) != 1 {
+		t.Fatalf("spent segments kept: %v", segs)
+	}
+}
+
+func TestTornTailIsRepaired(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	s.Append([]byte("intact"))
+	s.Close()
+
+	path := filepath.Join(dir, fmt.Sprintf("%020d.seg", 1))
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	f.Write([]byte{20, 0, 0, 0, 1, 2, 3, 4, 'x'})
+	f.Close()
+
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	s.Append([]byte("after"))
+	if got, _ := readAll(t, s, 10); fmt.Sprint(got) != "[intact after]" {
+		t.Fatalf("records = %v", got)
+	}
+}
+
+func TestLimitDropsOldest(t *testing.T) {
+	defer func(n int64) { segmentBytes = n }(segmentBytes)
+	segmentBytes = 16
+
+	s, err := Open(t.TempDir(), 48)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+	for i := 0; i < 10; i++ {
+		s.Append([]byte(fmt.Sprintf("record-%d", i)))
+	}
+
+	got, _ := readAll(t, s, 100)
+	if len(got) == 0 || len(got) >= 10 || got[len(got)-1] != "record-9" {
+		t.Fatalf("records = %v, want the newest few", got)
+	}
+	if s.Dropped() == 0 {
+		t.Fatalf("no drops counted")
+	}
+}
+
+func TestCursorOnlyRewrittenOnDrop(t *testing.T) {
+	defer func(n int64) { segmentBytes = n }(segmentBytes)
+	segmentBytes = 32
+
+	dir := t.TempDir()
+	s, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+	for i := 0; i < 10; i++ {
+		if err := s.Append([]byte(fmt.Sprintf("rec-%d", i))); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cursor")); !os.IsNotExist(err) {
+		t.Fatalf("cursor written without a drop: %v", err)
+	}
+
+	s.maxBytes = 64
+	if err := s.Append([]byte("rec-10")); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cursor")); err != nil || s.Dropped() == 0 {
+		t.Fatalf("cursor after drop: %v, dropped %d", err, s.Dropped())
+	}
+}
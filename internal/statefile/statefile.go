@@ -0,0 +1,44 @@
+// Package statefile replaces the small JSON files edgeprobe keeps its state
+// in.
+package statefile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Write replaces path with b through a temporary file and a rename, so a
+// reader sees the old content or the new, never a mix. With sync the new
+// content is on disk before the rename. Without it a crash can lose the
+// update, which suits files rewritten often enough that the next write
+// makes up for it. what names the file in errors.
+func Write(path string, b []byte, what string, sync bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", what, err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", what, err)
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("sync %s: %w", what, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", what, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace %s: %w", what, err)
+	}
+
+	return nil
+}
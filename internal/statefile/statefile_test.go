@@ -0,0 +1,27 @@
+package statefile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteReplaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "x.json")
+
+	for _, sync := range []bool{true, false} {
+		want := "synced"
+		if !sync {
+			want = "unsynced"
+		}
+		if err := Write(path, []byte(want), "x", sync); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Fatalf("content = %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+}
@@ -24,13 +24,19 @@ type Hop struct {
 	RttMs float64
 }
 
+// Result is one traceroute. DestIP is the address traceroute resolved the
+// target to, taken from its header line.
 type Result struct {
+	DestIP   string
 	Hops     []Hop
 	PathHash string
 	Err      string
 }
 
-var hopLine = regexp.MustCompile(`^\s*(\d+)\s+(.+)$`)
+var (
+	hopLine    = regexp.MustCompile(`^\s*(\d+)\s+(.+)$`)
+	headerLine = regexp.MustCompile(`^traceroute to \S+ \(([^)]+)\)`)
+)
 
 func Run(ctx context.Context, target string, cfg Config) Result {
 	args := []string{"-n", "-m", strconv.Itoa(cfg.MaxHops), "-w", fmt.Sprintf("%.0f", cfg.Timeout.Seconds()), target}
@@ -42,22 +48,26 @@ func Run(ctx context.Context, target string, cfg Config) Result {
 		if len(out) == 0 {
 			return res
 		}
-		hops := parseOutput(string(out))
-		res.Hops = hops
-		res.PathHash = hashPath(hops)
+		res.DestIP, res.Hops = parseOutput(string(out))
+		res.PathHash = hashPath(res.Hops)
 		return res
 	}
 
-	hops := parseOutput(string(out))
-	return Result{Hops: hops, PathHash: hashPath(hops)}
+	dest, hops := parseOutput(string(out))
+	return Result{DestIP: dest, Hops: hops, PathHash: hashPath(hops)}
 }
 
-func parseOutput(out string) []Hop {
+func parseOutput(out string) (string, []Hop) {
 	scanner := bufio.NewScanner(strings.NewReader(out))
+	var dest string
 	var hops []Hop
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
+		if m := headerLine.FindStringSubmatch(line); m != nil {
+			dest = m[1]
+			continue
+		}
 		if line == "" || strings.HasPrefix(line, "traceroute") {
 			continue
 		}
@@ -74,7 +84,7 @@ func parseOutput(out string) []Hop {
 		hops = append(hops, Hop{TTL: ttl, IP: ip, RttMs: rtt})
 	}
 
-	return hops
+	return dest, hops
 }
 
 func parseHop(rest string) (string, float64) {
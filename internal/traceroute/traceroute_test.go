@@ -0,0 +1,22 @@
+package traceroute
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOutput(t *testing.T) {
+	out := `traceroute to example.com (93.184.216.34), 30 hops max, 60 byte packets
+ 1  192.168.1.1  1.234 ms  1.100 ms  1.050 ms
+ 2  * * *
+ 3  93.184.216.34  12.500 ms  12.400 ms  12.300 ms
+`
+	dest, hops := parseOutput(out)
+	if dest != "93.184.216.34" {
+		t.Fatalf("dest = %q", dest)
+	}
+	want := []Hop{{TTL: 1, IP: "192.168.1.1", RttMs: 1.234}, {TTL: 2}, {TTL: 3, IP: "93.184.216.34", RttMs: 12.5}}
+	if !reflect.DeepEqual(hops, want) {
+		t.Fatalf("hops = %+v, want %+v", hops, want)
+	}
+}
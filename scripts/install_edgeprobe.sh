@@ -5,6 +5,7 @@ PREFIX="${PREFIX:-/usr/local}"
 BINDIR="${BINDIR:-${PREFIX}/bin}"
 CONFIG_DIR="${CONFIG_DIR:-/etc/edgeprobe}"
 LOG_DIR="${LOG_DIR:-/var/log/edgeprobe}"
+STATE_DIR="${STATE_DIR:-/var/lib/edgeprobe}"
 SYSTEMD_DIR="${SYSTEMD_DIR:-/etc/systemd/system}"
 
 repo_root() {
@@ -36,6 +37,7 @@ else
 fi
 
 install -d "${LOG_DIR}"
+install -d "${STATE_DIR}"
 
 install -m 644 "${ROOT_DIR}/scripts/edgeprobe.service" "${SYSTEMD_DIR}/edgeprobe.service"
 